@@ -8,11 +8,11 @@ import (
 func main() {
 	data := &dfg.DockerfileData{
 		Stages: []dfg.Stage{
-			// Stage 1 - Builder Image
+			// Stage 1 - Builder Image, the name is rendered as the AS alias of the FROM instruction
 			// An instruction is just an interface, so you can pass custom structs as well
-			[]dfg.Instruction{
+			{Name: "builder", Instructions: []dfg.Instruction{
 				dfg.From{
-					Image: "golang:1.7.3", As: "builder",
+					Image: "golang:1.7.3",
 				},
 				dfg.User{
 					User: "ozan",
@@ -29,11 +29,11 @@ func main() {
 				dfg.RunCommand{
 					Params: []string{"CGO_ENABLED=0", "GOOS=linux", "go", "build", "-a", "-installsuffix", "cgo", "-o", "app", "."},
 				},
-			},
+			}},
 			// Stage 2 - Final Image
-			[]dfg.Instruction{
+			{Name: "final", Instructions: []dfg.Instruction{
 				dfg.From{
-					Image: "alpine:latest", As: "final",
+					Image: "alpine:latest",
 				},
 				dfg.RunCommand{
 					Params: []string{"apk", "--no-cache", "add", "ca-certificates"},
@@ -50,7 +50,7 @@ func main() {
 				dfg.Cmd{
 					Params: []string{"./app"},
 				},
-			},
+			}},
 		},
 	}
 	tmpl := dfg.NewDockerfileTemplate(data)
@@ -14,6 +14,7 @@ func NewDfgCommand() *cobra.Command {
 
 	cmds.ResetFlags()
 	cmds.AddCommand(NewCmdGenerate())
+	cmds.AddCommand(NewCmdImport())
 
 	return cmds
 }
@@ -1,15 +1,35 @@
 package cmd
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
 	dfg "github.com/ozankasikci/dockerfile-generator"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 const (
 	// YAMLFileInput specifies that the input channel will be a yaml file, this is the default
 	YAMLFileInput = "yaml-file"
+
+	// JSONFileInput specifies that the input channel will be a json file
+	JSONFileInput = "json-file"
+
+	// TOMLFileInput specifies that the input channel will be a toml file
+	TOMLFileInput = "toml-file"
+
+	// StdinInput is the input path that makes dfg read the config from stdin
+	StdinInput = "-"
+
+	// utf8BOM is the byte order mark some editors write at the start of a file
+	utf8BOM = "\xef\xbb\xbf"
 )
 
 type cmdGenerateConfig struct {
@@ -18,6 +38,12 @@ type cmdGenerateConfig struct {
 	inputType   string
 	stdout      bool
 	targetField string
+	target      string
+	scriptForm  string
+	platforms   []string
+	set         []string
+	varFile     string
+	overlays    []string
 }
 
 // NewCmdGenerate generates a command that is responsible for generating a Dockerfile output
@@ -28,41 +54,185 @@ func NewCmdGenerate() *cobra.Command {
 		Use:   "generate",
 		Short: "Generates a Dockerfile based on input",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			switch cfg.inputType {
-			case YAMLFileInput:
-			default:
-				if err := generateFromYAMLFile(cfg); err != nil {
-					return err
-				}
-			}
+			// flags are valid at this point, printing the usage wouldn't help with input errors
+			cmd.SilenceUsage = true
 
-			return nil
+			return reportConfigErrors(cmd.ErrOrStderr(), inputName(cfg.input), generate(cfg, cmd.InOrStdin(), cmd.ErrOrStderr()))
 		},
 	}
 
-	cmd.PersistentFlags().StringVarP(&cfg.input, "input", "i", "", "Input path")
+	cmd.PersistentFlags().StringVarP(&cfg.input, "input", "i", "", "Input path, - reads from stdin")
 	cmd.PersistentFlags().StringVarP(&cfg.output, "out", "o", "", "Output file path")
 	cmd.PersistentFlags().BoolVar(&cfg.stdout, "stdout", false, "When true, output will be redirected to stdout")
-	cmd.PersistentFlags().StringVarP(&cfg.inputType, "type", "t", "", "Input type (yaml-file)")
+	cmd.PersistentFlags().StringVarP(&cfg.inputType, "type", "t", "", "Input type (yaml-file, json-file, toml-file), detected from the file extension or the content when omitted")
 	cmd.PersistentFlags().StringVar(&cfg.targetField, "target-field", "", "Identifies which key-value pair should be used in the file")
+	cmd.PersistentFlags().StringVar(&cfg.target, "target", "", "Only generates the given stage and the stages it depends on")
+	cmd.PersistentFlags().StringVar(&cfg.scriptForm, "script-form", "", "Default form of RUN scripts and COPY contents (heredoc, chained), chained works with the classic builder")
+	cmd.PersistentFlags().StringSliceVar(&cfg.platforms, "platforms", nil, "Target platforms of a multi-platform build, e.g. linux/amd64,linux/arm64, the builder stages cross-compile for them")
+	cmd.PersistentFlags().StringArrayVar(&cfg.set, "set", nil, "Sets a variable referenced as ${{ .vars.<name> }}, e.g. --set goVersion=1.13, overrides the vars of the input and the var file")
+	cmd.PersistentFlags().StringVar(&cfg.varFile, "var-file", "", "YAML or JSON file of variables, e.g. goVersion: \"1.13\", overrides the vars of the input")
+	cmd.PersistentFlags().StringArrayVar(&cfg.overlays, "overlay", nil, "Config file merged over the input, e.g. its stages, vars and patches, can be repeated and is applied in order")
 
 	return cmd
 }
 
-func generateFromYAMLFile(cfg *cmdGenerateConfig) error {
-	var outputTarget io.Writer
-	var data *dfg.DockerfileData
+// inputName returns the name of the input used in error messages
+func inputName(input string) string {
+	if input == StdinInput {
+		return "<stdin>"
+	}
+
+	return input
+}
+
+func generate(cfg *cmdGenerateConfig, stdin io.Reader, stderr io.Writer) error {
+	var content []byte
 	var err error
 
-	if cfg.targetField == "" {
-		data, err = dfg.NewDockerFileDataFromYamlFile(cfg.input)
+	if cfg.input == StdinInput {
+		content, err = ioutil.ReadAll(stdin)
 	} else {
-		data, err = dfg.NewDockerFileDataFromYamlField(cfg.input, cfg.targetField)
+		content, err = ioutil.ReadFile(cfg.input)
+	}
+	if err != nil {
+		return fmt.Errorf("Can't read input: %v", err)
+	}
+
+	// the byte order mark is removed once for the type detection and every decoder
+	content = bytes.TrimPrefix(content, []byte(utf8BOM))
+
+	inputType := cfg.inputType
+	if inputType == "" {
+		inputType = detectInputType(cfg.input, content)
 	}
+
+	vars, err := readVars(cfg)
 	if err != nil {
 		return err
 	}
 
+	var data *dfg.DockerfileData
+	r := bytes.NewReader(content)
+	opts := dfg.DecodeOptions{Filename: inputName(cfg.input), Vars: vars, Overlays: cfg.overlays}
+
+	switch inputType {
+	case YAMLFileInput:
+		data, err = dfg.NewDockerFileDataFromYamlReaderWithOptions(r, cfg.targetField, opts)
+	case JSONFileInput:
+		data, err = dfg.NewDockerFileDataFromJSONReaderWithOptions(r, cfg.targetField, opts)
+	case TOMLFileInput:
+		data, err = dfg.NewDockerFileDataFromTOMLReaderWithOptions(r, cfg.targetField, opts)
+	default:
+		return fmt.Errorf("Unknown input type %s", inputType)
+	}
+
+	if err == nil && cfg.scriptForm != "" {
+		data.ScriptForm = dfg.ScriptForm(cfg.scriptForm)
+	}
+
+	if err == nil && len(cfg.platforms) > 0 {
+		data.Platforms = cfg.platforms
+	}
+
+	// instructions and stage references are checked before rendering
+	if err == nil {
+		err = data.Validate()
+	}
+	if err == nil && cfg.target != "" {
+		data, err = data.Prune(cfg.target)
+	}
+
+	// the stage errors of Validate and Prune have no position, the decoding ones can come from an included file
+	if errs, ok := err.(dfg.ConfigErrors); ok {
+		for _, configErr := range errs {
+			if configErr.Filename == "" {
+				configErr.Filename = inputName(cfg.input)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, warning := range data.Warnings() {
+		warning.Filename = inputName(cfg.input)
+		fmt.Fprintf(stderr, "warning: %s\n", warning)
+	}
+
+	return renderDockerfile(cfg, data)
+}
+
+// readVars returns the variables of the --var-file overridden by the --set ones
+func readVars(cfg *cmdGenerateConfig) (map[string]string, error) {
+	vars := map[string]string{}
+
+	if cfg.varFile != "" {
+		content, err := ioutil.ReadFile(cfg.varFile)
+		if err != nil {
+			return nil, fmt.Errorf("Can't read var file: %v", err)
+		}
+
+		// yaml is a superset of json, the values are decoded as they are written, e.g. 1.10 isn't read as 1.1
+		if err := yaml.Unmarshal(content, &vars); err != nil {
+			return nil, fmt.Errorf("Invalid var file %s, expected a map of names to values: %v", cfg.varFile, err)
+		}
+
+		for name := range vars {
+			if err := dfg.ValidateVarName(name); err != nil {
+				return nil, fmt.Errorf("Invalid var file %s: %v", cfg.varFile, err)
+			}
+		}
+	}
+
+	for _, set := range cfg.set {
+		parts := strings.SplitN(set, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid --set %q, expected <name>=<value>", set)
+		}
+
+		if err := dfg.ValidateVarName(parts[0]); err != nil {
+			return nil, err
+		}
+		vars[parts[0]] = parts[1]
+	}
+
+	return vars, nil
+}
+
+// detectInputType picks the input type from the file extension, the content is sniffed when the extension is unknown,
+// e.g. for stdin. YAML is the fallback since it is the default input type, --type should be used if the guess is wrong.
+func detectInputType(filename string, content []byte) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return YAMLFileInput
+	case ".json":
+		return JSONFileInput
+	case ".toml":
+		return TOMLFileInput
+	}
+
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) == 0 {
+		return YAMLFileInput
+	}
+
+	// a flow mapping like `{stages: {...}}` starts with a brace too but it's yaml
+	if json.Valid(trimmed) {
+		return JSONFileInput
+	}
+
+	// a yaml mapping like `stages:` isn't valid toml, while `key = value` pairs and [tables] aren't yaml mappings
+	var tomlData map[string]interface{}
+	if _, err := toml.Decode(string(trimmed), &tomlData); err == nil {
+		return TOMLFileInput
+	}
+
+	return YAMLFileInput
+}
+
+func renderDockerfile(cfg *cmdGenerateConfig, data *dfg.DockerfileData) error {
+	var outputTarget io.Writer
+
 	tmpl := dfg.NewDockerfileTemplate(data)
 
 	if cfg.stdout {
@@ -76,10 +246,28 @@ func generateFromYAMLFile(cfg *cmdGenerateConfig) error {
 		defer file.Close()
 	}
 
-	err = tmpl.Render(outputTarget)
+	err := tmpl.Render(outputTarget)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// reportConfigErrors prints config errors in the form of compiler diagnostics, other errors are returned as is
+func reportConfigErrors(w io.Writer, input string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	errs, ok := err.(dfg.ConfigErrors)
+	if !ok {
+		return err
+	}
+
+	for _, configErr := range errs {
+		fmt.Fprintf(w, "%s\n", configErr)
+	}
+
+	return fmt.Errorf("%d error(s) found in %s", len(errs), input)
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestGenerateByteOrderMark generates a Dockerfile from inputs that start with a UTF-8 byte order mark
+func TestGenerateByteOrderMark(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dfg-generate")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	inputs := map[string]string{
+		"dfg.json": `{"stages": {"final": [{"from": {"image": "alpine"}}]}}`,
+		"dfg.yaml": "stages:\n  final:\n    - from:\n        image: alpine\n",
+		"dfg.toml": "[[stages.final]]\nfrom = { image = \"alpine\" }\n",
+		"dfg":      `{"stages": {"final": [{"from": {"image": "alpine"}}]}}`,
+	}
+
+	for filename, content := range inputs {
+		input := filepath.Join(dir, filename)
+		assert.NoError(t, ioutil.WriteFile(input, []byte(utf8BOM+content), 0644))
+
+		output := input + ".Dockerfile"
+		err := generate(&cmdGenerateConfig{input: input, output: output}, nil, &bytes.Buffer{})
+		if !assert.NoError(t, err, filename) {
+			continue
+		}
+
+		generated, err := ioutil.ReadFile(output)
+		assert.NoError(t, err)
+		assert.Equal(t, "FROM alpine as final\n\n", string(generated), filename)
+	}
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	dfg "github.com/ozankasikci/dockerfile-generator"
+	"github.com/ozankasikci/dockerfile-generator/parser"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+type cmdImportConfig struct {
+	input       string
+	output      string
+	stdout      bool
+	targetField string
+}
+
+// NewCmdImport generates a command that converts an existing Dockerfile into a YAML input
+func NewCmdImport() *cobra.Command {
+	cfg := &cmdImportConfig{}
+
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Converts a Dockerfile into a YAML input",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// flags are valid at this point, printing the usage wouldn't help with input errors
+			cmd.SilenceUsage = true
+
+			return reportConfigErrors(cmd.ErrOrStderr(), cfg.input, importFromDockerfile(cfg))
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&cfg.input, "input", "i", "", "Dockerfile path")
+	cmd.PersistentFlags().StringVarP(&cfg.output, "out", "o", "", "Output file path")
+	cmd.PersistentFlags().BoolVar(&cfg.stdout, "stdout", false, "When true, output will be redirected to stdout")
+	cmd.PersistentFlags().StringVar(&cfg.targetField, "target-field", "", "Identifies which field of the existing output file should store the config")
+
+	return cmd
+}
+
+func importFromDockerfile(cfg *cmdImportConfig) error {
+	var outputTarget io.Writer
+
+	data, err := parser.ParseFile(cfg.input)
+	if err != nil {
+		return err
+	}
+
+	document := &yaml.Node{}
+
+	// the existing output file is kept, only the target field is replaced
+	if cfg.targetField != "" && cfg.output != "" {
+		content, err := ioutil.ReadFile(cfg.output)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if err := yaml.Unmarshal(content, document); err != nil {
+			return fmt.Errorf("Unmarshal: %v", err)
+		}
+	}
+
+	dataNode, err := data.MarshalYAML()
+	if err != nil {
+		return err
+	}
+
+	if err := dfg.SetTargetNode(document, cfg.targetField, dataNode.(*yaml.Node)); err != nil {
+		return fmt.Errorf("Can't set target val: %v", err)
+	}
+
+	if cfg.stdout {
+		outputTarget = os.Stdout
+	} else {
+		file, err := os.Create(cfg.output)
+		outputTarget = file
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+	}
+
+	encoder := yaml.NewEncoder(outputTarget)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(document); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestImportGenerate imports a Dockerfile with paths that need quoting and generates it back from the YAML input
+func TestImportGenerate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dfg-import")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	instructions := `COPY ["my file.txt", "/dest dir/"]
+ADD ["https://example.com/a b.tar.gz", "/opt/"]
+VOLUME ["/a b"]
+WORKDIR "/app \"x\""
+`
+	input := filepath.Join(dir, "Dockerfile")
+	assert.NoError(t, ioutil.WriteFile(input, []byte("FROM alpine\n"+instructions), 0644))
+
+	yamlOutput := filepath.Join(dir, "dfg.yaml")
+	assert.NoError(t, importFromDockerfile(&cmdImportConfig{input: input, output: yamlOutput}))
+
+	output := filepath.Join(dir, "Dockerfile.generated")
+	assert.NoError(t, generate(&cmdGenerateConfig{input: yamlOutput, output: output}, nil, &bytes.Buffer{}))
+
+	generated, err := ioutil.ReadFile(output)
+	assert.NoError(t, err)
+	assert.Equal(t, "FROM alpine as stage0\n"+instructions+"\n", string(generated))
+}
@@ -3,6 +3,7 @@ module github.com/ozankasikci/dockerfile-generator
 go 1.13
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/kr/pretty v0.1.0 // indirect
 	github.com/spf13/cobra v0.0.5
 	github.com/stretchr/testify v1.4.0
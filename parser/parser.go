@@ -0,0 +1,932 @@
+/*
+Package parser reads an existing Dockerfile and turns it back into a *dockerfilegenerator.DockerfileData,
+so hand-written Dockerfiles can be migrated to dfg inputs.
+*/
+package parser
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	dfg "github.com/ozankasikci/dockerfile-generator"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DefaultEscape is the line continuation character used unless an escape parser directive is given
+const DefaultEscape = '\\'
+
+var directiveRegexp = regexp.MustCompile(`^#\s*([a-zA-Z][a-zA-Z0-9]*)\s*=\s*(.+?)\s*$`)
+
+// heredocRegexp matches the start of a heredoc, e.g. <<EOF, <<-EOF or <<'EOF'
+var heredocRegexp = regexp.MustCompile(`^<<(-?)(["']?)([A-Za-z_][A-Za-z0-9_]*)["']?`)
+
+// line is a logical Dockerfile line, continuation lines are already joined
+type line struct {
+	number  int
+	text    string
+	heredoc *heredoc
+}
+
+// heredoc is the body of a RUN or COPY heredoc, the lines following the instruction up to the delimiter
+type heredoc struct {
+	delimiter string
+	body      string
+}
+
+// parser holds the state of a single Dockerfile parse
+type parser struct {
+	filename   string
+	escape     rune
+	directives dfg.Directives
+	errs       dfg.ConfigErrors
+}
+
+// ParseFile reads the given Dockerfile and returns its stages, see Parse
+func ParseFile(filename string) (*dfg.DockerfileData, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parse(filename, file)
+}
+
+// Parse reads a Dockerfile and returns its stages in the same order.
+// Every problem found in the Dockerfile is reported together as dfg.ConfigErrors.
+func Parse(r io.Reader) (*dfg.DockerfileData, error) {
+	return parse("", r)
+}
+
+func parse(filename string, r io.Reader) (*dfg.DockerfileData, error) {
+	p := &parser{filename: filename, escape: DefaultEscape}
+
+	lines, err := p.readLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &dfg.DockerfileData{Directives: p.directives}
+	var global []dfg.Instruction
+
+	for _, l := range lines {
+		keyword, rest := splitInstruction(l.text)
+		keyword = strings.ToUpper(keyword)
+
+		if len(data.Stages) == 0 && keyword != "FROM" && keyword != "ARG" {
+			p.addError(l, "", -1, "%s instruction found before FROM", keyword)
+			continue
+		}
+
+		instructions, err := p.parseInstruction(keyword, rest, l.heredoc)
+		if err != nil {
+			stageName, index := p.currentPosition(data)
+			p.addError(l, stageName, index, "%v", err)
+			continue
+		}
+
+		if keyword == "FROM" {
+			// global ARGs are kept in front of the first FROM, the stage is named after its alias
+			stage := dfg.NewStage("", append(global, instructions...)...)
+			stage.Name = stage.Alias()
+			data.Stages = append(data.Stages, stage)
+			global = nil
+			continue
+		}
+
+		if len(data.Stages) == 0 {
+			global = append(global, instructions...)
+			continue
+		}
+
+		last := len(data.Stages) - 1
+		data.Stages[last].Instructions = append(data.Stages[last].Instructions, instructions...)
+	}
+
+	if len(data.Stages) == 0 && len(p.errs) == 0 {
+		p.addError(line{}, "", -1, "Dockerfile doesn't contain a FROM instruction")
+	}
+
+	if len(p.errs) > 0 {
+		return nil, p.errs
+	}
+
+	return data, nil
+}
+
+func (p *parser) addError(l line, stage string, instruction int, format string, args ...interface{}) {
+	p.errs = append(p.errs, &dfg.ConfigError{
+		Filename:    p.filename,
+		Line:        l.number,
+		Stage:       stage,
+		Instruction: instruction,
+		Reason:      fmt.Sprintf(format, args...),
+	})
+}
+
+// currentPosition returns the name and the next instruction index of the stage being parsed
+func (p *parser) currentPosition(data *dfg.DockerfileData) (string, int) {
+	if len(data.Stages) == 0 {
+		return "", -1
+	}
+
+	stage := data.Stages[len(data.Stages)-1]
+	return stageName(data, len(data.Stages)-1), len(stage.Instructions)
+}
+
+// stageName returns the name of the stage at the given index, or stage<index> when it has none
+func stageName(data *dfg.DockerfileData, index int) string {
+	if name := data.Stages[index].Name; name != "" {
+		return name
+	}
+
+	return fmt.Sprintf("stage%d", index)
+}
+
+// readLines reads parser directives and returns the logical lines, comments and empty lines are dropped
+func (p *parser) readLines(r io.Reader) ([]line, error) {
+	var lines []line
+	var current *line
+	var doc *heredoc
+	var body []string
+	stripTabs := false
+	readingDirectives := true
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for number := 1; scanner.Scan(); number++ {
+		if doc != nil {
+			text := scanner.Text()
+			if stripTabs {
+				text = strings.TrimLeft(text, "\t")
+			}
+
+			if text == doc.delimiter {
+				doc.body = strings.Join(body, "\n")
+				doc, body = nil, nil
+				continue
+			}
+
+			body = append(body, text)
+			continue
+		}
+
+		text := strings.TrimRightFunc(scanner.Text(), unicode.IsSpace)
+		trimmed := strings.TrimLeftFunc(text, unicode.IsSpace)
+
+		if readingDirectives {
+			if m := directiveRegexp.FindStringSubmatch(trimmed); m != nil {
+				p.setDirective(strings.ToLower(m[1]), m[2])
+				continue
+			}
+			readingDirectives = false
+		}
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if current == nil {
+			current = &line{number: number}
+		} else if trimmed != text && !strings.HasSuffix(current.text, " ") {
+			// keeps the word boundary of an indented continuation line
+			current.text += " "
+		}
+
+		if strings.HasSuffix(trimmed, string(p.escape)) {
+			current.text += strings.TrimSuffix(trimmed, string(p.escape))
+			continue
+		}
+
+		current.text += trimmed
+		current.text = strings.TrimSpace(current.text)
+		lines = append(lines, *current)
+		current = nil
+
+		last := &lines[len(lines)-1]
+		if keyword, _ := splitInstruction(last.text); strings.EqualFold(keyword, "RUN") || strings.EqualFold(keyword, "COPY") {
+			if m := findHeredoc(last.text); m != nil {
+				doc = &heredoc{delimiter: m[3]}
+				stripTabs = m[1] == "-"
+				last.heredoc = doc
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if doc != nil {
+		return nil, dfg.ConfigErrors{&dfg.ConfigError{
+			Filename:    p.filename,
+			Line:        lines[len(lines)-1].number,
+			Instruction: -1,
+			Reason:      fmt.Sprintf("Heredoc isn't terminated, expected %s on its own line", doc.delimiter),
+		}}
+	}
+
+	if current != nil {
+		current.text = strings.TrimSpace(current.text)
+		lines = append(lines, *current)
+	}
+
+	return lines, nil
+}
+
+// findHeredoc returns the heredocRegexp submatches of the first << that starts a word outside of quotes and
+// arithmetic expansions, as BuildKit reads the heredocs of a line, e.g. not the one of RUN echo $((1<<X))
+func findHeredoc(text string) []string {
+	var quote byte
+	arithmetic := 0
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '\\':
+			i++
+		case c == '\'' || c == '"':
+			quote = c
+		case strings.HasPrefix(text[i:], "$(("):
+			arithmetic++
+			i += 2
+		case arithmetic > 0 && strings.HasPrefix(text[i:], "))"):
+			arithmetic--
+			i++
+		case arithmetic == 0 && (i == 0 || unicode.IsSpace(rune(text[i-1]))):
+			if m := heredocRegexp.FindStringSubmatch(text[i:]); m != nil {
+				return m
+			}
+		}
+	}
+
+	return nil
+}
+
+// setDirective keeps the known directives for the data, unknown ones are ignored as Docker does
+func (p *parser) setDirective(name, value string) {
+	switch name {
+	case "syntax":
+		p.directives.Syntax = value
+	case "escape":
+		if value == "`" || value == "\\" {
+			p.escape = rune(value[0])
+			p.directives.Escape = value
+		}
+	case "check":
+		p.directives.Check = value
+	}
+}
+
+// splitInstruction splits the first word from the rest of the text
+func splitInstruction(text string) (string, string) {
+	i := strings.IndexFunc(text, unicode.IsSpace)
+	if i < 0 {
+		return text, ""
+	}
+
+	return text[:i], strings.TrimSpace(text[i:])
+}
+
+// parseFlags extracts the leading --name=value flags of an instruction, only the allowed flags are accepted
+func parseFlags(rest string, allowed ...string) (map[string]string, string, error) {
+	list, rest, err := parseFlagList(rest, allowed...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	flags := map[string]string{}
+	for _, flag := range list {
+		flags[flag[0]] = flag[1]
+	}
+
+	return flags, rest, nil
+}
+
+// parseFlagList returns the flags in the order they are given, for the flags that can be repeated, e.g. --mount
+func parseFlagList(rest string, allowed ...string) ([][2]string, string, error) {
+	var flags [][2]string
+
+	for strings.HasPrefix(rest, "--") {
+		flag, remaining := splitInstruction(rest)
+		parts := strings.SplitN(strings.TrimPrefix(flag, "--"), "=", 2)
+
+		name := parts[0]
+		if !contains(allowed, name) {
+			return nil, "", fmt.Errorf("Unsupported flag --%s", name)
+		}
+
+		value := ""
+		if len(parts) == 2 {
+			value = parts[1]
+		}
+		flags = append(flags, [2]string{name, value})
+
+		rest = remaining
+	}
+
+	return flags, rest, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseExecForm returns the params of a json array, ok is false when the given string isn't in exec form
+func parseExecForm(rest string) (dfg.Params, bool) {
+	if !strings.HasPrefix(rest, "[") {
+		return nil, false
+	}
+
+	var params []string
+	if err := json.Unmarshal([]byte(rest), &params); err != nil {
+		return nil, false
+	}
+
+	return params, true
+}
+
+// parseParams returns the params and the form they were written in
+func parseParams(rest string) (dfg.Params, dfg.RunForm) {
+	if params, ok := parseExecForm(rest); ok {
+		return params, dfg.ExecForm
+	}
+
+	return dfg.Params{rest}, dfg.ShellForm
+}
+
+// splitWords splits by whitespace, quoted parts and escaped characters are kept as they are
+func (p *parser) splitWords(rest string) []string {
+	var words []string
+	var word strings.Builder
+	var quote rune
+	escaped := false
+	inWord := false
+
+	for _, char := range rest {
+		switch {
+		case escaped:
+			escaped = false
+		case char == p.escape:
+			escaped = true
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '"' || char == '\'':
+			quote = char
+		case unicode.IsSpace(char):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		}
+
+		word.WriteRune(char)
+		inWord = true
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words
+}
+
+// keyValue is a <key>=<value> pair of ENV and LABEL, literal is true when a $ is escaped in it
+type keyValue struct {
+	key     string
+	value   string
+	literal bool
+}
+
+// parseKeyValues parses the <key>=<value> ... and the legacy <key> <value> forms of ENV and LABEL
+func (p *parser) parseKeyValues(keyword, rest string) ([]keyValue, error) {
+	words := p.splitWords(rest)
+	if len(words) == 0 {
+		return nil, fmt.Errorf("%s requires at least one argument", keyword)
+	}
+
+	if p.separatorIndex(words[0]) < 0 {
+		name, value := splitInstruction(rest)
+		value, literal := p.unquote(value)
+		return []keyValue{{key: name, value: value, literal: literal}}, nil
+	}
+
+	var res []keyValue
+	for _, word := range words {
+		index := p.separatorIndex(word)
+		if index < 0 {
+			return nil, fmt.Errorf("%s expects <key>=<value> pairs, got %q", keyword, word)
+		}
+
+		key, keyLiteral := p.unquote(word[:index])
+		value, valueLiteral := p.unquote(word[index+1:])
+		res = append(res, keyValue{key: key, value: value, literal: keyLiteral || valueLiteral})
+	}
+
+	return res, nil
+}
+
+// separatorIndex returns the index of the first = that isn't quoted or escaped, -1 if there is none
+func (p *parser) separatorIndex(word string) int {
+	var quote rune
+	escaped := false
+
+	for i, char := range word {
+		switch {
+		case escaped:
+			escaped = false
+		case char == p.escape && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '"' || char == '\'':
+			quote = char
+		case char == '=':
+			return i
+		}
+	}
+
+	return -1
+}
+
+// unquote removes the quotes and the escape characters of a value the way the builder does, literal is true when
+// a $ is escaped, a value that mixes escaped and expanded variables is read as a literal one
+func (p *parser) unquote(word string) (string, bool) {
+	var res strings.Builder
+	var quote rune
+	literal := false
+	chars := []rune(word)
+
+	for i := 0; i < len(chars); i++ {
+		char := chars[i]
+
+		switch {
+		case quote == '\'':
+			if char == '\'' {
+				quote = 0
+				continue
+			}
+		case char == p.escape && i+1 < len(chars):
+			next := chars[i+1]
+			// in double quotes only the quote, $ and the escape character itself are escaped
+			if quote == '"' && next != '"' && next != '$' && next != p.escape {
+				break
+			}
+
+			literal = literal || next == '$'
+			char = next
+			i++
+		case char == '"' && quote == '"':
+			quote = 0
+			continue
+		case (char == '"' || char == '\'') && quote == 0:
+			quote = char
+			continue
+		}
+
+		res.WriteRune(char)
+	}
+
+	return res.String(), literal
+}
+
+func (p *parser) parseInstruction(keyword, rest string, doc *heredoc) ([]dfg.Instruction, error) {
+	switch keyword {
+	case "FROM":
+		return p.parseFrom(rest)
+	case "ARG":
+		return p.parseArg(rest)
+	case "RUN":
+		return p.parseRun(rest, doc)
+	case "CMD":
+		params, form := parseParams(rest)
+		return []dfg.Instruction{dfg.Cmd{Params: params, RunForm: form}}, nil
+	case "ENTRYPOINT":
+		params, form := parseParams(rest)
+		return []dfg.Instruction{dfg.Entrypoint{Params: params, RunForm: form}}, nil
+	case "SHELL":
+		params, ok := parseExecForm(rest)
+		if !ok {
+			return nil, fmt.Errorf("SHELL requires the exec form, e.g. [\"/bin/sh\", \"-c\"]")
+		}
+		return []dfg.Instruction{dfg.Shell{Params: params}}, nil
+	case "COPY":
+		return p.parseCopy(rest, doc)
+	case "ADD":
+		return p.parseAdd(rest)
+	case "EXPOSE":
+		expose := dfg.Expose{Ports: p.splitWords(rest)}
+		if err := expose.Validate(); err != nil {
+			return nil, err
+		}
+		return []dfg.Instruction{expose}, nil
+	case "STOPSIGNAL":
+		stopSignal := dfg.StopSignal{Signal: rest}
+		if err := stopSignal.Validate(); err != nil {
+			return nil, err
+		}
+		return []dfg.Instruction{stopSignal}, nil
+	case "ENV":
+		pairs, err := p.parseKeyValues(keyword, rest)
+		if err != nil {
+			return nil, err
+		}
+
+		var res []dfg.Instruction
+		for _, pair := range pairs {
+			res = append(res, dfg.EnvVariable{Name: pair.key, Value: pair.value, Literal: pair.literal})
+		}
+		return res, nil
+	case "LABEL":
+		pairs, err := p.parseKeyValues(keyword, rest)
+		if err != nil {
+			return nil, err
+		}
+
+		var res []dfg.Instruction
+		for _, pair := range pairs {
+			res = append(res, dfg.Label{Name: pair.key, Value: pair.value, Literal: pair.literal})
+		}
+		return res, nil
+	case "VOLUME":
+		return p.parseVolume(rest)
+	case "WORKDIR":
+		dir, _ := p.unquote(rest)
+		return []dfg.Instruction{dfg.Workdir{Dir: dir}}, nil
+	case "USER":
+		parts := strings.SplitN(rest, ":", 2)
+		name, _ := p.unquote(parts[0])
+		user := dfg.User{User: name}
+		if len(parts) == 2 {
+			user.Group, _ = p.unquote(parts[1])
+		}
+		return []dfg.Instruction{user}, nil
+	case "ONBUILD":
+		return p.parseOnbuild(rest)
+	case "HEALTHCHECK":
+		return p.parseHealthCheck(rest)
+	}
+
+	return nil, unsupportedInstructionError(keyword)
+}
+
+// unsupportedInstructionError is returned for an unknown keyword, an ONBUILD trigger keeps it as free text
+type unsupportedInstructionError string
+
+func (e unsupportedInstructionError) Error() string {
+	return fmt.Sprintf("Unsupported instruction %s", string(e))
+}
+
+func (p *parser) parseHealthCheck(rest string) ([]dfg.Instruction, error) {
+	if strings.EqualFold(rest, "NONE") {
+		return []dfg.Instruction{dfg.HealthCheck{None: true, RunForm: dfg.HealthCheckDefaultRunForm}}, nil
+	}
+
+	flags, rest, err := parseFlagList(rest, "interval", "timeout", "start-period", "start-interval", "retries")
+	if err != nil {
+		return nil, err
+	}
+
+	keyword, command := splitInstruction(rest)
+	if !strings.EqualFold(keyword, "CMD") {
+		return nil, fmt.Errorf("HEALTHCHECK expects [OPTIONS] CMD <command> or NONE, got %q", rest)
+	}
+
+	params, form := parseParams(command)
+	h := dfg.HealthCheck{Params: params, RunForm: form}
+
+	for _, flag := range flags {
+		if flag[0] == "retries" {
+			if h.Retries, err = strconv.Atoi(flag[1]); err != nil {
+				return nil, fmt.Errorf("Invalid HEALTHCHECK --retries %q, expected a number", flag[1])
+			}
+			continue
+		}
+
+		duration, err := time.ParseDuration(flag[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid HEALTHCHECK --%s %q, expected a duration, e.g. 30s", flag[0], flag[1])
+		}
+
+		switch flag[0] {
+		case "interval":
+			h.Interval = duration
+		case "timeout":
+			h.Timeout = duration
+		case "start-period":
+			h.StartPeriod = duration
+		case "start-interval":
+			h.StartInterval = duration
+		}
+	}
+
+	if err := h.Validate(); err != nil {
+		return nil, err
+	}
+
+	return []dfg.Instruction{h}, nil
+}
+
+func (p *parser) parseFrom(rest string) ([]dfg.Instruction, error) {
+	flags, rest, err := parseFlags(rest, "platform")
+	if err != nil {
+		return nil, err
+	}
+
+	words := p.splitWords(rest)
+
+	switch {
+	case len(words) == 1:
+		return []dfg.Instruction{dfg.From{Image: words[0], Platform: flags["platform"]}}, nil
+	case len(words) == 3 && strings.EqualFold(words[1], "as"):
+		return []dfg.Instruction{dfg.From{Image: words[0], As: words[2], Platform: flags["platform"]}}, nil
+	}
+
+	return nil, fmt.Errorf("FROM expects <image> [AS <name>], got %q", rest)
+}
+
+func (p *parser) parseArg(rest string) ([]dfg.Instruction, error) {
+	if rest == "" {
+		return nil, fmt.Errorf("ARG requires a name")
+	}
+
+	arg := dfg.Arg{Name: rest}
+	if index := p.separatorIndex(rest); index >= 0 {
+		arg.Name = rest[:index]
+		arg.Value, arg.Literal = p.unquote(rest[index+1:])
+	}
+
+	return []dfg.Instruction{arg}, nil
+}
+
+// parseOnbuild parses the trigger as an instruction of its own, an ENV or LABEL with several pairs is split into
+// one ONBUILD per pair. An unknown trigger is kept as the free text params, e.g. ONBUILD echo test.
+func (p *parser) parseOnbuild(rest string) ([]dfg.Instruction, error) {
+	if rest == "" {
+		return nil, fmt.Errorf("ONBUILD requires an instruction")
+	}
+
+	keyword, trigger := splitInstruction(rest)
+	keyword = strings.ToUpper(keyword)
+
+	// MAINTAINER isn't supported by the parser, it's checked here so that the ONBUILD error is reported
+	if keyword == "MAINTAINER" {
+		return nil, fmt.Errorf("%s isn't allowed as an ONBUILD trigger", keyword)
+	}
+
+	instructions, err := p.parseInstruction(keyword, trigger, nil)
+	if _, ok := err.(unsupportedInstructionError); ok {
+		return []dfg.Instruction{dfg.Onbuild{Params: dfg.Params{rest}}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]dfg.Instruction, len(instructions))
+	for i, instruction := range instructions {
+		onbuild := dfg.Onbuild{Instruction: instruction}
+		if err := onbuild.Validate(); err != nil {
+			return nil, err
+		}
+		res[i] = onbuild
+	}
+
+	return res, nil
+}
+
+func (p *parser) parseCopy(rest string, doc *heredoc) ([]dfg.Instruction, error) {
+	list, rest, err := parseFlagList(rest, "from", "chown", "chmod", "link", "parents", "exclude")
+	if err != nil {
+		return nil, err
+	}
+
+	flags := map[string]string{}
+	var exclude []string
+	for _, flag := range list {
+		if flag[0] == "exclude" {
+			exclude = append(exclude, flag[1])
+			continue
+		}
+		flags[flag[0]] = flag[1]
+	}
+
+	if doc != nil {
+		words := p.splitWords(rest)
+		if len(words) != 2 || words[0] != "<<"+doc.delimiter || flags["from"] != "" {
+			return nil, fmt.Errorf("COPY heredocs are supported in the form of COPY <<%s <dest>", doc.delimiter)
+		}
+
+		copyCommand := dfg.CopyCommand{
+			Destination: words[1],
+			Chown:       flags["chown"],
+			Chmod:       flags["chmod"],
+			Link:        boolFlag(flags, "link"),
+			Parents:     boolFlag(flags, "parents"),
+			Exclude:     exclude,
+			Content:     doc.body,
+			Delimiter:   heredocDelimiter(doc),
+		}
+		if err := copyCommand.Validate(); err != nil {
+			return nil, err
+		}
+
+		return []dfg.Instruction{copyCommand}, nil
+	}
+
+	paths, ok := parseExecForm(rest)
+	if !ok {
+		paths = p.splitWords(rest)
+	}
+
+	if len(paths) < 2 {
+		return nil, fmt.Errorf("COPY requires at least one source and a destination")
+	}
+
+	copyCommand := dfg.CopyCommand{
+		Sources:     paths[:len(paths)-1],
+		Destination: paths[len(paths)-1],
+		From:        flags["from"],
+		Chown:       flags["chown"],
+		Chmod:       flags["chmod"],
+		Link:        boolFlag(flags, "link"),
+		Parents:     boolFlag(flags, "parents"),
+		Exclude:     exclude,
+	}
+	if err := copyCommand.Validate(); err != nil {
+		return nil, err
+	}
+
+	return []dfg.Instruction{copyCommand}, nil
+}
+
+func (p *parser) parseRun(rest string, doc *heredoc) ([]dfg.Instruction, error) {
+	flags, rest, err := parseFlagList(rest, "mount", "network", "security")
+	if err != nil {
+		return nil, err
+	}
+
+	var run dfg.RunCommand
+	switch {
+	case doc != nil && rest == "<<"+doc.delimiter:
+		run = dfg.RunCommand{Script: doc.body, Delimiter: heredocDelimiter(doc), RunForm: dfg.ShellForm}
+	case doc != nil:
+		// heredocs fed to a command, e.g. RUN cat <<EOF > file, or quoted ones are kept as they are written
+		run = dfg.RunCommand{Params: dfg.Params{rest + "\n" + doc.body + "\n" + doc.delimiter}, RunForm: dfg.ShellForm}
+	default:
+		params, form := parseParams(rest)
+		run = dfg.RunCommand{Params: params, RunForm: form}
+	}
+
+	for _, flag := range flags {
+		switch flag[0] {
+		case "mount":
+			mount, err := parseMount(flag[1])
+			if err != nil {
+				return nil, err
+			}
+			run.Mounts = append(run.Mounts, mount)
+		case "network":
+			run.Network = flag[1]
+		case "security":
+			run.Security = flag[1]
+		}
+	}
+
+	if err := run.Validate(); err != nil {
+		return nil, err
+	}
+
+	return []dfg.Instruction{run}, nil
+}
+
+// heredocDelimiter returns the delimiter to keep, the default one is left empty
+func heredocDelimiter(doc *heredoc) string {
+	if doc.delimiter == dfg.DefaultHeredocDelimiter {
+		return ""
+	}
+
+	return doc.delimiter
+}
+
+// parseMount parses the value of a --mount flag, e.g. type=cache,target=/root/.cache,sharing=locked
+func parseMount(value string) (dfg.Mount, error) {
+	mount := dfg.Mount{Type: dfg.BindMount}
+
+	for _, option := range strings.Split(value, ",") {
+		parts := strings.SplitN(option, "=", 2)
+		key := strings.ToLower(parts[0])
+		val := ""
+		if len(parts) == 2 {
+			val = parts[1]
+		}
+
+		switch key {
+		case "type":
+			mount.Type = dfg.MountType(val)
+		case "id":
+			mount.ID = val
+		case "target", "dst", "destination":
+			mount.Target = val
+		case "source", "src":
+			mount.Source = val
+		case "from":
+			mount.From = val
+		case "sharing":
+			mount.Sharing = val
+		case "mode":
+			mount.Mode = val
+		case "uid":
+			mount.UID = val
+		case "gid":
+			mount.GID = val
+		case "size":
+			mount.Size = val
+		case "rw", "readwrite":
+			mount.ReadWrite = val == "" || val == "true"
+		case "required":
+			mount.Required = val == "" || val == "true"
+		default:
+			return mount, fmt.Errorf("Unsupported mount option %s", key)
+		}
+	}
+
+	return mount, nil
+}
+
+func (p *parser) parseAdd(rest string) ([]dfg.Instruction, error) {
+	flags, rest, err := parseFlags(rest, "chown", "chmod", "checksum", "keep-git-dir", "link")
+	if err != nil {
+		return nil, err
+	}
+
+	paths, ok := parseExecForm(rest)
+	if !ok {
+		paths = p.splitWords(rest)
+	}
+
+	if len(paths) < 2 {
+		return nil, fmt.Errorf("ADD requires at least one source and a destination")
+	}
+
+	add := dfg.Add{
+		Sources:     paths[:len(paths)-1],
+		Destination: paths[len(paths)-1],
+		Chown:       flags["chown"],
+		Chmod:       flags["chmod"],
+		Checksum:    flags["checksum"],
+		KeepGitDir:  boolFlag(flags, "keep-git-dir"),
+		Link:        boolFlag(flags, "link"),
+	}
+	if err := add.Validate(); err != nil {
+		return nil, err
+	}
+
+	return []dfg.Instruction{add}, nil
+}
+
+// boolFlag returns true when the flag is given without a value, e.g. --link, or as --link=true
+func boolFlag(flags map[string]string, name string) bool {
+	value, ok := flags[name]
+	return ok && (value == "" || value == "true")
+}
+
+func (p *parser) parseVolume(rest string) ([]dfg.Instruction, error) {
+	paths, ok := parseExecForm(rest)
+	if !ok {
+		paths = p.splitWords(rest)
+	}
+
+	switch len(paths) {
+	case 1:
+		return []dfg.Instruction{dfg.Volume{Source: paths[0]}}, nil
+	case 2:
+		return []dfg.Instruction{dfg.Volume{Source: paths[0], Destination: paths[1]}}, nil
+	}
+
+	return nil, fmt.Errorf("VOLUME expects one or two paths, got %d", len(paths))
+}
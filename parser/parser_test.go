@@ -0,0 +1,342 @@
+package parser
+
+import (
+	"bytes"
+	dfg "github.com/ozankasikci/dockerfile-generator"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func render(t *testing.T, data *dfg.DockerfileData) string {
+	output := &bytes.Buffer{}
+	err := dfg.NewDockerfileTemplate(data).Render(output)
+	assert.NoError(t, err)
+
+	return output.String()
+}
+
+func TestRoundTripExampleInputFiles(t *testing.T) {
+	tests := []struct {
+		filename    string
+		targetField string
+	}{
+		{filename: "apache-php.yaml"},
+		{filename: "test-input.yaml"},
+		{filename: "test-input-no-user.yaml"},
+		{filename: "test-input-user-group.yaml"},
+		{filename: "test-input-with-include.yaml"},
+		{filename: "test-input-with-target-key.yaml", targetField: ".seq[3].dockerfileConfig"},
+		{filename: "test-input-with-target-key-2.yaml", targetField: ".dockerfileConfig"},
+		{filename: "test-input-with-target-key-3.yaml", targetField: "[0]"},
+		{filename: "test-input-with-target-key-4.yaml", targetField: "[1]"},
+		{filename: "test-input-with-target-key-5.yaml", targetField: ".prod.apache"},
+		{filename: "test-input-with-target-key-5.yaml", targetField: ".dev.apache"},
+		{filename: "test-input-with-target-key-5.yaml", targetField: ".dev.server"},
+		{filename: "test-input-with-target-key-6.yaml", targetField: ".serverConfig.dockerfile"},
+		{filename: "test-input-with-extends.yaml", targetField: ".dev.apache"},
+		{filename: "test-input-with-onbuild.yaml"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename+tt.targetField, func(t *testing.T) {
+			filename := "../example-input-files/" + tt.filename
+
+			var data *dfg.DockerfileData
+			var err error
+			if tt.targetField == "" {
+				data, err = dfg.NewDockerFileDataFromYamlFile(filename)
+			} else {
+				data, err = dfg.NewDockerFileDataFromYamlField(filename, tt.targetField)
+			}
+			assert.NoError(t, err)
+
+			expected := render(t, data)
+			parsed, err := Parse(strings.NewReader(expected))
+			assert.NoError(t, err)
+			assert.Len(t, parsed.Stages, len(data.Stages))
+			assert.Equal(t, expected, render(t, parsed))
+		})
+	}
+}
+
+func TestRoundTripLibraryData(t *testing.T) {
+	data := &dfg.DockerfileData{
+		Directives: dfg.Directives{Check: "skip=JSONArgsRecommended"},
+		Stages: []dfg.Stage{
+			{Instructions: []dfg.Instruction{
+				dfg.From{Image: "golang:1.7.3", As: "builder"},
+				dfg.Arg{Name: "arg-name", Test: true, EnvVariable: true},
+				dfg.Workdir{Dir: "/go/src/github.com/alexellis/href-counter/"},
+				dfg.User{User: "ozan", Group: "admin"},
+				dfg.RunCommand{Params: []string{"go", "get", "-d", "-v", "golang.org/x/net/html"}},
+				dfg.CopyCommand{Sources: []string{"app.go", "go.mod"}, Destination: ".", Chown: "ozan:admin", Chmod: "644", Exclude: []string{"*_test.go"}},
+				dfg.Volume{Source: "/data"},
+				dfg.Add{Sources: []string{"app.tar.gz"}, Destination: "/opt/", Chown: "ozan", Chmod: "755", KeepGitDir: true, Link: true},
+			}},
+			{Instructions: []dfg.Instruction{
+				dfg.From{Image: "alpine:latest", As: "final"},
+				dfg.Label{Name: "maintainer", Value: "ozan"},
+				dfg.CopyCommand{From: "builder", Sources: []string{"/go/src/github.com/alexellis/href-counter/app"}, Destination: ".", Link: true},
+				dfg.Shell{Params: []string{"/bin/sh", "-c"}},
+				dfg.Entrypoint{Params: []string{"./app"}, RunForm: dfg.ExecForm},
+				dfg.Cmd{Params: []string{"--help"}, RunForm: dfg.ShellForm},
+				dfg.Expose{Ports: []string{"8080", "9000-9010/udp"}},
+				dfg.RunCommand{
+					Params: []string{"npm", "ci"}, RunForm: dfg.ExecForm, Network: "host", Security: "sandbox",
+					Mounts: []dfg.Mount{
+						{Type: dfg.BindMount, Target: "/src", From: "builder", Source: "/app", ReadWrite: true},
+						{Type: dfg.CacheMount, ID: "npm", Target: "/root/.npm", Mode: "0755", UID: "1000", GID: "1000"},
+						{Type: dfg.TmpfsMount, Target: "/tmp", Size: "64m"},
+					},
+				},
+				dfg.StopSignal{Signal: "9"},
+				dfg.HealthCheck{Params: dfg.Params{"curl", "-f", "http://localhost/"}, RunForm: dfg.ExecForm, Interval: 90 * time.Second, Retries: 3},
+				dfg.Onbuild{Instruction: dfg.RunCommand{Params: []string{"go", "build"}, RunForm: dfg.ExecForm}},
+			}},
+		},
+	}
+
+	expected := render(t, data)
+	parsed, err := Parse(strings.NewReader(expected))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, render(t, parsed))
+}
+
+const randomValueChars = "abcXYZ019 -_./:=\"'#&*!|>%@$`{}[],?~\\\tç"
+
+func randomValue(r *rand.Rand) string {
+	res := make([]rune, r.Intn(12))
+	chars := []rune(randomValueChars)
+	for i := range res {
+		res[i] = chars[r.Intn(len(chars))]
+	}
+
+	return string(res)
+}
+
+// TestRoundTripEscaping renders random values and checks that they are parsed back the way the builder reads them
+func TestRoundTripEscaping(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 500; i++ {
+		escape := []string{"", "\\", "`"}[r.Intn(3)]
+		env := dfg.EnvVariable{Name: "ENV_" + strconv.Itoa(i), Value: randomValue(r), Literal: r.Intn(2) == 0}
+		label := dfg.Label{Name: "label " + randomValue(r), Value: randomValue(r), Literal: r.Intn(2) == 0}
+		arg := dfg.Arg{Name: "ARG_" + strconv.Itoa(i), Value: randomValue(r), Literal: r.Intn(2) == 0}
+		cmd := dfg.Cmd{Params: dfg.Params{randomValue(r) + "\n", randomValue(r)}, RunForm: dfg.ExecForm}
+		copyCommand := dfg.CopyCommand{Sources: []string{randomValue(r), randomValue(r)}, Destination: randomValue(r)}
+		add := dfg.Add{Sources: []string{randomValue(r)}, Destination: randomValue(r), Link: r.Intn(2) == 0}
+		volume := dfg.Volume{Source: "/" + randomValue(r), Destination: randomValue(r)}
+		workdir := dfg.Workdir{Dir: "/" + randomValue(r)}
+		// the builder splits the user and the group at the first :
+		user := dfg.User{User: "u" + strings.Replace(randomValue(r), ":", "", -1), Group: randomValue(r)}
+		instructions := []dfg.Instruction{dfg.From{Image: "alpine"}, env, label, arg, cmd, copyCommand, add, volume, workdir, user}
+
+		data := &dfg.DockerfileData{
+			Directives: dfg.Directives{Escape: escape},
+			Stages:     []dfg.Stage{dfg.NewStage("", instructions...)},
+		}
+		dockerfile := render(t, data)
+
+		// the parsed values are literal when a $ had to be escaped in them
+		env.Literal = env.Literal && strings.Contains(env.Value, "$")
+		label.Literal = label.Literal && strings.Contains(label.Name+label.Value, "$")
+		arg.Literal = arg.Literal && strings.Contains(arg.Value, "$")
+
+		parsed, err := Parse(strings.NewReader(dockerfile))
+		if !assert.NoError(t, err, dockerfile) {
+			continue
+		}
+		instructions[1], instructions[2], instructions[3] = env, label, arg
+		assert.Equal(t, instructions, parsed.Stages[0].Instructions, dockerfile)
+	}
+}
+
+func TestParse(t *testing.T) {
+	dockerfile := `# syntax=docker/dockerfile:1
+# escape=\
+
+# a comment that isn't a directive
+ARG VERSION=1.13
+FROM golang:${VERSION} AS builder
+RUN apt-get update && \
+    # comments are removed from continuation lines
+    apt-get install -y \
+      git
+
+ENV GOOS=linux GOARCH="amd 64"
+ENV LEGACY some value
+COPY --from=base --chown=1000:1000 ["a b", "/dest/"]
+COPY --parents --chmod=0755 --exclude=*.md --exclude=**/testdata services/*/bin /usr/local/
+ADD --chmod=644 --checksum=sha256:24454f830cdb571e2c4ad15481119c43b3cafd48dd869a9b2945d1036d1dc68d --link https://example.com/a.tar.gz /
+ADD --keep-git-dir=true git@github.com:moby/buildkit.git /buildkit
+RUN --mount=type=cache,target=/root/.cache/go-build,sharing=locked --mount=type=secret,id=netrc,dst=/root/.netrc,required --network=none go build
+RUN --mount=type=ssh --security=insecure ["git", "clone", "git@github.com:moby/buildkit.git"]
+CMD ["go", "test"]
+EXPOSE 80/tcp 53/udp 8000-8010 ${PORT}
+STOPSIGNAL SIGQUIT
+
+from --platform=$BUILDPLATFORM alpine
+copy --from=builder --link /app /app
+entrypoint ./app
+healthcheck none
+ONBUILD COPY --chown=app . /src
+onbuild env A=1 B="x y"
+ONBUILD echo test
+`
+
+	data, err := Parse(strings.NewReader(dockerfile))
+	assert.NoError(t, err)
+	assert.Equal(t, &dfg.DockerfileData{
+		Directives: dfg.Directives{Syntax: "docker/dockerfile:1", Escape: "\\"},
+		Stages: []dfg.Stage{
+			{Name: "builder", Instructions: []dfg.Instruction{
+				dfg.Arg{Name: "VERSION", Value: "1.13"},
+				dfg.From{Image: "golang:${VERSION}", As: "builder"},
+				dfg.RunCommand{Params: dfg.Params{"apt-get update && apt-get install -y git"}, RunForm: dfg.ShellForm},
+				dfg.EnvVariable{Name: "GOOS", Value: "linux"},
+				dfg.EnvVariable{Name: "GOARCH", Value: "amd 64"},
+				dfg.EnvVariable{Name: "LEGACY", Value: "some value"},
+				dfg.CopyCommand{Sources: []string{"a b"}, Destination: "/dest/", From: "base", Chown: "1000:1000"},
+				dfg.CopyCommand{
+					Sources: []string{"services/*/bin"}, Destination: "/usr/local/", Chmod: "0755", Parents: true,
+					Exclude: []string{"*.md", "**/testdata"},
+				},
+				dfg.Add{
+					Sources: []string{"https://example.com/a.tar.gz"}, Destination: "/", Chmod: "644", Link: true,
+					Checksum: "sha256:24454f830cdb571e2c4ad15481119c43b3cafd48dd869a9b2945d1036d1dc68d",
+				},
+				dfg.Add{Sources: []string{"git@github.com:moby/buildkit.git"}, Destination: "/buildkit", KeepGitDir: true},
+				dfg.RunCommand{
+					Params: dfg.Params{"go build"}, RunForm: dfg.ShellForm, Network: "none",
+					Mounts: []dfg.Mount{
+						{Type: dfg.CacheMount, Target: "/root/.cache/go-build", Sharing: "locked"},
+						{Type: dfg.SecretMount, ID: "netrc", Target: "/root/.netrc", Required: true},
+					},
+				},
+				dfg.RunCommand{
+					Params: dfg.Params{"git", "clone", "git@github.com:moby/buildkit.git"}, RunForm: dfg.ExecForm,
+					Mounts: []dfg.Mount{{Type: dfg.SSHMount}}, Security: "insecure",
+				},
+				dfg.Cmd{Params: dfg.Params{"go", "test"}, RunForm: dfg.ExecForm},
+				dfg.Expose{Ports: []string{"80/tcp", "53/udp", "8000-8010", "${PORT}"}},
+				dfg.StopSignal{Signal: "SIGQUIT"},
+			}},
+			{Instructions: []dfg.Instruction{
+				dfg.From{Image: "alpine", Platform: "$BUILDPLATFORM"},
+				dfg.CopyCommand{Sources: []string{"/app"}, Destination: "/app", From: "builder", Link: true},
+				dfg.Entrypoint{Params: dfg.Params{"./app"}, RunForm: dfg.ShellForm},
+				dfg.HealthCheck{None: true, RunForm: dfg.ShellForm},
+				dfg.Onbuild{Instruction: dfg.CopyCommand{Sources: []string{"."}, Destination: "/src", Chown: "app"}},
+				dfg.Onbuild{Instruction: dfg.EnvVariable{Name: "A", Value: "1"}},
+				dfg.Onbuild{Instruction: dfg.EnvVariable{Name: "B", Value: "x y"}},
+				dfg.Onbuild{Params: dfg.Params{"echo test"}},
+			}},
+		},
+	}, data)
+
+	// the rendered Dockerfile is read back into the same data, including the JSON form COPY paths
+	reparsed, err := Parse(strings.NewReader(render(t, data)))
+	assert.NoError(t, err)
+	assert.Equal(t, data, reparsed)
+}
+
+func TestParseRenderJSONFormPaths(t *testing.T) {
+	instructions := `COPY --from=base --chown=1000:1000 ["a b", "/dest/"]
+ADD --link ["https://example.com/a b.tar.gz", "/opt/"]
+VOLUME ["/var/lib/my data"]
+COPY ["--not-a-flag", "/src/"]
+COPY a.txt b.txt /dest/
+`
+
+	data, err := Parse(strings.NewReader("FROM golang AS base\nFROM alpine\n" + instructions))
+	assert.NoError(t, err)
+	assert.Equal(t, dfg.CopyCommand{Sources: []string{"a b"}, Destination: "/dest/", From: "base", Chown: "1000:1000"}, data.Stages[1].Instructions[1])
+	assert.Equal(t, "# syntax=docker/dockerfile:1.4\nFROM golang as base\n\nFROM alpine\n"+instructions+"\n", render(t, data))
+}
+
+func TestParseEscapeDirective(t *testing.T) {
+	dockerfile := "# escape=`\nFROM mcr.microsoft.com/windows/servercore\nRUN dir c:\\ `\n    && echo done\n"
+
+	data, err := Parse(strings.NewReader(dockerfile))
+	assert.NoError(t, err)
+	assert.Equal(t, dfg.RunCommand{Params: dfg.Params{`dir c:\ && echo done`}, RunForm: dfg.ShellForm}, data.Stages[0].Instructions[1])
+	assert.Equal(t, dfg.Directives{Escape: "`"}, data.Directives)
+}
+
+func TestParseHeredocs(t *testing.T) {
+	dockerfile := "# syntax=docker/dockerfile:1.4\n" +
+		"FROM alpine\n" +
+		"RUN --network=none <<EOF\nset -e\n\n# keeps comments\necho hi\nEOF\n" +
+		"COPY --chown=app <<CONFIG /etc/app.conf\nport = 80\nCONFIG\n" +
+		"RUN cat <<-'EOF' > /motd\n\thello $USER\n\tEOF\n"
+
+	data, err := Parse(strings.NewReader(dockerfile))
+	assert.NoError(t, err)
+	assert.Equal(t, []dfg.Instruction{
+		dfg.From{Image: "alpine"},
+		dfg.RunCommand{Script: "set -e\n\n# keeps comments\necho hi", RunForm: dfg.ShellForm, Network: "none"},
+		dfg.CopyCommand{Content: "port = 80", Destination: "/etc/app.conf", Chown: "app", Delimiter: "CONFIG"},
+		dfg.RunCommand{Params: dfg.Params{"cat <<-'EOF' > /motd\nhello $USER\nEOF"}, RunForm: dfg.ShellForm},
+	}, data.Stages[0].Instructions)
+
+	// << inside quotes, arithmetic or a word isn't a heredoc, the next lines are instructions
+	data, err = Parse(strings.NewReader("FROM alpine\n" +
+		"RUN echo \"cat <<EOF_MARKER_IN_STRING\" 'a <<B'\n" +
+		"RUN echo $((1<<X)) $(( 2 <<Y ))\n" +
+		"RUN cat<<EOF\n" +
+		"USER app\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []dfg.Instruction{
+		dfg.From{Image: "alpine"},
+		dfg.RunCommand{Params: dfg.Params{`echo "cat <<EOF_MARKER_IN_STRING" 'a <<B'`}, RunForm: dfg.ShellForm},
+		dfg.RunCommand{Params: dfg.Params{"echo $((1<<X)) $(( 2 <<Y ))"}, RunForm: dfg.ShellForm},
+		dfg.RunCommand{Params: dfg.Params{"cat<<EOF"}, RunForm: dfg.ShellForm},
+		dfg.User{User: "app"},
+	}, data.Stages[0].Instructions)
+
+	_, err = Parse(strings.NewReader("FROM alpine\nRUN <<EOF\necho hi\n"))
+	assert.EqualError(t, err, "2: Heredoc isn't terminated, expected EOF on its own line")
+
+	_, err = Parse(strings.NewReader("FROM alpine\nCOPY --from=builder <<EOF /a\nhi\nEOF\n"))
+	assert.EqualError(t, err, "2: stages.stage0[1]: COPY heredocs are supported in the form of COPY <<EOF <dest>")
+}
+
+func TestParseErrors(t *testing.T) {
+	dockerfile := `RUN echo before from
+FROM alpine AS final
+COPY --exclude=*.md --checksum=sha256:abc app /app
+MAINTAINER ozan
+EXPOSE 70000
+RUN --mount=type=cache echo
+HEALTHCHECK --interval=soon CMD true
+COPY --chmod=u+x app /app
+ONBUILD FROM alpine
+ONBUILD MAINTAINER ozan
+ADD --checksum=sha256:abc https://example.com/a /a
+`
+
+	_, err := Parse(strings.NewReader(dockerfile))
+	assert.EqualError(t, err, `1: RUN instruction found before FROM
+3: stages.final[1]: Unsupported flag --checksum
+4: stages.final[1]: Unsupported instruction MAINTAINER
+5: stages.final[1]: Invalid port "70000", expected a number between 1 and 65535
+6: stages.final[1]: cache mounts require a target
+7: stages.final[1]: Invalid HEALTHCHECK --interval "soon", expected a duration, e.g. 30s
+8: stages.final[1]: Invalid COPY --chmod "u+x", expected an octal file mode, e.g. 0755
+9: stages.final[1]: FROM isn't allowed as an ONBUILD trigger
+10: stages.final[1]: MAINTAINER isn't allowed as an ONBUILD trigger
+11: stages.final[1]: Invalid ADD --checksum "sha256:abc", expected <algorithm>:<hex digest> with sha256, sha384 or sha512`)
+
+	_, err = Parse(strings.NewReader("# only a comment\n"))
+	assert.EqualError(t, err, "Dockerfile doesn't contain a FROM instruction")
+}
+
+func TestParseFile(t *testing.T) {
+	_, err := ParseFile("non-existent")
+	assert.Error(t, err)
+}
@@ -5,9 +5,11 @@ and spits out a generated Dockerfile.
 package dockerfilegenerator
 
 import (
+	"errors"
 	"fmt"
 	"gopkg.in/yaml.v3"
 	"io"
+	"io/ioutil"
 	"strconv"
 	"text/template"
 )
@@ -22,28 +24,29 @@ func NewDockerfileTemplate(data *DockerfileData) *DockerfileTemplate {
 	return &DockerfileTemplate{Data: data}
 }
 
-// Tries to return a *yaml.Node based on the given targetField
-func getTargetNode(node *yaml.Node, targetField string) (*yaml.Node, error) {
-	type part struct {
-		kind   string
-		val    string
-		intVal int
-	}
+// targetFieldPart is a single step of a targetField, either a map key or a seq index
+type targetFieldPart struct {
+	kind   string
+	val    string
+	intVal int
+}
 
-	last := func(parts []part) *part {
+// Splits the given targetField into its parts, e.g. ".serverConfigs[0].docker" has three parts
+func parseTargetField(targetField string) ([]targetFieldPart, error) {
+	last := func(parts []targetFieldPart) *targetFieldPart {
 		return &(parts[len(parts)-1])
 	}
 
-	var parts []part
-	var curPart part
+	var parts []targetFieldPart
+	var curPart targetFieldPart
 
 	for _, char := range targetField {
 		if char == '.' {
-			curPart = part{kind: "map"}
+			curPart = targetFieldPart{kind: "map"}
 			parts = append(parts, curPart)
 			continue
 		} else if char == '[' {
-			curPart = part{kind: "seq"}
+			curPart = targetFieldPart{kind: "seq"}
 			parts = append(parts, curPart)
 			continue
 		} else if char == ']' {
@@ -69,6 +72,16 @@ func getTargetNode(node *yaml.Node, targetField string) (*yaml.Node, error) {
 		}
 	}
 
+	return parts, nil
+}
+
+// Tries to return a *yaml.Node based on the given targetField
+func getTargetNode(node *yaml.Node, targetField string) (*yaml.Node, error) {
+	parts, err := parseTargetField(targetField)
+	if err != nil {
+		return nil, err
+	}
+
 	curNode := node.Content[0]
 
 	for _, part := range parts {
@@ -77,17 +90,21 @@ func getTargetNode(node *yaml.Node, targetField string) (*yaml.Node, error) {
 				return nil, fmt.Errorf("Expected a map with key %s", part.val)
 			}
 
-			for pos, curContent := range curNode.Content {
-				if curContent.Value == part.val {
-					curNode = curNode.Content[pos+1]
-					break
-				}
+			valueNode := getMappingValueNode(curNode, part.val)
+			if valueNode == nil {
+				return nil, fmt.Errorf("Can't find key %s", part.val)
 			}
+
+			curNode = valueNode
 		} else if part.kind == "seq" {
 			if curNode.Kind != yaml.SequenceNode {
 				return nil, fmt.Errorf("Expected a seq with key %s", part.val)
 			}
 
+			if part.intVal < 0 || part.intVal >= len(curNode.Content) {
+				return nil, fmt.Errorf("Index %d is out of range", part.intVal)
+			}
+
 			curNode = curNode.Content[part.intVal]
 		}
 	}
@@ -95,73 +112,299 @@ func getTargetNode(node *yaml.Node, targetField string) (*yaml.Node, error) {
 	return curNode, nil
 }
 
-func getStagesDataFromNode(node *yaml.Node) ([]Stage, error) {
-	var data DockerfileDataYaml
+func newNodeForTargetFieldPart(part targetFieldPart) *yaml.Node {
+	if part.kind == "seq" {
+		return &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	}
 
-	stagesInOrder, err := getStagesOrderFromYamlNode(node)
+	return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+}
+
+// SetTargetNode places the value node at the given targetField of a yaml document node, it's the counterpart
+// of reading a file with a target field. Missing map keys are created, a seq index may point one past the end to append.
+func SetTargetNode(node *yaml.Node, targetField string, value *yaml.Node) error {
+	parts, err := parseTargetField(targetField)
 	if err != nil {
-		return nil, fmt.Errorf("Unmarshal: %v", err)
+		return err
+	}
+
+	if len(parts) == 0 {
+		*node = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{value}}
+		return nil
+	}
+
+	if node.Kind != yaml.DocumentNode || len(node.Content) == 0 {
+		*node = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{newNodeForTargetFieldPart(parts[0])}}
+	}
+
+	curNode := node.Content[0]
+
+	for i, part := range parts {
+		next := value
+		if i < len(parts)-1 {
+			next = newNodeForTargetFieldPart(parts[i+1])
+		}
+
+		if part.kind == "map" {
+			if curNode.Kind != yaml.MappingNode {
+				return fmt.Errorf("Expected a map with key %s", part.val)
+			}
+
+			valueNode := getMappingValueNode(curNode, part.val)
+			if valueNode == nil || i == len(parts)-1 {
+				setMappingValueNode(curNode, part.val, next)
+				valueNode = next
+			}
+
+			curNode = valueNode
+		} else if part.kind == "seq" {
+			if curNode.Kind != yaml.SequenceNode {
+				return fmt.Errorf("Expected a seq with key %s", part.val)
+			}
+
+			if part.intVal < 0 || part.intVal > len(curNode.Content) {
+				return fmt.Errorf("Index %d is out of range", part.intVal)
+			}
+
+			if part.intVal == len(curNode.Content) {
+				curNode.Content = append(curNode.Content, next)
+			} else if i == len(parts)-1 {
+				curNode.Content[part.intVal] = next
+			}
+
+			curNode = curNode.Content[part.intVal]
+		}
 	}
 
-	if err := node.Decode(&data); err != nil {
+	return nil
+}
+
+func getStagesDataFromNode(node *yaml.Node) ([]Stage, error) {
+	stagesInOrder, err := getStagesOrderFromYamlNode(node)
+	if err != nil {
 		return nil, err
 	}
 
+	stagesMapNode := getMappingValueNode(node, "stages")
+
 	var stages []Stage
-	for _, stageName := range stagesInOrder {
-		stages = append(stages, data.Stages[stageName])
+	var errs ConfigErrors
+	for i, stageName := range stagesInOrder {
+		stage, stageErrs := decodeStageNode(stagesMapNode.Content[i*2+1])
+		for _, stageErr := range stageErrs {
+			stageErr.Stage = stageName
+		}
+
+		// the map key is the name of the stage, it is rendered as the AS alias when the FROM instruction has none
+		stage.Name = stageName
+		if keyNode := stagesMapNode.Content[i*2]; stage.Comment == "" && keyNode.HeadComment != "" {
+			stage.Comment = yamlComment(keyNode.HeadComment)
+		}
+		errs = append(errs, stageErrs...)
+		stages = append(stages, stage)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
 	}
 
 	return stages, nil
 }
 
+// newDockerFileDataFromYamlNode decodes the given node, errors caused by the config itself are returned as ConfigErrors.
+// The included files, the extended config and the overlays are merged and the patches and the snippets are applied
+// first, then the variable references are resolved with the vars of the config overridden by the given ones and the
+// stages and instructions whose when expression is false are dropped.
+func newDockerFileDataFromYamlNode(node *yaml.Node, targetField string, opts DecodeOptions) (*DockerfileData, error) {
+	targetNode := node.Content[0]
+
+	if targetField != "" {
+		var err error
+		targetNode, err = getTargetNode(node, targetField)
+		if err != nil {
+			return nil, fmt.Errorf("Can't decode target val: %v", err)
+		}
+	}
+
+	in := newIncludes(opts.Filename)
+	errs := in.resolve(targetNode, node, opts.Filename, targetField)
+	for _, overlay := range opts.Overlays {
+		if len(errs) == 0 {
+			errs = in.overlay(targetNode, overlay)
+		}
+	}
+	if len(errs) == 0 {
+		errs = applySnippets(targetNode, in.origins)
+	}
+
+	var vars map[string]string
+	if len(errs) == 0 {
+		vars, errs = resolveTargetVars(targetNode, opts.Vars, in.origins)
+	}
+	if len(errs) == 0 {
+		errs = applyWhen(targetNode, vars)
+	}
+	if len(errs) > 0 {
+		in.setFilename(errs)
+		return nil, errs
+	}
+
+	stages, err := getStagesDataFromNode(targetNode)
+	if errs, ok := err.(ConfigErrors); ok {
+		in.setFilename(errs)
+		return nil, errs
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Can't extract stages from node: %v", err)
+	}
+
+	data := &DockerfileData{Stages: stages}
+
+	if directivesNode := getMappingValueNode(targetNode, "directives"); directivesNode != nil {
+		directives, errs := decodeDirectivesNode(directivesNode)
+		if len(errs) > 0 {
+			in.setFilename(errs)
+			return nil, errs
+		}
+		data.Directives = directives
+	}
+
+	if scriptFormNode := getMappingValueNode(targetNode, "scriptForm"); scriptFormNode != nil {
+		data.ScriptForm = ScriptForm(scriptFormNode.Value)
+
+		if err := validateScript("", "", data.ScriptForm); err != nil {
+			errs := ConfigErrors{newConfigError(scriptFormNode, "%v", err)}
+			in.setFilename(errs)
+			return nil, errs
+		}
+	}
+
+	if platformsNode := getMappingValueNode(targetNode, "platforms"); platformsNode != nil {
+		platforms, errs := decodePlatformsNode(platformsNode)
+		if len(errs) > 0 {
+			in.setFilename(errs)
+			return nil, errs
+		}
+		data.Platforms = platforms
+	}
+
+	return data, nil
+}
+
 // NewDockerFileDataFromYamlField reads a YAML file and tries to extract Dockerfile data
 // from the specified targetField option, examples:
 // --target-field ".dev.dockerfileConfig"
 // --target-field ".serverConfigs[0].docker.server"
+// Problems in the config are reported together as ConfigErrors.
 func NewDockerFileDataFromYamlField(filename, targetField string) (*DockerfileData, error) {
 	node := yaml.Node{}
 
 	err := unmarshallYamlFile(filename, &node)
-	if err != nil {
-		return nil, fmt.Errorf("Unmarshal: %v", err)
-	}
-
-	targetNode, err := getTargetNode(&node, targetField)
-	if err != nil {
-		return nil, fmt.Errorf("Can't decode target val: %v", err)
+	if _, ok := err.(ConfigErrors); ok {
+		return nil, err
 	}
-
-	stages, err := getStagesDataFromNode(targetNode)
 	if err != nil {
-		return nil, fmt.Errorf("Can't extract stages from node: %v", err)
+		return nil, fmt.Errorf("Unmarshal: %v", err)
 	}
 
-	return &DockerfileData{Stages: stages}, nil
+	return newDockerFileDataFromYamlNode(&node, targetField, DecodeOptions{Filename: filename})
 }
 
-// NewDockerFileDataFromYamlFile reads a file and returns a *DockerfileData
+// NewDockerFileDataFromYamlFile reads a file and returns a *DockerfileData.
+// Problems in the config are reported together as ConfigErrors.
 func NewDockerFileDataFromYamlFile(filename string) (*DockerfileData, error) {
 	node := yaml.Node{}
 
 	err := unmarshallYamlFile(filename, &node)
+	if _, ok := err.(ConfigErrors); ok {
+		return nil, err
+	}
 	if err != nil {
 		return nil, fmt.Errorf("Unmarshal: %v", err)
 	}
 
-	// passing node.Content[0] because the file is expected to store solely the dockerfile config
-	stages, err := getStagesDataFromNode(node.Content[0])
+	// passing an empty target field because the file is expected to store solely the dockerfile config
+	return newDockerFileDataFromYamlNode(&node, "", DecodeOptions{Filename: filename})
+}
+
+// DecodeOptions are the options of the reader constructors, e.g. NewDockerFileDataFromYamlReaderWithOptions
+type DecodeOptions struct {
+	// Filename is where the content comes from, it is set on the ConfigErrors and the included files are relative to it
+	Filename string
+
+	// Vars override the ones under the vars key, e.g. for dfg generate --set
+	Vars map[string]string
+
+	// Overlays are config files merged over the input in the given order, e.g. for dfg generate --overlay
+	Overlays []string
+}
+
+// NewDockerFileDataFromYamlReader reads YAML from r, e.g. os.Stdin, and extracts Dockerfile data from the targetField,
+// an empty targetField means the whole document. ConfigErrors returned by it have no Filename, the included files are
+// relative to the working directory.
+func NewDockerFileDataFromYamlReader(r io.Reader, targetField string) (*DockerfileData, error) {
+	return NewDockerFileDataFromYamlReaderWithOptions(r, targetField, DecodeOptions{})
+}
+
+// NewDockerFileDataFromYamlReaderWithOptions works like NewDockerFileDataFromYamlReader with the given DecodeOptions,
+// e.g. the variables of dfg generate --set.
+func NewDockerFileDataFromYamlReaderWithOptions(r io.Reader, targetField string, opts DecodeOptions) (*DockerfileData, error) {
+	content, err := ioutil.ReadAll(r)
 	if err != nil {
-		return nil, fmt.Errorf("Can't extract stages from node: %v", err)
+		return nil, fmt.Errorf("Unmarshal: yamlReader.Read err #%v", err)
 	}
 
-	return &DockerfileData{Stages: stages}, nil
+	node := yaml.Node{}
+	if err := unmarshallYaml(content, opts.Filename, &node); err != nil {
+		return nil, err
+	}
+
+	return newDockerFileDataFromYamlNode(&node, targetField, opts)
 }
 
 // Render iterates through the given dockerfile instruction instances and executes the template.
-// The output would be a generated Dockerfile.
+// The output would be a generated Dockerfile. The data is validated first, nothing is written when Validate
+// returns ConfigErrors.
 func (d *DockerfileTemplate) Render(writer io.Writer) error {
-	templateString := "{{- range .Stages -}}" +
+	if d.Data == nil {
+		return errors.New("Can't render nil Dockerfile data")
+	}
+
+	if err := d.Data.Validate(); err != nil {
+		return err
+	}
+
+	stages := make([][]Instruction, len(d.Data.Stages))
+	for i, stage := range d.Data.Stages {
+		stages[i] = stage.renderInstructions(d.Data.ScriptForm)
+	}
+
+	stages, err := d.Data.withCrossCompilation(stages)
+	if err != nil {
+		return err
+	}
+
+	// BuildKit-only features are parsed by the docker/dockerfile frontend, the classic builder rejects them
+	directives := d.Data.Directives
+	if directives.Syntax == "" {
+		directives.Syntax = minimalSyntax(stages)
+	}
+
+	for i, stage := range d.Data.Stages {
+		stages[i] = withEscape(directives.Escape, withComments(stage.Comment, stages[i]))
+	}
+
+	header := directives.render()
+	if startsWithDirectiveComment(stages) {
+		// an empty line ends the parser directives, the comment isn't read as one
+		header += "\n"
+	}
+
+	if _, err := io.WriteString(writer, header); err != nil {
+		return err
+	}
+
+	templateString := "{{- range . -}}" +
 		"{{- range $i, $instruction := . }}" +
 		"{{- if gt $i 0 }}\n{{ end }}" +
 		"{{ $instruction.Render }}\n" +
@@ -173,7 +416,7 @@ func (d *DockerfileTemplate) Render(writer io.Writer) error {
 		return err
 	}
 
-	err = tmpl.Execute(writer, d.Data)
+	err = tmpl.Execute(writer, stages)
 	if err != nil {
 		return err
 	}
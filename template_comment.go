@@ -0,0 +1,173 @@
+package dockerfilegenerator
+
+import (
+	"regexp"
+	"strings"
+)
+
+// directiveCommentRegexp matches the comments that Docker reads as parser directives at the top of a Dockerfile,
+// e.g. # escape=`
+var directiveCommentRegexp = regexp.MustCompile(`^#\s*[a-zA-Z][a-zA-Z0-9]*\s*=`)
+
+// Comment represents a comment in the Dockerfile, see https://docs.docker.com/engine/reference/builder/#format
+// Every line of the text is rendered as a # line.
+type Comment struct {
+	Text string `yaml:"text"`
+}
+
+// Render returns a string in the form of # <text>
+func (c Comment) Render() string {
+	lines := strings.Split(strings.TrimRight(c.Text, "\n"), "\n")
+	for i, line := range lines {
+		if line == "" {
+			lines[i] = "#"
+		} else {
+			lines[i] = "# " + line
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// withComments returns the instructions with a Comment in front of the commented ones, the stage comment comes first.
+// Instructions don't render their own Comment field, the template adds it with this function.
+func withComments(stageComment string, instructions []Instruction) []Instruction {
+	var res []Instruction
+
+	if stageComment != "" {
+		res = append(res, Comment{Text: stageComment})
+	}
+
+	for _, instruction := range instructions {
+		if comment := instructionComment(instruction); comment != "" {
+			res = append(res, Comment{Text: comment})
+		}
+
+		res = append(res, instruction)
+	}
+
+	return res
+}
+
+// instructionComment returns the Comment field of the instruction, empty for the instructions that don't have one
+func instructionComment(instruction Instruction) string {
+	switch v := instruction.(type) {
+	case Arg:
+		return v.Comment
+	case From:
+		return v.Comment
+	case Label:
+		return v.Comment
+	case Volume:
+		return v.Comment
+	case RunCommand:
+		return v.Comment
+	case EnvVariable:
+		return v.Comment
+	case CopyCommand:
+		return v.Comment
+	case Add:
+		return v.Comment
+	case Cmd:
+		return v.Comment
+	case Entrypoint:
+		return v.Comment
+	case Onbuild:
+		return v.Comment
+	case HealthCheck:
+		return v.Comment
+	case Shell:
+		return v.Comment
+	case Workdir:
+		return v.Comment
+	case Expose:
+		return v.Comment
+	case StopSignal:
+		return v.Comment
+	case User:
+		return v.Comment
+	}
+
+	return ""
+}
+
+// setInstructionComment returns a copy of the instruction with the given Comment field, the instructions that don't
+// have one are returned as they are
+func setInstructionComment(instruction Instruction, comment string) Instruction {
+	switch v := instruction.(type) {
+	case Arg:
+		v.Comment = comment
+		return v
+	case From:
+		v.Comment = comment
+		return v
+	case Label:
+		v.Comment = comment
+		return v
+	case Volume:
+		v.Comment = comment
+		return v
+	case RunCommand:
+		v.Comment = comment
+		return v
+	case EnvVariable:
+		v.Comment = comment
+		return v
+	case CopyCommand:
+		v.Comment = comment
+		return v
+	case Add:
+		v.Comment = comment
+		return v
+	case Cmd:
+		v.Comment = comment
+		return v
+	case Entrypoint:
+		v.Comment = comment
+		return v
+	case Onbuild:
+		v.Comment = comment
+		return v
+	case HealthCheck:
+		v.Comment = comment
+		return v
+	case Shell:
+		v.Comment = comment
+		return v
+	case Workdir:
+		v.Comment = comment
+		return v
+	case Expose:
+		v.Comment = comment
+		return v
+	case StopSignal:
+		v.Comment = comment
+		return v
+	case User:
+		v.Comment = comment
+		return v
+	}
+
+	return instruction
+}
+
+// yamlComment returns the text of a yaml.v3 comment, e.g. "# first\n# second" is returned as "first\nsecond"
+func yamlComment(comment string) string {
+	lines := strings.Split(comment, "\n")
+	for i, line := range lines {
+		line = strings.TrimPrefix(strings.TrimSpace(line), "#")
+		lines[i] = strings.TrimPrefix(line, " ")
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// startsWithDirectiveComment reports whether the first rendered line is a comment in the form of a parser directive
+func startsWithDirectiveComment(stages [][]Instruction) bool {
+	if len(stages) == 0 || len(stages[0]) == 0 {
+		return false
+	}
+
+	comment, ok := stages[0][0].(Comment)
+	return ok && directiveCommentRegexp.MatchString(comment.Render())
+}
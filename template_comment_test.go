@@ -0,0 +1,119 @@
+package dockerfilegenerator
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"strings"
+	"testing"
+)
+
+func TestComments(t *testing.T) {
+	data := &DockerfileData{
+		Directives: Directives{Syntax: "docker/dockerfile:1"},
+		Stages: []Stage{
+			{Name: "builder", Comment: "builds the binary", Instructions: []Instruction{
+				Arg{Name: "VERSION", Comment: "the Go version"},
+				From{Image: "golang:${VERSION}"},
+				Comment{Text: "dependencies are cached\n\nin their own layer"},
+				RunCommand{Params: []string{"go mod download"}},
+				User{User: "ozan", Comment: "no root"},
+			}},
+		},
+	}
+
+	output := &bytes.Buffer{}
+	assert.NoError(t, NewDockerfileTemplate(data).Render(output))
+	assert.Equal(t, `# syntax=docker/dockerfile:1
+# builds the binary
+# the Go version
+ARG VERSION
+FROM golang:${VERSION} as builder
+# dependencies are cached
+#
+# in their own layer
+RUN go mod download
+# no root
+USER ozan
+
+`, output.String())
+
+	// the comment isn't part of the rendered instruction itself
+	assert.Equal(t, "USER ozan", User{User: "ozan", Comment: "no root"}.Render())
+}
+
+func TestCommentsDirectiveForm(t *testing.T) {
+	// a first comment in the form of a parser directive is separated by an empty line, Docker would read it as one
+	data := &DockerfileData{
+		Stages: []Stage{
+			{Name: "final", Comment: "escape=`", Instructions: []Instruction{From{Image: "alpine"}}},
+		},
+	}
+
+	output := &bytes.Buffer{}
+	assert.NoError(t, NewDockerfileTemplate(data).Render(output))
+	assert.Equal(t, "\n# escape=`\nFROM alpine as final\n\n", output.String())
+
+	data.Directives.Syntax = "docker/dockerfile:1"
+	data.Stages[0].Instructions = []Instruction{Comment{Text: "check=skip=all"}, From{Image: "alpine"}}
+	data.Stages[0].Comment = ""
+	output.Reset()
+	assert.NoError(t, NewDockerfileTemplate(data).Render(output))
+	assert.Equal(t, "# syntax=docker/dockerfile:1\n\n# check=skip=all\nFROM alpine as final\n\n", output.String())
+
+	// other comments follow the directives directly
+	data.Stages[0].Instructions[0] = Comment{Text: "base image: alpine"}
+	output.Reset()
+	assert.NoError(t, NewDockerfileTemplate(data).Render(output))
+	assert.Equal(t, "# syntax=docker/dockerfile:1\n# base image: alpine\nFROM alpine as final\n\n", output.String())
+}
+
+func TestCommentsYaml(t *testing.T) {
+	data, err := NewDockerFileDataFromYamlReader(strings.NewReader(`
+stages:
+  # builds the binary
+  builder:
+    # the base image
+    # is pinned
+    - from:
+        image: golang:1.13
+    - comment: |
+        dependencies are cached
+        in their own layer
+    # a yaml comment
+    - run:
+        params: [go mod download]
+        comment: the comment key wins
+    - user: ozan # line comments are ignored
+  final:
+    comment: the runtime image
+    instructions:
+      # the user is set below
+      - user: ozan
+`), "")
+	assert.NoError(t, err)
+	assert.Equal(t, []Stage{
+		{Name: "builder", Comment: "builds the binary", Instructions: []Instruction{
+			From{Image: "golang:1.13", Comment: "the base image\nis pinned"},
+			Comment{Text: "dependencies are cached\nin their own layer\n"},
+			RunCommand{Params: []string{"go mod download"}, RunForm: ShellForm, Comment: "the comment key wins"},
+			User{User: "ozan"},
+		}},
+		{Name: "final", Comment: "the runtime image", Instructions: []Instruction{
+			User{User: "ozan", Comment: "the user is set below"},
+		}},
+	}, data.Stages)
+
+	out, err := yaml.Marshal(data.Stages[1])
+	assert.NoError(t, err)
+	assert.Equal(t, `comment: the runtime image
+instructions:
+  - user:
+        user: ozan
+        comment: the user is set below
+`, string(out))
+
+	var stage Stage
+	err = yaml.Unmarshal([]byte("comment: [a]\ninstructions: []\n"), &stage)
+	assert.EqualError(t, err, "1:10: Stage comment should be a string")
+}
@@ -1,8 +1,14 @@
 package dockerfilegenerator
 
 import (
+	"errors"
 	"fmt"
+	"gopkg.in/yaml.v3"
+	"path"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // RunForm specifies in which form the instruction string should be constructed.
@@ -24,6 +30,9 @@ const (
 
 	// EntrypointDefaultRunForm is the default RunForm for Entrypoint
 	EntrypointDefaultRunForm = ExecForm
+
+	// HealthCheckDefaultRunForm is the default RunForm for HealthCheck
+	HealthCheckDefaultRunForm = ShellForm
 )
 
 // Instruction represents a Dockerfile instruction, e.g. FROM alpine:latest
@@ -33,41 +42,168 @@ type Instruction interface {
 
 // DockerfileData struct can hold multiple stages for a multi-staged Dockerfile
 // Check https://docs.docker.com/develop/develop-images/multistage-build/ for more information
+// Directives are rendered on top of the Dockerfile, ScriptForm is the default script form of the RUN scripts
+// and COPY contents that don't set one. Platforms are the target platforms of a multi-platform build,
+// the builder stages are run on the build platform and get the TARGETOS and TARGETARCH ARGs to cross-compile.
 type DockerfileData struct {
-	Stages []Stage `yaml:"stages,omitempty"`
+	Directives Directives `yaml:"directives,omitempty"`
+	ScriptForm ScriptForm `yaml:"scriptForm,omitempty"`
+	Platforms  []string   `yaml:"platforms,omitempty"`
+	Stages     []Stage    `yaml:"stages,omitempty"`
+}
+
+// Stage is a named set of instructions, the purpose is to keep the order of the given instructions
+// and generate a Dockerfile using the output of these instructions.
+// Name and Platform are filled into the stage's FROM instruction when it doesn't set AS or --platform itself.
+// Comment is rendered as # lines above the stage.
+type Stage struct {
+	Name         string
+	Platform     string
+	DependsOn    []string
+	Comment      string
+	Instructions []Instruction
 }
 
-// Stage is a set of instructions, the purpose is to keep the order of the given instructions
-// and generate a Dockerfile using the output of these instructions
-type Stage []Instruction
+// NewStage returns a stage with the given name and instructions, it is the migration path for the Stage literals
+// that used to be a plain []Instruction, e.g. dfg.NewStage("", []dfg.Instruction{...}...)
+func NewStage(name string, instructions ...Instruction) Stage {
+	return Stage{Name: name, Instructions: instructions}
+}
 
 // UnmarshalYAML implements an interface to let go-yaml be able to decode Stages in to Stage struct
-func (s *Stage) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	var data []interface{}
-	var result []Instruction
-	err := unmarshal(&data)
-	if err != nil {
-		return err
+func (s *Stage) UnmarshalYAML(node *yaml.Node) error {
+	stage, errs := decodeStageNode(node)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	*s = stage
+	return nil
+}
+
+// from returns the first FROM instruction of the stage
+func (s Stage) from() (From, bool) {
+	for _, instruction := range s.Instructions {
+		if from, ok := instruction.(From); ok {
+			return from, true
+		}
+	}
+
+	return From{}, false
+}
+
+// Alias returns the name the stage can be referred to with in the Dockerfile, the AS alias of its FROM instruction
+// wins over the Name since the stage is rendered with it
+func (s Stage) Alias() string {
+	if from, ok := s.from(); ok && from.As != "" {
+		return from.As
+	}
+
+	return s.Name
+}
+
+// BaseImage returns the image of the stage's FROM instruction, an empty string if it has none
+func (s Stage) BaseImage() string {
+	from, _ := s.from()
+	return from.Image
+}
+
+// BuildArgs returns the ARG instructions of the stage in order
+func (s Stage) BuildArgs() []Arg {
+	var args []Arg
+	for _, instruction := range s.Instructions {
+		if arg, ok := instruction.(Arg); ok {
+			args = append(args, arg)
+		}
+	}
+
+	return args
+}
+
+// renderInstructions returns the instructions to render, the first FROM instruction gets the stage's Name and
+// Platform unless it sets them itself, scripts and contents get the given scriptForm unless they set one
+func (s Stage) renderInstructions(scriptForm ScriptForm) []Instruction {
+	instructions := make([]Instruction, len(s.Instructions))
+	copy(instructions, s.Instructions)
+
+	fromFound := false
+	for i, instruction := range instructions {
+		switch v := instruction.(type) {
+		case From:
+			if fromFound {
+				continue
+			}
+			fromFound = true
+
+			if v.As == "" {
+				v.As = s.Name
+			}
+			if v.Platform == "" {
+				v.Platform = s.Platform
+			}
+
+			instructions[i] = v
+		case RunCommand, CopyCommand:
+			instructions[i] = withScriptForm(v, scriptForm)
+		case Onbuild:
+			v.Instruction = withScriptForm(v.Instruction, scriptForm)
+			instructions[i] = v
+		}
+	}
+
+	return instructions
+}
+
+// withScriptForm returns a RUN or COPY instruction with the given script form unless it has its own one
+func withScriptForm(instruction Instruction, scriptForm ScriptForm) Instruction {
+	switch v := instruction.(type) {
+	case RunCommand:
+		if v.ScriptForm == "" {
+			v.ScriptForm = scriptForm
+		}
+
+		return v
+	case CopyCommand:
+		if v.ScriptForm == "" {
+			v.ScriptForm = scriptForm
+		}
+
+		return v
+	}
+
+	return instruction
+}
+
+// Stage returns the stage with the given name or alias, nil if there is no such stage
+func (d *DockerfileData) Stage(name string) *Stage {
+	if index := d.stageIndex(name); index >= 0 {
+		return &d.Stages[index]
 	}
 
-	*s = append(result, cleanUpInterfaceArray(data)...)
 	return nil
 }
 
 // Arg represents a Dockerfile instruction, see https://docs.docker.com/engine/reference/builder/#arg
+// The value is quoted when needed, Literal escapes the $ characters so variables in it aren't expanded.
 type Arg struct {
 	Name        string `yaml:"name"`
 	Value       string `yaml:"value"`
+	Literal     bool   `yaml:"literal,omitempty"`
 	Test        bool   `yaml:"test,omitempty"`
 	EnvVariable bool   `yaml:"envVariable,omitempty"`
+	Comment     string `yaml:"comment"`
 }
 
 // Render returns a string in the form of ARG <name>[=<default value>]
 func (a Arg) Render() string {
+	return a.renderEscaped(DefaultEscape)
+}
+
+func (a Arg) renderEscaped(escape rune) string {
 	res := fmt.Sprintf("ARG %s", a.Name)
 
 	if a.Value != "" {
-		res = fmt.Sprintf("%s=%s", res, a.Value)
+		res = fmt.Sprintf("%s=%s", res, quoteValue(a.Value, a.Literal, escape))
 	}
 
 	if a.Test {
@@ -81,15 +217,33 @@ func (a Arg) Render() string {
 	return res
 }
 
+// Validate checks that the default value can be written on a single line
+func (a Arg) Validate() error {
+	if strings.Contains(a.Value, "\n") {
+		return errors.New("ARG value can't contain a newline")
+	}
+
+	return nil
+}
+
 // From represents a Dockerfile instruction, see https://docs.docker.com/engine/reference/builder/#from
+// Image is an image reference or the name of a previous stage, see ImageReference for its parts.
 type From struct {
-	Image string `yaml:"image"`
-	As    string `yaml:"as"`
+	Image    string `yaml:"image"`
+	As       string `yaml:"as"`
+	Platform string `yaml:"platform"`
+	Comment  string `yaml:"comment"`
 }
 
-// Render returns a string in the form of FROM <image> [AS <name>]
+// Render returns a string in the form of FROM [--platform=<platform>] <image> [AS <name>]
 func (f From) Render() string {
-	res := fmt.Sprintf("FROM %s", f.Image)
+	res := "FROM "
+
+	if f.Platform != "" {
+		res = fmt.Sprintf("%s--platform=%s ", res, f.Platform)
+	}
+
+	res += f.Image
 
 	if f.As != "" {
 		res = fmt.Sprintf("%s as %s", res, f.As)
@@ -98,68 +252,355 @@ func (f From) Render() string {
 	return res
 }
 
+// Reference returns the parts of the image, the image of a FROM <stage> instruction is parsed as a repository
+func (f From) Reference() (ImageReference, error) {
+	return ParseImageReference(f.Image)
+}
+
+// Validate checks the image reference and the platform, ARG references are accepted as they are
+func (f From) Validate() error {
+	if !strings.Contains(f.Image, "$") {
+		if _, err := f.Reference(); err != nil {
+			return err
+		}
+	}
+
+	return f.validatePlatform()
+}
+
+func (f From) validatePlatform() error {
+	if f.Platform == "" {
+		return nil
+	}
+
+	return validatePlatform(f.Platform)
+}
+
 // Label represents a Dockerfile instruction, see https://docs.docker.com/engine/reference/builder/#from
+// The name and the value are quoted when needed, Literal escapes the $ characters so variables in them aren't expanded.
 type Label struct {
-	Name  string `yaml:"name"`
-	Value string `yaml:"value"`
+	Name    string `yaml:"name"`
+	Value   string `yaml:"value"`
+	Literal bool   `yaml:"literal"`
+	Comment string `yaml:"comment"`
 }
 
 // Render returns a string in the form of LABEL <key>=<value>
 func (l Label) Render() string {
-	return fmt.Sprintf("LABEL %s=%s", l.Name, l.Value)
+	return l.renderEscaped(DefaultEscape)
+}
+
+func (l Label) renderEscaped(escape rune) string {
+	return fmt.Sprintf("LABEL %s=%s", quoteValue(l.Name, l.Literal, escape), quoteValue(l.Value, l.Literal, escape))
+}
+
+// Validate checks that the label can be written on a single line
+func (l Label) Validate() error {
+	if strings.Contains(l.Name, "\n") || strings.Contains(l.Value, "\n") {
+		return errors.New("LABEL can't contain a newline")
+	}
+
+	return nil
 }
 
 // Volume represents a Dockerfile instruction, see https://docs.docker.com/engine/reference/builder/#volume
 type Volume struct {
 	Source      string `yaml:"source"`
 	Destination string `yaml:"destination"`
+	Comment     string `yaml:"comment"`
 }
 
-// Render returns a string in the form of VOLUME <source> <destination>
+// Render returns a string in the form of VOLUME <source> [<destination>]
+// or VOLUME ["<source>", "<destination>"] when a path needs quoting
 func (v Volume) Render() string {
-	return fmt.Sprintf("VOLUME %s %s", v.Source, v.Destination)
+	return v.renderEscaped(DefaultEscape)
+}
+
+func (v Volume) renderEscaped(escape rune) string {
+	paths := []string{v.Source}
+	if v.Destination != "" {
+		paths = append(paths, v.Destination)
+	}
+
+	return fmt.Sprintf("VOLUME %s", renderPaths(paths, escape))
 }
 
 // RunCommand represents a Dockerfile instruction, see https://docs.docker.com/engine/reference/builder/#run
+// Mounts, Network and Security are BuildKit flags, see https://docs.docker.com/engine/reference/builder/#run---mount
+// Script is a multi-line script used instead of Params, it is rendered in the ScriptForm, a heredoc by default.
 type RunCommand struct {
-	Params  `yaml:"params"`
-	RunForm `yaml:"runForm"`
+	Params     `yaml:"params"`
+	RunForm    `yaml:"runForm"`
+	Mounts     []Mount `yaml:"mounts"`
+	Network    string  `yaml:"network"`
+	Security   string  `yaml:"security"`
+	Script     string  `yaml:"script"`
+	Delimiter  string  `yaml:"delimiter"`
+	ScriptForm `yaml:"scriptForm"`
+	Comment    string `yaml:"comment"`
 }
 
-// Render returns a string in the form of RUN <command>
+// Render returns a string in the form of RUN [--mount=<mount>]... [--network=<network>] [--security=<security>] <command>
+// or RUN [<flags>] <<EOF\n<script>\nEOF
 func (r RunCommand) Render() string {
+	return r.renderEscaped(DefaultEscape)
+}
+
+func (r RunCommand) renderEscaped(escape rune) string {
 	if r.RunForm == "" {
 		r.RunForm = RunCommandDefaultRunForm
 	}
 
+	res := "RUN"
+
+	for _, mount := range r.Mounts {
+		res = fmt.Sprintf("%s --mount=%s", res, mount.Render())
+	}
+
+	if r.Network != "" {
+		res = fmt.Sprintf("%s --network=%s", res, r.Network)
+	}
+
+	if r.Security != "" {
+		res = fmt.Sprintf("%s --security=%s", res, r.Security)
+	}
+
+	if r.Script != "" {
+		if r.ScriptForm == ChainedScriptForm {
+			return fmt.Sprintf("%s %s", res, renderChainedScript(r.Script, escape))
+		}
+
+		return fmt.Sprintf("%s %s", res, renderHeredoc(r.Script, r.Delimiter, ""))
+	}
+
 	if r.RunForm == ExecForm {
-		return fmt.Sprintf("RUN %s", r.ExecForm())
+		return fmt.Sprintf("%s %s", res, r.ExecForm())
 	}
 
-	return fmt.Sprintf("RUN %s", r.ShellForm())
+	return fmt.Sprintf("%s %s", res, r.ShellForm())
+}
+
+// Validate checks the mounts, the network and the security values, and that the script can be rendered
+func (r RunCommand) Validate() error {
+	if r.Script != "" {
+		if len(r.Params) > 0 {
+			return errors.New("RUN accepts either params or a script, not both")
+		}
+
+		if err := validateScript(r.Script, r.Delimiter, r.ScriptForm); err != nil {
+			return err
+		}
+	}
+
+	for _, mount := range r.Mounts {
+		if err := mount.Validate(); err != nil {
+			return err
+		}
+	}
+
+	switch r.Network {
+	case "", "none", "host", "default":
+	default:
+		return fmt.Errorf("Invalid network %q, expected none, host or default", r.Network)
+	}
+
+	switch r.Security {
+	case "", "insecure", "sandbox":
+	default:
+		return fmt.Errorf("Invalid security %q, expected insecure or sandbox", r.Security)
+	}
+
+	return nil
+}
+
+// MountType specifies the type of a RUN --mount
+type MountType string
+
+const (
+	// BindMount mounts a directory of the build context or of another stage, read only unless ReadWrite is set
+	BindMount MountType = "bind"
+
+	// CacheMount mounts a directory that is kept between builds, e.g. the go build cache
+	CacheMount MountType = "cache"
+
+	// TmpfsMount mounts a tmpfs
+	TmpfsMount MountType = "tmpfs"
+
+	// SecretMount mounts a secret given to the build, e.g. docker build --secret id=npmrc,src=.npmrc
+	SecretMount MountType = "secret"
+
+	// SSHMount mounts the ssh agent socket given to the build, e.g. docker build --ssh default
+	SSHMount MountType = "ssh"
+)
+
+// mountOptions are the options each mount type accepts besides type
+var mountOptions = map[MountType][]string{
+	BindMount:   {"target", "source", "from", "rw"},
+	CacheMount:  {"id", "target", "source", "from", "sharing", "mode", "uid", "gid"},
+	TmpfsMount:  {"target", "size"},
+	SecretMount: {"id", "target", "required", "mode", "uid", "gid"},
+	SSHMount:    {"id", "target", "required", "mode", "uid", "gid"},
+}
+
+var (
+	mountModeRegexp = regexp.MustCompile(`^0?[0-7]{3}$`)
+	chmodRegexp     = regexp.MustCompile(`^[0-7]{3,4}$`)
+	checksumRegexp  = regexp.MustCompile(`^(sha256|sha384|sha512):([0-9a-f]+)$`)
+)
+
+// checksumLengths are the lengths of the hex digests of the ADD --checksum algorithms
+var checksumLengths = map[string]int{"sha256": 64, "sha384": 96, "sha512": 128}
+
+// Mount is a RUN --mount flag, the options that don't apply to the Type are rejected by Validate
+type Mount struct {
+	Type      MountType `yaml:"type"`
+	ID        string    `yaml:"id"`
+	Target    string    `yaml:"target"`
+	Source    string    `yaml:"source"`
+	From      string    `yaml:"from"`
+	Sharing   string    `yaml:"sharing"`
+	Mode      string    `yaml:"mode"`
+	UID       string    `yaml:"uid"`
+	GID       string    `yaml:"gid"`
+	Size      string    `yaml:"size"`
+	ReadWrite bool      `yaml:"rw"`
+	Required  bool      `yaml:"required"`
+}
+
+// options returns the options that are set in the order they are rendered
+func (m Mount) options() [][2]string {
+	var res [][2]string
+
+	for _, option := range [][2]string{
+		{"id", m.ID}, {"target", m.Target}, {"source", m.Source}, {"from", m.From}, {"sharing", m.Sharing},
+		{"mode", m.Mode}, {"uid", m.UID}, {"gid", m.GID}, {"size", m.Size},
+		{"rw", strconv.FormatBool(m.ReadWrite)}, {"required", strconv.FormatBool(m.Required)},
+	} {
+		if option[1] != "" && option[1] != "false" {
+			res = append(res, option)
+		}
+	}
+
+	return res
+}
+
+// Render returns a string in the form of type=<type>[,<option>=<value>]...
+func (m Mount) Render() string {
+	res := fmt.Sprintf("type=%s", m.Type)
+
+	for _, option := range m.options() {
+		res = fmt.Sprintf("%s,%s=%s", res, option[0], option[1])
+	}
+
+	return res
+}
+
+// Validate checks the mount type, the options it accepts and their values
+func (m Mount) Validate() error {
+	allowed, ok := mountOptions[m.Type]
+	if !ok {
+		return fmt.Errorf("Invalid mount type %q, expected bind, cache, tmpfs, secret or ssh", m.Type)
+	}
+
+	for _, option := range m.options() {
+		found := false
+		for _, name := range allowed {
+			found = found || name == option[0]
+		}
+
+		if !found {
+			return fmt.Errorf("Mount option %s isn't supported by %s mounts", option[0], m.Type)
+		}
+	}
+
+	switch m.Type {
+	case BindMount, CacheMount, TmpfsMount:
+		if m.Target == "" {
+			return fmt.Errorf("%s mounts require a target", m.Type)
+		}
+	case SecretMount:
+		if m.ID == "" && m.Target == "" {
+			return errors.New("secret mounts require an id or a target")
+		}
+	}
+
+	switch m.Sharing {
+	case "", "shared", "private", "locked":
+	default:
+		return fmt.Errorf("Invalid mount sharing %q, expected shared, private or locked", m.Sharing)
+	}
+
+	if m.Mode != "" && !mountModeRegexp.MatchString(m.Mode) {
+		return fmt.Errorf("Invalid mount mode %q, expected an octal file mode, e.g. 0400", m.Mode)
+	}
+
+	for _, id := range [][2]string{{"uid", m.UID}, {"gid", m.GID}} {
+		if _, err := strconv.ParseUint(id[1], 10, 32); id[1] != "" && err != nil {
+			return fmt.Errorf("Invalid mount %s %q, expected a number", id[0], id[1])
+		}
+	}
+
+	return nil
 }
 
 // EnvVariable represents a Dockerfile instruction, see https://docs.docker.com/engine/reference/builder/#env
+// The value is quoted when needed, Literal escapes the $ characters so variables in it aren't expanded.
 type EnvVariable struct {
-	Name  string `yaml:"name"`
-	Value string `yaml:"value"`
+	Name    string `yaml:"name"`
+	Value   string `yaml:"value"`
+	Literal bool   `yaml:"literal"`
+	Comment string `yaml:"comment"`
 }
 
-// Render returns a string in the form of ENV <key> <value>
+// Render returns a string in the form of ENV <key>=<value>
 func (e EnvVariable) Render() string {
-	return fmt.Sprintf("ENV %s=%s", e.Name, e.Value)
+	return e.renderEscaped(DefaultEscape)
+}
+
+func (e EnvVariable) renderEscaped(escape rune) string {
+	return fmt.Sprintf("ENV %s=%s", e.Name, quoteValue(e.Value, e.Literal, escape))
+}
+
+// Validate checks that the value can be written on a single line
+func (e EnvVariable) Validate() error {
+	if strings.Contains(e.Value, "\n") {
+		return errors.New("ENV value can't contain a newline")
+	}
+
+	return nil
 }
 
 // CopyCommand represents a Dockerfile instruction, see https://docs.docker.com/engine/reference/builder/#copy
+// Content is the content of an inline file used instead of Sources, it is rendered in the ScriptForm, a heredoc by default.
+// The chained script form writes the file with RUN printf for the classic builder.
+// Link copies the files into an independent layer, it requires BuildKit.
 type CopyCommand struct {
 	Sources     []string `yaml:"sources"`
 	Destination string   `yaml:"destination"`
 	Chown       string   `yaml:"chown"`
+	Chmod       string   `yaml:"chmod"`
 	From        string   `yaml:"from"`
+	Link        bool     `yaml:"link"`
+	Parents     bool     `yaml:"parents"`
+	Exclude     []string `yaml:"exclude"`
+	Content     string   `yaml:"content"`
+	Delimiter   string   `yaml:"delimiter"`
+	ScriptForm  `yaml:"scriptForm"`
+	Comment     string `yaml:"comment"`
 }
 
-// Render returns a string in the form of COPY [--chown=<user>:<group>] <src>... <dest>
+// Render returns a string in the form of
+// COPY [--from=<stage>] [--chown=<user>:<group>] [--chmod=<perms>] [--link] [--parents] [--exclude=<pattern>...] <src>... <dest>
+// or COPY [--chown=<user>:<group>] [--chmod=<perms>] [--link] <<EOF <dest>\n<content>\nEOF
 func (c CopyCommand) Render() string {
+	return c.renderEscaped(DefaultEscape)
+}
+
+func (c CopyCommand) renderEscaped(escape rune) string {
+	if c.Content != "" && c.ScriptForm == ChainedScriptForm {
+		return c.renderPrintf(escape)
+	}
+
 	res := "COPY"
 
 	if c.From != "" {
@@ -170,16 +611,161 @@ func (c CopyCommand) Render() string {
 		res = fmt.Sprintf("%s --chown=%s", res, c.Chown)
 	}
 
-	sources := strings.Join(c.Sources, " ")
-	res = fmt.Sprintf("%s %s %s", res, sources, c.Destination)
+	if c.Chmod != "" {
+		res = fmt.Sprintf("%s --chmod=%s", res, c.Chmod)
+	}
+
+	if c.Link {
+		res = fmt.Sprintf("%s --link", res)
+	}
+
+	if c.Parents {
+		res = fmt.Sprintf("%s --parents", res)
+	}
+
+	for _, pattern := range c.Exclude {
+		res = fmt.Sprintf("%s --exclude=%s", res, pattern)
+	}
+
+	if c.Content != "" {
+		return fmt.Sprintf("%s %s", res, renderHeredoc(c.Content, c.Delimiter, " "+c.Destination))
+	}
+
+	paths := append(append([]string{}, c.Sources...), c.Destination)
+
+	return fmt.Sprintf("%s %s", res, renderPaths(paths, escape))
+}
+
+// renderPrintf returns a RUN instruction that writes the content with printf, one argument per line
+func (c CopyCommand) renderPrintf(escape rune) string {
+	lines := strings.Split(strings.TrimSuffix(c.Content, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = shellQuote(line)
+	}
+
+	res := fmt.Sprintf("RUN printf '%%s\\n' %s > %s", strings.Join(lines, " "), shellQuote(c.Destination))
+
+	if c.Chown != "" {
+		res = fmt.Sprintf("%s && %c\n    chown %s %s", res, escape, shellQuote(c.Chown), shellQuote(c.Destination))
+	}
+
+	if c.Chmod != "" {
+		res = fmt.Sprintf("%s && %c\n    chmod %s %s", res, escape, c.Chmod, shellQuote(c.Destination))
+	}
 
 	return res
 }
 
+// Validate checks the chmod mode, the exclude patterns and that the inline content can be rendered
+func (c CopyCommand) Validate() error {
+	if c.Chmod != "" && !chmodRegexp.MatchString(c.Chmod) {
+		return fmt.Errorf("Invalid COPY --chmod %q, expected an octal file mode, e.g. 0755", c.Chmod)
+	}
+
+	for _, pattern := range c.Exclude {
+		if pattern == "" {
+			return errors.New("COPY --exclude patterns can't be empty")
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Invalid COPY --exclude pattern %q: %v", pattern, err)
+		}
+	}
+
+	if c.Content == "" {
+		return nil
+	}
+
+	if len(c.Sources) > 0 || c.From != "" {
+		return errors.New("COPY accepts either sources or content, content can't be copied from another stage")
+	}
+
+	if c.Parents || len(c.Exclude) > 0 {
+		return errors.New("COPY --parents and --exclude only apply to sources, not to content")
+	}
+
+	// the chained form writes the content with RUN printf, --chown and --chmod become chown and chmod commands
+	if c.Link && c.ScriptForm == ChainedScriptForm {
+		return errors.New("COPY --link doesn't apply to content in the chained script form, it is written with RUN printf")
+	}
+
+	if c.Destination == "" {
+		return errors.New("COPY requires a destination for the content")
+	}
+
+	return validateScript(c.Content, c.Delimiter, c.ScriptForm)
+}
+
+// Add represents a Dockerfile instruction, see https://docs.docker.com/engine/reference/builder/#add
+type Add struct {
+	Sources     []string `yaml:"sources"`
+	Destination string   `yaml:"destination"`
+	Chown       string   `yaml:"chown"`
+	Chmod       string   `yaml:"chmod"`
+	Checksum    string   `yaml:"checksum"`
+	KeepGitDir  bool     `yaml:"keepGitDir"`
+	Link        bool     `yaml:"link"`
+	Comment     string   `yaml:"comment"`
+}
+
+// Render returns a string in the form of
+// ADD [--chown=<user>:<group>] [--chmod=<perms>] [--checksum=<checksum>] [--keep-git-dir=true] [--link] <src>... <dest>,
+// the paths are rendered as a JSON array when one of them needs quoting
+func (a Add) Render() string {
+	return a.renderEscaped(DefaultEscape)
+}
+
+func (a Add) renderEscaped(escape rune) string {
+	res := "ADD"
+
+	if a.Chown != "" {
+		res = fmt.Sprintf("%s --chown=%s", res, a.Chown)
+	}
+
+	if a.Chmod != "" {
+		res = fmt.Sprintf("%s --chmod=%s", res, a.Chmod)
+	}
+
+	if a.Checksum != "" {
+		res = fmt.Sprintf("%s --checksum=%s", res, a.Checksum)
+	}
+
+	if a.KeepGitDir {
+		res = fmt.Sprintf("%s --keep-git-dir=true", res)
+	}
+
+	if a.Link {
+		res = fmt.Sprintf("%s --link", res)
+	}
+
+	paths := append(append([]string{}, a.Sources...), a.Destination)
+
+	return fmt.Sprintf("%s %s", res, renderPaths(paths, escape))
+}
+
+// Validate checks the chmod mode and that the checksum is a digest in the form of <algorithm>:<hex>
+func (a Add) Validate() error {
+	if a.Chmod != "" && !chmodRegexp.MatchString(a.Chmod) {
+		return fmt.Errorf("Invalid ADD --chmod %q, expected an octal file mode, e.g. 0755", a.Chmod)
+	}
+
+	if a.Checksum == "" {
+		return nil
+	}
+
+	m := checksumRegexp.FindStringSubmatch(a.Checksum)
+	if m == nil || len(m[2]) != checksumLengths[m[1]] {
+		return fmt.Errorf("Invalid ADD --checksum %q, expected <algorithm>:<hex digest> with sha256, sha384 or sha512", a.Checksum)
+	}
+
+	return nil
+}
+
 // Cmd represents a Dockerfile instruction, see https://docs.docker.com/engine/reference/builder/#cmd
 type Cmd struct {
 	Params  `yaml:"params"`
 	RunForm `yaml:"runForm"`
+	Comment string `yaml:"comment"`
 }
 
 // Render returns a string in the form of CMD ["executable","param1","param2"]
@@ -199,6 +785,7 @@ func (c Cmd) Render() string {
 type Entrypoint struct {
 	Params  `yaml:"params"`
 	RunForm `yaml:"runForm"`
+	Comment string `yaml:"comment"`
 }
 
 // Render returns a string in the form of ENTRYPOINT ["executable", "param1", "param2"]
@@ -214,29 +801,323 @@ func (e Entrypoint) Render() string {
 	return fmt.Sprintf("ENTRYPOINT %s", e.ShellForm())
 }
 
-// Onbuild represents a Dockerfile instruction, see https://docs.docker.com/engine/reference/builder/#onbuuild
+// Onbuild represents a Dockerfile instruction, see https://docs.docker.com/engine/reference/builder/#onbuild
+// Instruction is the trigger run when the image is used as a base, Params are rendered as a free text trigger without it.
 type Onbuild struct {
-	Params `yaml:"params"`
+	Params      `yaml:"params"`
+	Instruction Instruction `yaml:"instruction"`
+	Comment     string      `yaml:"comment"`
 }
 
+// onbuildForbidden are the instructions Docker doesn't allow as ONBUILD triggers
+var onbuildForbidden = []string{"FROM", "ONBUILD", "MAINTAINER"}
+
 // Render returns a string in the form of ONBUILD [INSTRUCTION]
 func (o Onbuild) Render() string {
+	return o.renderEscaped(DefaultEscape)
+}
+
+func (o Onbuild) renderEscaped(escape rune) string {
+	if v, ok := o.Instruction.(escapeRenderer); ok {
+		return fmt.Sprintf("ONBUILD %s", v.renderEscaped(escape))
+	}
+
+	if o.Instruction != nil {
+		return fmt.Sprintf("ONBUILD %s", o.Instruction.Render())
+	}
+
 	return fmt.Sprintf("ONBUILD %s", o.ShellForm())
 }
 
+// Validate rejects the triggers Docker doesn't allow, heredocs, which can't follow ONBUILD, and the triggers that
+// render several instructions, then validates the trigger
+func (o Onbuild) Validate() error {
+	if err := o.validateTrigger(); err != nil {
+		return err
+	}
+
+	switch v := o.Instruction.(type) {
+	case RunCommand:
+		if v.Script != "" && v.ScriptForm != ChainedScriptForm {
+			return errors.New("ONBUILD RUN can't be a heredoc, use the chained script form")
+		}
+	case CopyCommand:
+		if v.Content != "" && v.ScriptForm != ChainedScriptForm {
+			return errors.New("ONBUILD COPY can't be a heredoc, use the chained script form")
+		}
+	}
+
+	// ONBUILD prefixes a single instruction, e.g. an ARG with test renders a RUN line that would run in the base image
+	if o.Instruction != nil && renderedInstructions(o.Instruction.Render(), DefaultEscape) > 1 {
+		return errors.New("ONBUILD trigger should render a single instruction")
+	}
+
+	if v, ok := o.Instruction.(validator); ok {
+		return v.Validate()
+	}
+
+	return nil
+}
+
+// validateTrigger checks the instruction keyword of the trigger, the script form is left to Validate
+func (o Onbuild) validateTrigger() error {
+	if _, ok := o.Instruction.(Comment); ok {
+		return errors.New("ONBUILD can't wrap a comment")
+	}
+
+	trigger := o.ShellForm()
+	if o.Instruction != nil {
+		trigger = o.Instruction.Render()
+	}
+
+	fields := strings.Fields(trigger)
+	if len(fields) == 0 {
+		return errors.New("ONBUILD requires an instruction")
+	}
+
+	keyword := strings.ToUpper(fields[0])
+	for _, forbidden := range onbuildForbidden {
+		if keyword == forbidden {
+			return fmt.Errorf("%s isn't allowed as an ONBUILD trigger", keyword)
+		}
+	}
+
+	return nil
+}
+
+// renderedInstructions counts the instructions of a string rendered with the escape character, a line ending with the
+// escape character continues the instruction of the line
+func renderedInstructions(rendered string, escape rune) int {
+	count := 0
+	continued := false
+	for _, line := range strings.Split(rendered, "\n") {
+		if !continued {
+			count++
+		}
+		continued = strings.HasSuffix(strings.TrimRight(line, " "), string(escape))
+	}
+
+	return count
+}
+
 // HealthCheck represents a Dockerfile instruction, see https://docs.docker.com/engine/reference/builder/#healthcheck
+// Params are the CMD command in the given RunForm, zero durations and retries are left to the defaults of the builder.
+// None disables the health check inherited from the base image. Params that hold the options as well, e.g.
+// {"--interval=30s", "CMD", "curl"}, are still accepted and read into the fields.
 type HealthCheck struct {
-	Params `yaml:"params"`
+	Params        `yaml:"params"`
+	RunForm       `yaml:"runForm"`
+	Interval      time.Duration `yaml:"interval"`
+	Timeout       time.Duration `yaml:"timeout"`
+	StartPeriod   time.Duration `yaml:"startPeriod"`
+	StartInterval time.Duration `yaml:"startInterval"`
+	Retries       int           `yaml:"retries"`
+	None          bool          `yaml:"none"`
+	Comment       string        `yaml:"comment"`
 }
 
-// Render returns a string in the form of HEALTHCHECK [OPTIONS] CMD command
+// Render returns a string in the form of HEALTHCHECK [--interval=<duration>] [--timeout=<duration>]
+// [--start-period=<duration>] [--start-interval=<duration>] [--retries=<n>] CMD <command> or HEALTHCHECK NONE
 func (h HealthCheck) Render() string {
-	return fmt.Sprintf("HEALTHCHECK %s", h.ShellForm())
+	h, err := h.withOptionParams()
+	if err != nil {
+		// Validate reports the params, they are rendered as they are given
+		return fmt.Sprintf("HEALTHCHECK %s", h.ShellForm())
+	}
+
+	if h.None {
+		return "HEALTHCHECK NONE"
+	}
+
+	res := "HEALTHCHECK"
+
+	for _, option := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"interval", h.Interval},
+		{"timeout", h.Timeout},
+		{"start-period", h.StartPeriod},
+		{"start-interval", h.StartInterval},
+	} {
+		if option.value != 0 {
+			res = fmt.Sprintf("%s --%s=%s", res, option.name, formatDuration(option.value))
+		}
+	}
+
+	if h.Retries != 0 {
+		res = fmt.Sprintf("%s --retries=%d", res, h.Retries)
+	}
+
+	if h.RunForm == ExecForm {
+		return fmt.Sprintf("%s CMD %s", res, h.ExecForm())
+	}
+
+	return fmt.Sprintf("%s CMD %s", res, h.ShellForm())
+}
+
+// Validate checks that a command is given unless the health check is disabled and that the options are
+// accepted by the builder, durations can't be less than 1ms
+func (h HealthCheck) Validate() error {
+	h, err := h.withOptionParams()
+	if err != nil {
+		return err
+	}
+
+	if h.None {
+		if len(h.Params) > 0 || h.Interval != 0 || h.Timeout != 0 || h.StartPeriod != 0 || h.StartInterval != 0 || h.Retries != 0 {
+			return errors.New("HEALTHCHECK NONE doesn't accept a command or options")
+		}
+		return nil
+	}
+
+	if len(h.Params) == 0 {
+		return errors.New("HEALTHCHECK requires a command unless none is set")
+	}
+
+	for _, option := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"interval", h.Interval},
+		{"timeout", h.Timeout},
+		{"start-period", h.StartPeriod},
+		{"start-interval", h.StartInterval},
+	} {
+		if option.value != 0 && option.value < time.Millisecond {
+			return fmt.Errorf("HEALTHCHECK --%s can't be less than 1ms, got %s", option.name, option.value)
+		}
+	}
+
+	if h.Retries < 0 {
+		return fmt.Errorf("HEALTHCHECK --retries can't be negative, got %d", h.Retries)
+	}
+
+	return nil
+}
+
+// hasOptionParams reports whether the params hold the options or NONE as well, the form used before the fields
+func hasOptionParams(params []string) bool {
+	return len(params) > 0 && (strings.HasPrefix(params[0], "--") || strings.EqualFold(params[0], "CMD") || strings.EqualFold(params[0], "NONE"))
+}
+
+// withOptionParams returns the health check with the options and the command of option params read into the fields,
+// the fields the params don't set are kept
+func (h HealthCheck) withOptionParams() (HealthCheck, error) {
+	if !hasOptionParams(h.Params) {
+		return h, nil
+	}
+
+	res, err := healthCheckFromParams(h.Params)
+	if err != nil {
+		return h, err
+	}
+
+	for _, field := range []struct{ value, given *time.Duration }{
+		{&res.Interval, &h.Interval},
+		{&res.Timeout, &h.Timeout},
+		{&res.StartPeriod, &h.StartPeriod},
+		{&res.StartInterval, &h.StartInterval},
+	} {
+		if *field.value == 0 {
+			*field.value = *field.given
+		}
+	}
+
+	if res.Retries == 0 {
+		res.Retries = h.Retries
+	}
+	if h.RunForm != "" {
+		res.RunForm = h.RunForm
+	}
+	res.None = res.None || h.None
+	res.Comment = h.Comment
+
+	return res, nil
+}
+
+// healthCheckFromParams reads the params of a health check written in the form of
+// [--<option>=<value>...] CMD <command>... or NONE, the command is kept in shell form
+func healthCheckFromParams(params []string) (HealthCheck, error) {
+	h := HealthCheck{RunForm: ShellForm}
+
+	for i, param := range params {
+		switch {
+		case strings.EqualFold(param, "NONE") && i == len(params)-1:
+			h.None = true
+			return h, nil
+		case strings.EqualFold(param, "CMD"):
+			h.Params = params[i+1:]
+			return h, nil
+		case strings.HasPrefix(param, "--"):
+			parts := strings.SplitN(strings.TrimPrefix(param, "--"), "=", 2)
+			if len(parts) != 2 {
+				return h, fmt.Errorf("HEALTHCHECK option %s requires a value", param)
+			}
+			if err := h.setOption(parts[0], parts[1]); err != nil {
+				return h, err
+			}
+		default:
+			return h, fmt.Errorf("HEALTHCHECK expects options followed by CMD or NONE, got %q", param)
+		}
+	}
+
+	return h, errors.New("HEALTHCHECK expects options followed by CMD or NONE")
+}
+
+// setOption sets an option by its flag name, e.g. start-period, durations are in the form of Go durations, e.g. 30s
+func (h *HealthCheck) setOption(name, value string) error {
+	if name == "retries" {
+		retries, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("Invalid HEALTHCHECK --retries %q, expected a number", value)
+		}
+		h.Retries = retries
+		return nil
+	}
+
+	var duration *time.Duration
+	switch name {
+	case "interval":
+		duration = &h.Interval
+	case "timeout":
+		duration = &h.Timeout
+	case "start-period":
+		duration = &h.StartPeriod
+	case "start-interval":
+		duration = &h.StartInterval
+	default:
+		return fmt.Errorf("Unknown HEALTHCHECK option --%s", name)
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("Invalid HEALTHCHECK --%s %q, expected a duration, e.g. 30s", name, value)
+	}
+	*duration = d
+
+	return nil
+}
+
+// formatDuration returns the duration without the zero units time.Duration.String adds, e.g. 1h instead of 1h0m0s
+func formatDuration(d time.Duration) string {
+	res := d.String()
+
+	if strings.HasSuffix(res, "m0s") {
+		res = strings.TrimSuffix(res, "0s")
+	}
+
+	if strings.HasSuffix(res, "h0m") {
+		res = strings.TrimSuffix(res, "0m")
+	}
+
+	return res
 }
 
 // Shell represents a Dockerfile instruction, see https://docs.docker.com/engine/reference/builder/#shell
 type Shell struct {
-	Params `yaml:"params"`
+	Params  `yaml:"params"`
+	Comment string `yaml:"comment"`
 }
 
 // Render returns a string in the form of SHELL ["executable", "parameters"]
@@ -246,32 +1127,158 @@ func (s Shell) Render() string {
 
 // Workdir represents a Dockerfile instruction, see https://docs.docker.com/engine/reference/builder/#workdir
 type Workdir struct {
-	Dir string `yaml:"dir"`
+	Dir     string `yaml:"dir"`
+	Comment string `yaml:"comment"`
 }
 
-// Render returns a string in the form of WORKDIR /path/to/workdir
+// Render returns a string in the form of WORKDIR /path/to/workdir, the path is quoted when needed
 func (w Workdir) Render() string {
-	return fmt.Sprintf("WORKDIR %s", w.Dir)
+	return w.renderEscaped(DefaultEscape)
+}
+
+func (w Workdir) renderEscaped(escape rune) string {
+	return fmt.Sprintf("WORKDIR %s", quoteWord(w.Dir, escape))
+}
+
+// Expose represents a Dockerfile instruction, see https://docs.docker.com/engine/reference/builder/#expose
+// A port is in the form of <port>[-<port>][/<protocol>], e.g. 80, 53/udp, 8000-8010/tcp or ${PORT}/tcp
+type Expose struct {
+	Ports   []string `yaml:"ports"`
+	Comment string   `yaml:"comment"`
+}
+
+// Render returns a string in the form of EXPOSE <port>[/<protocol>]...
+func (e Expose) Render() string {
+	return fmt.Sprintf("EXPOSE %s", strings.Join(e.Ports, " "))
+}
+
+// Validate checks the port numbers, the ranges and the protocols, ARG references aren't checked
+func (e Expose) Validate() error {
+	if len(e.Ports) == 0 {
+		return errors.New("EXPOSE requires at least one port")
+	}
+
+	for _, port := range e.Ports {
+		if err := validatePortSpec(port); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validatePortSpec(spec string) error {
+	ports, protocol := spec, ""
+	if i := strings.LastIndex(spec, "/"); i >= 0 {
+		ports, protocol = spec[:i], spec[i+1:]
+
+		switch strings.ToLower(protocol) {
+		case "tcp", "udp":
+		default:
+			if !isVariableReference(protocol) {
+				return fmt.Errorf("Invalid protocol %q in port %q, expected tcp or udp", protocol, spec)
+			}
+		}
+	}
+
+	if isVariableReference(ports) {
+		return nil
+	}
+
+	bounds := strings.SplitN(ports, "-", 2)
+	numbers := make([]int, len(bounds))
+	for i, bound := range bounds {
+		number, err := strconv.Atoi(bound)
+		if err != nil || number < 1 || number > 65535 {
+			return fmt.Errorf("Invalid port %q, expected a number between 1 and 65535", spec)
+		}
+		numbers[i] = number
+	}
+
+	if len(numbers) == 2 && numbers[0] > numbers[1] {
+		return fmt.Errorf("Invalid port range %q, the start is greater than the end", spec)
+	}
+
+	return nil
+}
+
+var variableReferenceRegexp = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*|\{[A-Za-z_][A-Za-z0-9_]*(:[-+][^}]*)?\})$`)
+
+// isVariableReference returns true when the value is a single $VAR or ${VAR} reference, which is resolved by the build
+func isVariableReference(value string) bool {
+	return variableReferenceRegexp.MatchString(value)
+}
+
+// StopSignal represents a Dockerfile instruction, see https://docs.docker.com/engine/reference/builder/#stopsignal
+type StopSignal struct {
+	Signal  string `yaml:"signal"`
+	Comment string `yaml:"comment"`
+}
+
+// Render returns a string in the form of STOPSIGNAL <signal>
+func (s StopSignal) Render() string {
+	return fmt.Sprintf("STOPSIGNAL %s", s.Signal)
+}
+
+// signalNames are the signal names docker accepts, with or without the SIG prefix
+var signalNames = map[string]bool{
+	"ABRT": true, "ALRM": true, "BUS": true, "CHLD": true, "CLD": true, "CONT": true, "FPE": true, "HUP": true,
+	"ILL": true, "INT": true, "IO": true, "IOT": true, "KILL": true, "PIPE": true, "POLL": true, "PROF": true,
+	"PWR": true, "QUIT": true, "SEGV": true, "STKFLT": true, "STOP": true, "SYS": true, "TERM": true, "TRAP": true,
+	"TSTP": true, "TTIN": true, "TTOU": true, "URG": true, "USR1": true, "USR2": true, "VTALRM": true, "WINCH": true,
+	"XCPU": true, "XFSZ": true,
+}
+
+var realtimeSignalRegexp = regexp.MustCompile(`^RTM(IN\+([1-9]|1[0-5])|AX-([1-9]|1[0-4])|IN|AX)$`)
+
+// Validate checks the signal is either a known signal name, e.g. SIGTERM or TERM, or a number between 1 and 64
+func (s StopSignal) Validate() error {
+	if s.Signal == "" {
+		return errors.New("STOPSIGNAL requires a signal")
+	}
+
+	if isVariableReference(s.Signal) {
+		return nil
+	}
+
+	if number, err := strconv.Atoi(s.Signal); err == nil {
+		if number < 1 || number > 64 {
+			return fmt.Errorf("Invalid signal number %d, expected a number between 1 and 64", number)
+		}
+		return nil
+	}
+
+	name := strings.TrimPrefix(strings.ToUpper(s.Signal), "SIG")
+	if !signalNames[name] && !realtimeSignalRegexp.MatchString(name) {
+		return fmt.Errorf("Unknown signal %q", s.Signal)
+	}
+
+	return nil
 }
 
 // User represents a Dockerfile instruction, see https://docs.docker.com/engine/reference/builder/#user
 type User struct {
-	User  string `yaml:user`
-	Group string `yaml:group`
+	User    string `yaml:"user"`
+	Group   string `yaml:"group"`
+	Comment string `yaml:"comment"`
 }
 
-// Render returns a string in the form of WORKDIR /path/to/workdir
+// Render returns a string in the form of USER <user>[:<group>], the user and the group are quoted when needed
 func (u User) Render() string {
+	return u.renderEscaped(DefaultEscape)
+}
+
+func (u User) renderEscaped(escape rune) string {
 	res := "USER"
 
 	if u.User == "" && u.Group == "" {
 		return ""
 	}
 
-	res = fmt.Sprintf("%s %s", res, u.User)
+	res = fmt.Sprintf("%s %s", res, quoteWord(u.User, escape))
 
 	if u.Group != "" {
-		res = fmt.Sprintf("%s:%s", res, u.Group)
+		res = fmt.Sprintf("%s:%s", res, quoteWord(u.Group, escape))
 	}
 
 	return res
@@ -288,11 +1295,9 @@ func (p Params) mapParams(f func(string) string) []string {
 	return res
 }
 
-// ExecForm joins params slice in exec form
+// ExecForm joins params slice in exec form, the params are JSON strings
 func (p Params) ExecForm() string {
-	params := p.mapParams(func(s string) string {
-		return fmt.Sprintf("\"%s\"", s)
-	})
+	params := p.mapParams(jsonString)
 
 	paramsString := strings.Join(params, ", ")
 	execFormString := fmt.Sprintf("[%s]", paramsString)
@@ -0,0 +1,212 @@
+package dockerfilegenerator
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SyntaxImage is the frontend image of the syntax directive added when BuildKit-only features are used
+const SyntaxImage = "docker/dockerfile"
+
+// Directives are the parser directives rendered on top of the Dockerfile,
+// see https://docs.docker.com/engine/reference/builder/#parser-directives
+// When Syntax is empty, the minimal docker/dockerfile version the instructions require is rendered.
+type Directives struct {
+	Syntax string `yaml:"syntax"`
+	Escape string `yaml:"escape"`
+	Check  string `yaml:"check"`
+}
+
+// render returns the directives in the form of # <name>=<value> lines, the empty ones are left out
+func (d Directives) render() string {
+	var res strings.Builder
+
+	for _, directive := range [][2]string{{"syntax", d.Syntax}, {"escape", d.Escape}, {"check", d.Check}} {
+		if directive[1] != "" {
+			fmt.Fprintf(&res, "# %s=%s\n", directive[0], directive[1])
+		}
+	}
+
+	return res.String()
+}
+
+// Validate checks the escape character and that the values fit on the directive line
+func (d Directives) Validate() error {
+	if d.Escape != "" && d.Escape != "\\" && d.Escape != "`" {
+		return fmt.Errorf("Invalid escape directive %q, expected \\ or `", d.Escape)
+	}
+
+	if strings.ContainsAny(d.Syntax, " \t\n") {
+		return fmt.Errorf("Invalid syntax directive %q, expected an image reference, e.g. %s:1", d.Syntax, SyntaxImage)
+	}
+
+	if strings.Contains(d.Check, "\n") {
+		return errors.New("The check directive should be a single line")
+	}
+
+	return nil
+}
+
+// syntaxRequirement is a feature that needs at least docker/dockerfile:1.<minor>, or the labs channel when labs is set
+type syntaxRequirement struct {
+	feature string
+	minor   int
+	labs    bool
+}
+
+// syntaxUser is implemented by the instructions that can use BuildKit-only features
+type syntaxUser interface {
+	syntaxRequirements() []syntaxRequirement
+}
+
+// minimalSyntax returns the syntax directive the rendered instructions need, empty when the classic builder can build them
+func minimalSyntax(stages [][]Instruction) string {
+	var required syntaxRequirement
+
+	for _, instructions := range stages {
+		for _, instruction := range instructions {
+			u, ok := instruction.(syntaxUser)
+			if !ok {
+				continue
+			}
+
+			for _, requirement := range u.syntaxRequirements() {
+				if requirement.minor > required.minor {
+					required.minor = requirement.minor
+				}
+				required.labs = required.labs || requirement.labs
+			}
+		}
+	}
+
+	if required.labs {
+		return SyntaxImage + ":1-labs"
+	}
+
+	if required.minor == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%s:1.%d", SyntaxImage, required.minor)
+}
+
+var syntaxVersionRegexp = regexp.MustCompile(`^docker/dockerfile(?:-upstream)?:1(?:\.(\d+))?(?:\.\d+)?(-labs)?$`)
+
+// syntaxWarnings returns the features the syntax directive doesn't support, unknown frontends aren't checked
+func syntaxWarnings(syntax string, u syntaxUser) []string {
+	m := syntaxVersionRegexp.FindStringSubmatch(syntax)
+	if m == nil {
+		return nil
+	}
+
+	// docker/dockerfile:1 is the latest 1.x version
+	minor := -1
+	if m[1] != "" {
+		minor, _ = strconv.Atoi(m[1])
+	}
+
+	var res []string
+	for _, requirement := range u.syntaxRequirements() {
+		if requirement.labs && m[2] == "" {
+			res = append(res, fmt.Sprintf("%s requires %s:1-labs, the syntax directive is %s", requirement.feature, SyntaxImage, syntax))
+		} else if minor >= 0 && requirement.minor > minor {
+			res = append(res, fmt.Sprintf("%s requires %s:1.%d, the syntax directive is %s", requirement.feature, SyntaxImage, requirement.minor, syntax))
+		}
+	}
+
+	return res
+}
+
+func (r RunCommand) syntaxRequirements() []syntaxRequirement {
+	var res []syntaxRequirement
+
+	if len(r.Mounts) > 0 {
+		res = append(res, syntaxRequirement{feature: "RUN --mount", minor: 2})
+	}
+
+	if r.Network != "" {
+		res = append(res, syntaxRequirement{feature: "RUN --network", minor: 3})
+	}
+
+	if r.Security != "" {
+		res = append(res, syntaxRequirement{feature: "RUN --security", labs: true})
+	}
+
+	if r.Script != "" && r.ScriptForm != ChainedScriptForm {
+		res = append(res, syntaxRequirement{feature: "RUN heredoc", minor: 4})
+	}
+
+	return res
+}
+
+func (c CopyCommand) syntaxRequirements() []syntaxRequirement {
+	// the chained form of the content is a RUN printf, none of the COPY flags are rendered
+	if c.Content != "" && c.ScriptForm == ChainedScriptForm {
+		return nil
+	}
+
+	var res []syntaxRequirement
+
+	if c.Chmod != "" {
+		res = append(res, syntaxRequirement{feature: "COPY --chmod", minor: 3})
+	}
+
+	if c.Link {
+		res = append(res, syntaxRequirement{feature: "COPY --link", minor: 4})
+	}
+
+	if c.Parents {
+		res = append(res, syntaxRequirement{feature: "COPY --parents", labs: true})
+	}
+
+	if len(c.Exclude) > 0 {
+		res = append(res, syntaxRequirement{feature: "COPY --exclude", labs: true})
+	}
+
+	if c.Content != "" {
+		res = append(res, syntaxRequirement{feature: "COPY heredoc", minor: 4})
+	}
+
+	return res
+}
+
+func (a Add) syntaxRequirements() []syntaxRequirement {
+	var res []syntaxRequirement
+
+	if a.Chmod != "" {
+		res = append(res, syntaxRequirement{feature: "ADD --chmod", minor: 3})
+	}
+
+	if a.Link {
+		res = append(res, syntaxRequirement{feature: "ADD --link", minor: 4})
+	}
+
+	if a.Checksum != "" {
+		res = append(res, syntaxRequirement{feature: "ADD --checksum", minor: 6})
+	}
+
+	if a.KeepGitDir {
+		res = append(res, syntaxRequirement{feature: "ADD --keep-git-dir", minor: 6})
+	}
+
+	return res
+}
+
+func (o Onbuild) syntaxRequirements() []syntaxRequirement {
+	if u, ok := o.Instruction.(syntaxUser); ok {
+		return u.syntaxRequirements()
+	}
+
+	return nil
+}
+
+func (h HealthCheck) syntaxRequirements() []syntaxRequirement {
+	if h.StartInterval != 0 && !h.None {
+		return []syntaxRequirement{{feature: "HEALTHCHECK --start-interval", minor: 6}}
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package dockerfilegenerator
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestDirectives(t *testing.T) {
+	data := &DockerfileData{
+		Directives: Directives{Syntax: "docker/dockerfile:1", Escape: "`", Check: "skip=JSONArgsRecommended"},
+		Stages:     []Stage{NewStage("final", From{Image: "alpine"})},
+	}
+
+	output := &bytes.Buffer{}
+	assert.NoError(t, NewDockerfileTemplate(data).Render(output))
+	assert.Equal(t, "# syntax=docker/dockerfile:1\n# escape=`\n# check=skip=JSONArgsRecommended\nFROM alpine as final\n\n", output.String())
+
+	assert.EqualError(t, Directives{Escape: "/"}.Validate(), "Invalid escape directive \"/\", expected \\ or `")
+	assert.EqualError(t, Directives{Syntax: "docker/dockerfile 1"}.Validate(), `Invalid syntax directive "docker/dockerfile 1", expected an image reference, e.g. docker/dockerfile:1`)
+	assert.EqualError(t, Directives{Check: "skip=all\nerror=true"}.Validate(), "The check directive should be a single line")
+}
+
+func TestMinimalSyntax(t *testing.T) {
+	for expected, instructions := range map[string][]Instruction{
+		"": {
+			RunCommand{Params: []string{"ls"}},
+			CopyCommand{Sources: []string{"a"}, Destination: "/a", Chown: "app"},
+			RunCommand{Script: "ls", ScriptForm: ChainedScriptForm},
+			CopyCommand{Content: "a", Destination: "/a", Chmod: "755", ScriptForm: ChainedScriptForm},
+		},
+		"docker/dockerfile:1.2": {RunCommand{Params: []string{"ls"}, Mounts: []Mount{{Type: SSHMount}}}},
+		"docker/dockerfile:1.3": {
+			RunCommand{Params: []string{"ls"}, Network: "none"},
+			Add{Sources: []string{"a"}, Chmod: "644"},
+			CopyCommand{Sources: []string{"a"}, Destination: "/a", Chmod: "755"},
+		},
+		"docker/dockerfile:1.4": {
+			RunCommand{Params: []string{"ls"}, Mounts: []Mount{{Type: SSHMount}}},
+			CopyCommand{Sources: []string{"a"}, Destination: "/a", Link: true},
+		},
+		"docker/dockerfile:1.6":    {CopyCommand{Content: "a", Destination: "/a"}, Add{Sources: []string{"https://example.com/a"}, Checksum: "sha256:abc"}},
+		"docker/dockerfile:1-labs": {RunCommand{Params: []string{"ls"}, Security: "insecure"}, Add{Sources: []string{"a"}, Link: true}},
+	} {
+		assert.Equal(t, expected, minimalSyntax([][]Instruction{instructions}), expected)
+	}
+
+	for _, labs := range []CopyCommand{
+		{Sources: []string{"a"}, Destination: "/a", Parents: true},
+		{Sources: []string{"a"}, Destination: "/a", Exclude: []string{"*.md"}},
+	} {
+		assert.Equal(t, "docker/dockerfile:1-labs", minimalSyntax([][]Instruction{{labs}}))
+	}
+
+	// ONBUILD triggers need the syntax of the instruction they wrap
+	onbuild := Onbuild{Instruction: CopyCommand{Sources: []string{"a"}, Destination: "/a", Link: true}}
+	assert.Equal(t, "docker/dockerfile:1.4", minimalSyntax([][]Instruction{{onbuild}}))
+
+	// the script form of the data decides whether a heredoc is rendered
+	data := &DockerfileData{
+		ScriptForm: ChainedScriptForm,
+		Stages:     []Stage{NewStage("final", From{Image: "alpine"}, RunCommand{Script: "ls"})},
+	}
+	output := &bytes.Buffer{}
+	assert.NoError(t, NewDockerfileTemplate(data).Render(output))
+	assert.Equal(t, "FROM alpine as final\nRUN ls\n\n", output.String())
+
+	// a given syntax isn't replaced, features it doesn't support are reported as warnings
+	data = &DockerfileData{
+		Directives: Directives{Syntax: "docker/dockerfile:1.2"},
+		Stages: []Stage{NewStage("final",
+			From{Image: "alpine"},
+			RunCommand{Params: []string{"ls"}, Mounts: []Mount{{Type: SSHMount}}, Security: "insecure"},
+			CopyCommand{Sources: []string{"a"}, Destination: "/a", Link: true},
+		)},
+	}
+	output.Reset()
+	assert.NoError(t, NewDockerfileTemplate(data).Render(output))
+	assert.True(t, strings.HasPrefix(output.String(), "# syntax=docker/dockerfile:1.2\nFROM alpine as final\n"))
+	assert.EqualError(t, data.Warnings(), `stages.final[1]: RUN --security requires docker/dockerfile:1-labs, the syntax directive is docker/dockerfile:1.2
+stages.final[2]: COPY --link requires docker/dockerfile:1.4, the syntax directive is docker/dockerfile:1.2`)
+
+	data.Directives.Syntax = "docker/dockerfile:1-labs"
+	assert.Empty(t, data.Warnings())
+
+	data.Directives.Syntax = "example.com/frontend:latest"
+	assert.Empty(t, data.Warnings())
+}
+
+func TestDirectivesYaml(t *testing.T) {
+	data, err := NewDockerFileDataFromYamlReader(strings.NewReader(`
+directives:
+  syntax: docker/dockerfile:1.4
+  check: skip=all
+stages:
+  final:
+    - from:
+        image: alpine
+`), "")
+	assert.NoError(t, err)
+	assert.Equal(t, Directives{Syntax: "docker/dockerfile:1.4", Check: "skip=all"}, data.Directives)
+
+	_, err = NewDockerFileDataFromYamlReader(strings.NewReader("directives:\n  escape: /\n  platform: linux\n  check: [a]\nstages: {}\n"), "")
+	assert.EqualError(t, err, `3:3: Unknown directive "platform"
+4:10: Directive check should be a string`)
+
+	_, err = NewDockerFileDataFromYamlReader(strings.NewReader("directives:\n  escape: /\nstages: {}\n"), "")
+	assert.EqualError(t, err, "2:3: Invalid escape directive \"/\", expected \\ or `")
+
+	_, err = NewDockerFileDataFromYamlReader(strings.NewReader("directives: docker/dockerfile:1\nstages: {}\n"), "")
+	assert.EqualError(t, err, "1:13: Directives should be a map with syntax, escape or check keys")
+}
@@ -0,0 +1,92 @@
+package dockerfilegenerator
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ConfigError describes a single problem found while decoding a Dockerfile config.
+// Line and Column are 1-based and set to 0 when the position is unknown.
+// Instruction is the 0-based index of the instruction in its stage, -1 when the error isn't tied to an instruction.
+type ConfigError struct {
+	Filename    string
+	Line        int
+	Column      int
+	Stage       string
+	Instruction int
+	Reason      string
+
+	// node is the node the error was found at, it tells which included file the error comes from
+	node *yaml.Node
+}
+
+func newConfigError(node *yaml.Node, format string, args ...interface{}) *ConfigError {
+	e := &ConfigError{Instruction: -1, Reason: fmt.Sprintf(format, args...), node: node}
+
+	if node != nil {
+		e.Line = node.Line
+		e.Column = node.Column
+	}
+
+	return e
+}
+
+var yamlErrorLineRegexp = regexp.MustCompile(`^yaml: line (\d+): `)
+
+// newConfigErrorFromYaml converts a yaml syntax error, which only carries the position in its message
+func newConfigErrorFromYaml(err error) *ConfigError {
+	e := &ConfigError{Instruction: -1, Reason: err.Error()}
+
+	if m := yamlErrorLineRegexp.FindStringSubmatch(e.Reason); m != nil {
+		e.Line, _ = strconv.Atoi(m[1])
+		e.Reason = strings.TrimPrefix(e.Reason, m[0])
+	}
+
+	return e
+}
+
+// Error returns the error in the form of <file>:<line>:<column>: stages.<stage>[<instruction>]: <reason>
+func (e *ConfigError) Error() string {
+	var location []string
+
+	position := e.Filename
+	if e.Line > 0 {
+		position = fmt.Sprintf("%s:%d", position, e.Line)
+		if e.Column > 0 {
+			position = fmt.Sprintf("%s:%d", position, e.Column)
+		}
+	}
+	position = strings.TrimPrefix(position, ":")
+
+	if position != "" {
+		location = append(location, position)
+	}
+
+	if e.Stage != "" {
+		path := fmt.Sprintf("stages.%s", e.Stage)
+		if e.Instruction >= 0 {
+			path = fmt.Sprintf("%s[%d]", path, e.Instruction)
+		}
+		location = append(location, path)
+	}
+
+	location = append(location, e.Reason)
+
+	return strings.Join(location, ": ")
+}
+
+// ConfigErrors collects every ConfigError found in a config so they can be reported together
+type ConfigErrors []*ConfigError
+
+// Error returns every collected error on its own line
+func (e ConfigErrors) Error() string {
+	res := make([]string, len(e))
+	for i, err := range e {
+		res[i] = err.Error()
+	}
+
+	return strings.Join(res, "\n")
+}
@@ -0,0 +1,118 @@
+package dockerfilegenerator
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// DefaultEscape is the escape character of a Dockerfile unless the escape directive changes it
+const DefaultEscape = '\\'
+
+// escapeRenderer is implemented by the instructions that quote their values, the escape character depends on the
+// escape directive of the Dockerfile
+type escapeRenderer interface {
+	renderEscaped(escape rune) string
+}
+
+// escapedInstruction renders the wrapped instruction with a custom escape character
+type escapedInstruction struct {
+	instruction escapeRenderer
+	escape      rune
+}
+
+// Render returns the wrapped instruction rendered with the escape character
+func (e escapedInstruction) Render() string {
+	return e.instruction.renderEscaped(e.escape)
+}
+
+// withEscape returns the instructions rendering their values with the given escape directive,
+// they are returned as they are for the default escape character
+func withEscape(escape string, instructions []Instruction) []Instruction {
+	if escape == "" || rune(escape[0]) == DefaultEscape {
+		return instructions
+	}
+
+	res := make([]Instruction, len(instructions))
+	for i, instruction := range instructions {
+		if v, ok := instruction.(escapeRenderer); ok {
+			res[i] = escapedInstruction{instruction: v, escape: rune(escape[0])}
+		} else {
+			res[i] = instruction
+		}
+	}
+
+	return res
+}
+
+// quoteValue returns the value in double quotes when it's empty or has whitespace, quotes, variables or the escape
+// character. Variables are expanded by the builder in double quotes, $ is escaped when a literal value is requested.
+func quoteValue(value string, literal bool, escape rune) string {
+	if value != "" && !strings.ContainsAny(value, " \t\"'$"+string(escape)) {
+		return value
+	}
+
+	return doubleQuote(value, literal, escape)
+}
+
+// quoteWord returns the value in double quotes when it has quotes, the escape character or leading or trailing
+// whitespace, e.g. the path of WORKDIR. The builder reads the rest of the line as a single word, inner whitespace
+// and variables don't require quotes.
+func quoteWord(value string, escape rune) string {
+	if !strings.ContainsAny(value, "\"'"+string(escape)) && value == strings.TrimSpace(value) {
+		return value
+	}
+
+	return doubleQuote(value, false, escape)
+}
+
+// doubleQuote returns the value in double quotes, the quotes and the escape characters in it are escaped
+func doubleQuote(value string, literal bool, escape rune) string {
+	var res strings.Builder
+	res.WriteRune('"')
+	for _, char := range value {
+		if char == '"' || char == escape || (literal && char == '$') {
+			res.WriteRune(escape)
+		}
+		res.WriteRune(char)
+	}
+	res.WriteRune('"')
+
+	return res.String()
+}
+
+// jsonString returns the value as a JSON string, HTML characters aren't escaped
+func jsonString(value string) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	// strings are always encoded
+	_ = encoder.Encode(value)
+
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// needsExecForm returns true when one of the paths of COPY, ADD or VOLUME can't be written as a plain word, the
+// paths are rendered as a JSON array then. A first path starting with [ or -- would be read as a JSON array or a flag.
+func needsExecForm(paths []string, escape rune) bool {
+	for i, p := range paths {
+		if p == "" || strings.ContainsAny(p, " \t\n\"'"+string(escape)) {
+			return true
+		}
+
+		if i == 0 && (strings.HasPrefix(p, "[") || strings.HasPrefix(p, "--")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// renderPaths returns the paths separated by spaces, or as a JSON array when one of them needs quoting
+func renderPaths(paths []string, escape rune) string {
+	if needsExecForm(paths, escape) {
+		return Params(paths).ExecForm()
+	}
+
+	return strings.Join(paths, " ")
+}
@@ -0,0 +1,68 @@
+package dockerfilegenerator
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEscaping(t *testing.T) {
+	assert.Equal(t, `CMD ["echo", "\"test\"", "C:\\app", "<a & b>"]`, Cmd{Params: []string{"echo", `"test"`, `C:\app`, "<a & b>"}, RunForm: ExecForm}.Render())
+	assert.Equal(t, `ENV PATH="/opt/bin:${PATH}"`, EnvVariable{Name: "PATH", Value: "/opt/bin:${PATH}"}.Render())
+	assert.Equal(t, `ENV GREETING="hello \"world\""`, EnvVariable{Name: "GREETING", Value: `hello "world"`}.Render())
+	assert.Equal(t, `ENV EMPTY=""`, EnvVariable{Name: "EMPTY"}.Render())
+	assert.Equal(t, `ENV PRICE="\$5 it's C:\\free"`, EnvVariable{Name: "PRICE", Value: `$5 it's C:\free`, Literal: true}.Render())
+	assert.Equal(t, `LABEL "org.example.long name"="a b"`, Label{Name: "org.example.long name", Value: "a b"}.Render())
+	assert.Equal(t, `LABEL version=1.0`, Label{Name: "version", Value: "1.0"}.Render())
+	assert.Equal(t, `ARG GREETING="hello world"`, Arg{Name: "GREETING", Value: "hello world"}.Render())
+	assert.Equal(t, `ARG NAME`, Arg{Name: "NAME", Literal: true}.Render())
+
+	assert.EqualError(t, EnvVariable{Name: "A", Value: "a\nb"}.Validate(), "ENV value can't contain a newline")
+	assert.EqualError(t, Label{Name: "a\nb"}.Validate(), "LABEL can't contain a newline")
+	assert.EqualError(t, Arg{Name: "A", Value: "a\nb"}.Validate(), "ARG value can't contain a newline")
+}
+
+func TestEscapeDirective(t *testing.T) {
+	data := &DockerfileData{
+		Directives: Directives{Escape: "`"},
+		Stages: []Stage{NewStage("final",
+			From{Image: "mcr.microsoft.com/windows/servercore"},
+			EnvVariable{Name: "APP", Value: `C:\Program Files\app`},
+			Label{Name: "quote", Value: "a `quoted` \"$word\"", Literal: true},
+			Cmd{Params: []string{`C:\app\run.exe`}, RunForm: ExecForm},
+		)},
+	}
+
+	output := &bytes.Buffer{}
+	assert.NoError(t, NewDockerfileTemplate(data).Render(output))
+	assert.Equal(t, "# escape=`\n"+
+		"FROM mcr.microsoft.com/windows/servercore as final\n"+
+		"ENV APP=\"C:\\Program Files\\app\"\n"+
+		"LABEL quote=\"a ``quoted`` `\"`$word`\"\"\n"+
+		"CMD [\"C:\\\\app\\\\run.exe\"]\n\n", output.String())
+}
+
+func TestEscapeDirectiveChainedScripts(t *testing.T) {
+	data := &DockerfileData{
+		Directives: Directives{Escape: "`"},
+		ScriptForm: ChainedScriptForm,
+		Stages: []Stage{NewStage("final",
+			From{Image: "alpine"},
+			RunCommand{Script: "apk add \\\n  git\nrm -rf /var/cache/apk"},
+			CopyCommand{Content: "a\nb", Destination: "/etc/app.conf", Chown: "app"},
+			Onbuild{Instruction: RunCommand{Script: "go mod download\ngo build ./..."}},
+		)},
+	}
+
+	assert.NoError(t, data.Validate())
+	output := &bytes.Buffer{}
+	assert.NoError(t, NewDockerfileTemplate(data).Render(output))
+	assert.Equal(t, "# escape=`\n"+
+		"FROM alpine as final\n"+
+		"RUN apk add `\n    git && `\n    rm -rf /var/cache/apk\n"+
+		"RUN printf '%s\\n' 'a' 'b' > '/etc/app.conf' && `\n    chown 'app' '/etc/app.conf'\n"+
+		"ONBUILD RUN go mod download && `\n    go build ./...\n\n", output.String())
+
+	assert.Equal(t, 1, renderedInstructions("RUN a && `\n    b", '`'))
+	assert.Equal(t, 2, renderedInstructions("RUN a && \\\n    b", '`'))
+}
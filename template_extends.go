@@ -0,0 +1,211 @@
+package dockerfilegenerator
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// resolve merges the included files and the extended config into the node of the target field and applies its
+// patches, document is the node of the whole file, the extends key can refer to another target field of it
+func (in *includes) resolve(node, document *yaml.Node, filename, targetField string) ConfigErrors {
+	var chain []string
+	if filename != "" {
+		chain = []string{filepath.Clean(filename)}
+	}
+
+	in.extending = append(in.extending, extendsID(filename, targetField))
+	defer func() {
+		in.extending = in.extending[:len(in.extending)-1]
+	}()
+
+	errs := in.apply(node, filename, chain)
+	if len(errs) == 0 {
+		errs = in.extend(node, document, filename)
+	}
+	if len(errs) == 0 {
+		errs = applyPatches(node, in.origins)
+	}
+
+	return errs
+}
+
+// extendsID identifies an extended config in the cycle errors, e.g. app.yaml .prod
+func extendsID(filename, targetField string) string {
+	return strings.TrimSpace(filename + " " + targetField)
+}
+
+// extend replaces the node with the config given by its extends key overridden by the keys of the node.
+// The extended config is resolved first, it can extend another one. Extends is a file path or a map with a file and
+// a targetField, the target field of the same file when the file is omitted, e.g. {targetField: .prod.apache}.
+func (in *includes) extend(node, document *yaml.Node, filename string) ConfigErrors {
+	extendsNode := takeMappingValueNode(node, "extends")
+	if extendsNode == nil {
+		return nil
+	}
+
+	file, targetField, err := decodeExtendsNode(extendsNode)
+	if err != nil {
+		return ConfigErrors{err}
+	}
+
+	baseFilename, baseDocument := filename, document
+	if file != "" {
+		baseFilename = file
+		if !filepath.IsAbs(baseFilename) {
+			baseFilename = filepath.Join(filepath.Dir(filename), baseFilename)
+		}
+		baseFilename = filepath.Clean(baseFilename)
+	}
+
+	id := extendsID(baseFilename, targetField)
+	if containsString(in.extending, id) {
+		cycle := append(append([]string{}, in.extending...), id)
+		return ConfigErrors{newConfigError(extendsNode, "Extends cycle %s", strings.Join(cycle, " -> "))}
+	}
+
+	if file != "" {
+		content, err := ioutil.ReadFile(baseFilename)
+		if err != nil {
+			return ConfigErrors{newConfigError(extendsNode, "Can't read extended file: %v", err)}
+		}
+
+		var errs ConfigErrors
+		baseDocument, errs = in.load(baseFilename, content, "extended by "+in.position(extendsNode))
+		if len(errs) > 0 {
+			return errs
+		}
+	}
+
+	baseNode := baseDocument.Content[0]
+	if targetField != "" {
+		var err error
+		baseNode, err = getTargetNode(baseDocument, targetField)
+		if err != nil {
+			return ConfigErrors{newConfigError(extendsNode, "Can't find the extended target field %s: %v", targetField, err)}
+		}
+	}
+
+	if baseNode.Kind != yaml.MappingNode {
+		return ConfigErrors{newConfigError(extendsNode, "The extended config should be a map that contains 'stages' key")}
+	}
+
+	// the extended target field can be decoded on its own as well, e.g. .prod next to .dev
+	base := in.origins.clone(baseNode)
+
+	if errs := in.resolve(base, baseDocument, baseFilename, targetField); len(errs) > 0 {
+		return errs
+	}
+
+	mergeConfig(base, node)
+	node.Content = base.Content
+
+	return nil
+}
+
+// overlay merges the config of the overlay file into the node and applies its patches,
+// the overlay can include files but it can't extend a config since it is applied to the input
+func (in *includes) overlay(node *yaml.Node, path string) ConfigErrors {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ConfigErrors{&ConfigError{Filename: path, Instruction: -1, Reason: fmt.Sprintf("Can't read overlay: %v", err)}}
+	}
+
+	document, errs := in.load(path, content, "")
+	if len(errs) > 0 {
+		return errs
+	}
+
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return ConfigErrors{newConfigError(root, "Overlay should be a map with stages, patches or vars keys")}
+	}
+
+	if extendsNode := getMappingValueNode(root, "extends"); extendsNode != nil {
+		return ConfigErrors{newConfigError(extendsNode, "An overlay can't have an extends key, it extends the input")}
+	}
+
+	if errs := in.apply(root, path, []string{filepath.Clean(path)}); len(errs) > 0 {
+		return errs
+	}
+
+	mergeConfig(node, root)
+
+	return applyPatches(node, in.origins)
+}
+
+// decodeExtendsNode returns the file and the target field of an extends key
+func decodeExtendsNode(node *yaml.Node) (string, string, *ConfigError) {
+	invalid := newConfigError(node, "Extends should be a file path or a map with file and targetField keys, e.g. {file: base.yaml, targetField: .prod}")
+
+	if node.Kind == yaml.ScalarNode && node.Value != "" {
+		return node.Value, "", nil
+	}
+
+	if node.Kind != yaml.MappingNode {
+		return "", "", invalid
+	}
+
+	var file, targetField string
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+
+		if valueNode.Kind != yaml.ScalarNode {
+			return "", "", invalid
+		}
+
+		switch keyNode.Value {
+		case "file":
+			file = valueNode.Value
+		case "targetField":
+			targetField = valueNode.Value
+		default:
+			return "", "", newConfigError(keyNode, "Unknown extends key %q, expected file or targetField", keyNode.Value)
+		}
+	}
+
+	if file == "" && targetField == "" {
+		return "", "", invalid
+	}
+
+	return file, targetField, nil
+}
+
+// mergeConfig overrides the keys of the base with the ones of the node. The stages, the vars and the snippets are
+// merged by name, a stage of the node replaces the one of the base in place and new ones are added after them.
+func mergeConfig(base, node *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+
+		baseValueNode := getMappingValueNode(base, keyNode.Value)
+		if baseValueNode == nil {
+			base.Content = append(base.Content, keyNode, valueNode)
+			continue
+		}
+
+		switch keyNode.Value {
+		case "stages", "vars", "snippets":
+			if baseValueNode.Kind == yaml.MappingNode && valueNode.Kind == yaml.MappingNode {
+				mergeMappingNode(baseValueNode, valueNode)
+				continue
+			}
+		}
+
+		setMappingValueNode(base, keyNode.Value, valueNode)
+	}
+}
+
+// mergeMappingNode sets the pairs of the node on the base, the new keys are appended in their order
+func mergeMappingNode(base, node *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+
+		if getMappingValueNode(base, keyNode.Value) != nil {
+			setMappingValueNode(base, keyNode.Value, valueNode)
+			continue
+		}
+		base.Content = append(base.Content, keyNode, valueNode)
+	}
+}
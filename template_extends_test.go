@@ -0,0 +1,102 @@
+package dockerfilegenerator
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtends(t *testing.T) {
+	dir := writeIncludeFiles(t, map[string]string{
+		"base.yaml": `
+vars:
+  alpine: "3.11"
+directives:
+  syntax: docker/dockerfile:1
+stages:
+  builder:
+    - from:
+        image: golang
+  final:
+    - from:
+        image: alpine:${{ .vars.alpine }}
+    - cmd:
+        params: [app]
+`,
+		"app.yaml": `
+prod:
+  extends: base.yaml
+  vars:
+    alpine: "3.12"
+dev:
+  extends:
+    targetField: .prod
+  stages:
+    final:
+      - from:
+          image: alpine:edge
+    debug:
+      - from:
+          image: busybox
+`,
+		"overlay.yaml": `
+vars:
+  alpine: edge
+patches:
+  - stage: final
+    append:
+      - user: app
+`,
+	})
+
+	data, err := NewDockerFileDataFromYamlField(filepath.Join(dir, "app.yaml"), ".prod")
+	assert.NoError(t, err)
+	assert.Equal(t, Directives{Syntax: "docker/dockerfile:1"}, data.Directives)
+	assert.Equal(t, []Instruction{From{Image: "alpine:3.12"}, Cmd{Params: []string{"app"}, RunForm: ExecForm}}, data.Stages[1].Instructions)
+
+	data, err = NewDockerFileDataFromYamlField(filepath.Join(dir, "app.yaml"), ".dev")
+	assert.NoError(t, err)
+	assert.Equal(t, []Stage{
+		{Name: "builder", Instructions: []Instruction{From{Image: "golang"}}},
+		{Name: "final", Instructions: []Instruction{From{Image: "alpine:edge"}}},
+		{Name: "debug", Instructions: []Instruction{From{Image: "busybox"}}},
+	}, data.Stages)
+
+	data, err = NewDockerFileDataFromYamlReaderWithOptions(strings.NewReader("extends: base.yaml\n"), "", DecodeOptions{
+		Filename: filepath.Join(dir, "stdin.yaml"),
+		Overlays: []string{filepath.Join(dir, "overlay.yaml")},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Instruction{From{Image: "alpine:edge"}, Cmd{Params: []string{"app"}, RunForm: ExecForm}, User{User: "app"}}, data.Stages[1].Instructions)
+}
+
+func TestExtendsErrors(t *testing.T) {
+	dir := writeIncludeFiles(t, map[string]string{
+		"cycle.yaml":   "a:\n  extends: {targetField: .b}\nb:\n  extends: {targetField: .a}\n",
+		"missing.yaml": "extends: {targetField: .nothing}\n",
+		"invalid.yaml": "extends: {path: base.yaml}\n",
+		"base.yaml":    "stages:\n  final:\n    - from:\n        image: alpine\n    - expose:\n        ports: [abc]\n",
+		"app.yaml":     "extends: base.yaml\n",
+		"overlay.yaml": "extends: base.yaml\n",
+	})
+
+	name := func(file string) string {
+		return filepath.Join(dir, file)
+	}
+
+	for _, tt := range []struct {
+		file, targetField, expected string
+	}{
+		{"cycle.yaml", ".a", name("cycle.yaml") + ":4:12: Extends cycle " + name("cycle.yaml") + " .a -> " + name("cycle.yaml") + " .b -> " + name("cycle.yaml") + " .a"},
+		{"missing.yaml", "", name("missing.yaml") + ":1:10: Can't find the extended target field .nothing: Can't find key nothing"},
+		{"invalid.yaml", "", name("invalid.yaml") + `:1:11: Unknown extends key "path", expected file or targetField`},
+		{"app.yaml", "", name("base.yaml") + ":5:7: stages.final[1]: Failed to parse expose instruction: Invalid port \"abc\", expected a number between 1 and 65535 (extended by " + name("app.yaml") + ":1:10)"},
+	} {
+		_, err := NewDockerFileDataFromYamlField(name(tt.file), tt.targetField)
+		assert.EqualError(t, err, tt.expected, tt.file)
+	}
+
+	_, err := NewDockerFileDataFromYamlReaderWithOptions(strings.NewReader("stages: {}\n"), "", DecodeOptions{Overlays: []string{name("overlay.yaml")}})
+	assert.EqualError(t, err, name("overlay.yaml")+":1:10: An overlay can't have an extends key, it extends the input")
+}
@@ -0,0 +1,388 @@
+package dockerfilegenerator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StageGraph holds the dependencies between the stages of a DockerfileData.
+// A stage depends on the stages its FROM instruction builds on, the stages its COPY --from instructions copy from,
+// the stages its RUN --mount from= instructions mount and the stages listed in its DependsOn.
+type StageGraph struct {
+	data         *DockerfileData
+	dependencies [][]int
+}
+
+// Graph resolves the stage references of the data, unknown references, references to stages defined later,
+// duplicate stage names and dependency cycles are returned as ConfigErrors
+func (d *DockerfileData) Graph() (*StageGraph, error) {
+	g := &StageGraph{data: d, dependencies: make([][]int, len(d.Stages))}
+	var errs ConfigErrors
+
+	addError := func(stage, instruction int, format string, args ...interface{}) {
+		errs = append(errs, &ConfigError{
+			Stage:       d.stageName(stage),
+			Instruction: instruction,
+			Reason:      fmt.Sprintf(format, args...),
+		})
+	}
+
+	// flag names the reference in the errors, e.g. COPY --from
+	addFrom := func(stage, instruction int, flag, from string) {
+		if from == "" {
+			return
+		}
+
+		index, err := d.copyFromIndex(from)
+		if err != nil {
+			addError(stage, instruction, "%s %v", flag, err)
+		} else if index > stage {
+			addError(stage, instruction, "%s refers to stage %q, which is defined later", flag, from)
+		} else if index >= 0 {
+			g.addDependency(stage, index)
+		}
+	}
+
+	for i, stage := range d.Stages {
+		if alias := stage.Alias(); alias != "" {
+			if index := d.aliasIndex(alias); index < i {
+				addError(i, -1, "Duplicate stage name %q", alias)
+			}
+		}
+
+		for j, instruction := range stage.Instructions {
+			switch v := instruction.(type) {
+			case From:
+				// FROM <name> AS <name> builds on the image, the stage itself doesn't exist yet
+				index := d.aliasIndex(v.Image)
+				if index > i {
+					addError(i, j, "FROM refers to stage %q, which is defined later", v.Image)
+				} else if index >= 0 && index < i {
+					g.addDependency(i, index)
+				}
+			case CopyCommand:
+				addFrom(i, j, "COPY --from", v.From)
+			case RunCommand:
+				for _, mount := range v.Mounts {
+					addFrom(i, j, "RUN --mount from", mount.From)
+				}
+			}
+		}
+
+		for _, dependency := range stage.DependsOn {
+			index := d.stageIndex(dependency)
+			if index < 0 {
+				addError(i, -1, "Unknown stage %q in dependsOn", dependency)
+				continue
+			}
+
+			g.addDependency(i, index)
+		}
+	}
+
+	for _, cycle := range g.cycles() {
+		names := make([]string, len(cycle))
+		for k, index := range cycle {
+			names[k] = d.stageName(index)
+		}
+
+		addError(cycle[0], -1, "Dependency cycle %s", strings.Join(names, " -> "))
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return g, nil
+}
+
+// validator is implemented by the instructions that can check their own values, e.g. Expose
+type validator interface {
+	Validate() error
+}
+
+// Validate checks the instructions that implement Validate() error and the stage references of the data, see Graph
+func (d *DockerfileData) Validate() error {
+	var errs ConfigErrors
+
+	if err := d.Directives.Validate(); err != nil {
+		errs = append(errs, &ConfigError{Instruction: -1, Reason: err.Error()})
+	}
+
+	for _, platform := range d.Platforms {
+		if err := validatePlatform(platform); err != nil {
+			errs = append(errs, &ConfigError{Instruction: -1, Reason: err.Error()})
+		}
+	}
+
+	scriptForm := d.ScriptForm
+	if err := validateScript("", "", scriptForm); err != nil {
+		errs = append(errs, &ConfigError{Instruction: -1, Reason: err.Error()})
+		scriptForm = ""
+	}
+
+	for i, stage := range d.Stages {
+		// scripts are checked in the form they are rendered in, which may come from the data
+		for j, instruction := range stage.renderInstructions(scriptForm) {
+			var err error
+			switch v := instruction.(type) {
+			case From:
+				// FROM <stage> builds on a stage, the image isn't a reference
+				if d.aliasIndex(v.Image) >= 0 {
+					err = v.validatePlatform()
+				} else {
+					err = v.Validate()
+				}
+			case validator:
+				err = v.Validate()
+			}
+
+			if err != nil {
+				errs = append(errs, &ConfigError{Stage: d.stageName(i), Instruction: j, Reason: err.Error()})
+			}
+		}
+	}
+
+	_, err := d.Graph()
+	if graphErrs, ok := err.(ConfigErrors); ok {
+		errs = append(errs, graphErrs...)
+	} else if err != nil {
+		return err
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// Dependencies returns the names of the stages the given stage directly depends on, in the order they are declared
+func (g *StageGraph) Dependencies(name string) []string {
+	index := g.data.stageIndex(name)
+	if index < 0 {
+		return nil
+	}
+
+	var res []string
+	for _, dependency := range g.dependencies[index] {
+		res = append(res, g.data.stageName(dependency))
+	}
+
+	return res
+}
+
+// Prune returns a copy of the data that contains only the target stage and the stages it depends on, directly or not.
+// The order of the stages is kept and COPY --from=<index> references are updated to the new indexes.
+func (d *DockerfileData) Prune(target string) (*DockerfileData, error) {
+	g, err := d.Graph()
+	if err != nil {
+		return nil, err
+	}
+
+	targetIndex := d.stageIndex(target)
+	if targetIndex < 0 {
+		return nil, fmt.Errorf("Unknown target stage %q", target)
+	}
+
+	keep := map[int]bool{}
+	queue := []int{targetIndex}
+	for len(queue) > 0 {
+		index := queue[0]
+		queue = queue[1:]
+
+		if keep[index] {
+			continue
+		}
+
+		keep[index] = true
+		queue = append(queue, g.dependencies[index]...)
+	}
+
+	newIndexes := map[int]int{}
+	for i := range d.Stages {
+		if keep[i] {
+			newIndexes[i] = len(newIndexes)
+		}
+	}
+
+	res := &DockerfileData{Directives: d.Directives, ScriptForm: d.ScriptForm, Platforms: d.Platforms}
+	var globalArgs []Instruction
+	global := true
+
+	for i, stage := range d.Stages {
+		leading, hasFrom := leadingInstructions(stage)
+
+		if !keep[i] {
+			// global ARGs in front of the first FROM are used by the FROM instructions of the following stages,
+			// they can be in an ARG-only stage or in front of the FROM of a pruned stage
+			if global {
+				globalArgs = append(globalArgs, leading...)
+			}
+			global = global && !hasFrom
+			continue
+		}
+		global = global && !hasFrom
+
+		instructions := append([]Instruction{}, globalArgs...)
+		globalArgs = nil
+
+		for _, instruction := range stage.Instructions {
+			switch v := instruction.(type) {
+			case CopyCommand:
+				if index, err := strconv.Atoi(v.From); err == nil {
+					v.From = strconv.Itoa(newIndexes[index])
+					instruction = v
+				}
+			case RunCommand:
+				mounts := make([]Mount, len(v.Mounts))
+				for k, mount := range v.Mounts {
+					if index, err := strconv.Atoi(mount.From); err == nil {
+						mount.From = strconv.Itoa(newIndexes[index])
+					}
+					mounts[k] = mount
+				}
+				if len(mounts) > 0 {
+					v.Mounts = mounts
+					instruction = v
+				}
+			}
+
+			instructions = append(instructions, instruction)
+		}
+
+		stage.Instructions = instructions
+		res.Stages = append(res.Stages, stage)
+	}
+
+	return res, nil
+}
+
+func (g *StageGraph) addDependency(stage, dependency int) {
+	for _, existing := range g.dependencies[stage] {
+		if existing == dependency {
+			return
+		}
+	}
+
+	g.dependencies[stage] = append(g.dependencies[stage], dependency)
+}
+
+// cycles returns the dependency cycles in the form of stage indexes, each cycle is reported once
+func (g *StageGraph) cycles() [][]int {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	var res [][]int
+	state := make([]int, len(g.dependencies))
+	var path []int
+
+	var visit func(index int)
+	visit = func(index int) {
+		state[index] = visiting
+		path = append(path, index)
+
+		for _, dependency := range g.dependencies[index] {
+			switch state[dependency] {
+			case unvisited:
+				visit(dependency)
+			case visiting:
+				for k := range path {
+					if path[k] == dependency {
+						cycle := append([]int{}, path[k:]...)
+						res = append(res, append(cycle, dependency))
+						break
+					}
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[index] = visited
+	}
+
+	for i := range g.dependencies {
+		if state[i] == unvisited {
+			visit(i)
+		}
+	}
+
+	return res
+}
+
+// stageIndex returns the index of the stage with the given name or alias, -1 if there is no such stage
+func (d *DockerfileData) stageIndex(name string) int {
+	for i, stage := range d.Stages {
+		if stage.Name == name || stage.Alias() == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// aliasIndex returns the index of the stage rendered with the given alias, -1 if there is no such stage
+func (d *DockerfileData) aliasIndex(alias string) int {
+	for i, stage := range d.Stages {
+		if stage.Alias() == alias {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// copyFromIndex returns the index of the stage a COPY --from or RUN --mount from= value refers to, -1 if it refers to
+// an image.
+// A name that matches no stage is pulled as an image, e.g. nginx, and values with variables are resolved by Docker.
+func (d *DockerfileData) copyFromIndex(from string) (int, error) {
+	if strings.Contains(from, "$") {
+		return -1, nil
+	}
+
+	if index := d.aliasIndex(from); index >= 0 {
+		return index, nil
+	}
+
+	if index, err := strconv.Atoi(from); err == nil {
+		if index < 0 || index >= len(d.Stages) {
+			return 0, fmt.Errorf("refers to stage index %d, there are %d stages", index, len(d.Stages))
+		}
+		return index, nil
+	}
+
+	return -1, nil
+}
+
+// copyFromWarning reports a COPY --from or RUN --mount from= image without a tag, registry or digest, it is most
+// likely a misspelled stage, flag names the reference in the warning, e.g. COPY --from
+func (d *DockerfileData) copyFromWarning(flag, from string) []string {
+	if from == "" || strings.ContainsAny(from, "$:/@") {
+		return nil
+	}
+
+	if index, _ := d.copyFromIndex(from); index >= 0 {
+		return nil
+	}
+	if _, err := strconv.Atoi(from); err == nil {
+		return nil
+	}
+
+	return []string{fmt.Sprintf("%s=%s matches no stage and is pulled as the image %s:latest, use the tag if the image is intended", flag, from, from)}
+}
+
+// leadingInstructions returns the instructions in front of the first FROM instruction of the stage, all of them when
+// the stage has no FROM, e.g. a stage of global ARGs
+func leadingInstructions(stage Stage) ([]Instruction, bool) {
+	for i, instruction := range stage.Instructions {
+		if _, ok := instruction.(From); ok {
+			return stage.Instructions[:i], true
+		}
+	}
+
+	return stage.Instructions, false
+}
@@ -0,0 +1,157 @@
+package dockerfilegenerator
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newGraphTestData() *DockerfileData {
+	return &DockerfileData{
+		Stages: []Stage{
+			NewStage("base", Arg{Name: "VERSION", Value: "1.13"}, From{Image: "golang:${VERSION}"}),
+			NewStage("deps", From{Image: "base"}, RunCommand{Params: []string{"go mod download"}}),
+			NewStage("docs", From{Image: "alpine"}),
+			NewStage("builder", From{Image: "deps"}, CopyCommand{Sources: []string{"/config"}, Destination: "/config", From: "0"}),
+			{Name: "final", DependsOn: []string{"docs"}, Instructions: []Instruction{
+				From{Image: "alpine"},
+				CopyCommand{Sources: []string{"/app"}, Destination: "/app", From: "builder"},
+				CopyCommand{Sources: []string{"/etc/nginx"}, Destination: "/etc/nginx", From: "nginx:latest"},
+			}},
+			NewStage("test", From{Image: "builder"}),
+		},
+	}
+}
+
+func TestStageGraph(t *testing.T) {
+	data := newGraphTestData()
+
+	graph, err := data.Graph()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"base"}, graph.Dependencies("deps"))
+	assert.Equal(t, []string{"deps", "base"}, graph.Dependencies("builder"))
+	assert.Equal(t, []string{"builder", "docs"}, graph.Dependencies("final"))
+	assert.Empty(t, graph.Dependencies("docs"))
+	assert.Empty(t, graph.Dependencies("missing"))
+}
+
+func TestStageGraphErrors(t *testing.T) {
+	data := &DockerfileData{
+		Stages: []Stage{
+			NewStage("a", From{Image: "b"}),
+			NewStage("b", From{Image: "alpine"}, CopyCommand{From: "nginx"}, CopyCommand{From: "5"}, CopyCommand{From: "b"}),
+			{Name: "c", DependsOn: []string{"d", "missing"}, Instructions: []Instruction{From{Image: "alpine"}}},
+			{Name: "d", DependsOn: []string{"c"}, Instructions: []Instruction{From{Image: "alpine"}}},
+			NewStage("e", From{Image: "alpine", As: "d"}),
+		},
+	}
+
+	err := data.Validate()
+	assert.EqualError(t, err, `stages.a[0]: FROM refers to stage "b", which is defined later
+stages.b[2]: COPY --from refers to stage index 5, there are 5 stages
+stages.c: Unknown stage "missing" in dependsOn
+stages.e: Duplicate stage name "d"
+stages.b: Dependency cycle b -> b
+stages.c: Dependency cycle c -> d -> c`)
+}
+
+func TestPrune(t *testing.T) {
+	data, err := newGraphTestData().Prune("final")
+	assert.NoError(t, err)
+
+	output := &bytes.Buffer{}
+	err = NewDockerfileTemplate(data).Render(output)
+	assert.NoError(t, err)
+
+	expectedOutput := `ARG VERSION=1.13
+FROM golang:${VERSION} as base
+
+FROM base as deps
+RUN go mod download
+
+FROM alpine as docs
+
+FROM deps as builder
+COPY --from=0 /config /config
+
+FROM alpine as final
+COPY --from=builder /app /app
+COPY --from=nginx:latest /etc/nginx /etc/nginx
+
+`
+	assert.Equal(t, expectedOutput, output.String())
+
+	// the global ARG of the pruned first stage is kept and the stage indexes are updated
+	data = &DockerfileData{
+		Stages: []Stage{
+			NewStage("unused", Arg{Name: "VERSION"}, From{Image: "alpine"}),
+			NewStage("config", From{Image: "alpine:${VERSION}"}),
+			NewStage("final", From{Image: "scratch"}, CopyCommand{Sources: []string{"/etc"}, Destination: "/etc", From: "1"}),
+		},
+	}
+
+	data, err = data.Prune("final")
+	assert.NoError(t, err)
+	assert.Equal(t, []Stage{
+		NewStage("config", Arg{Name: "VERSION"}, From{Image: "alpine:${VERSION}"}),
+		NewStage("final", From{Image: "scratch"}, CopyCommand{Sources: []string{"/etc"}, Destination: "/etc", From: "0"}),
+	}, data.Stages)
+
+	// the global ARGs of a pruned ARG-only first stage are kept as well
+	data = &DockerfileData{
+		Stages: []Stage{
+			NewStage("args", Arg{Name: "VERSION", Value: "3.19"}),
+			NewStage("unused", Arg{Name: "DEBUG"}, From{Image: "golang"}),
+			NewStage("final", From{Image: "alpine:${VERSION}"}),
+		},
+	}
+
+	pruned, err := data.Prune("final")
+	assert.NoError(t, err)
+	assert.Equal(t, []Stage{
+		NewStage("final", Arg{Name: "VERSION", Value: "3.19"}, Arg{Name: "DEBUG"}, From{Image: "alpine:${VERSION}"}),
+	}, pruned.Stages)
+
+	_, err = data.Prune("missing")
+	assert.EqualError(t, err, `Unknown target stage "missing"`)
+}
+
+func TestStageGraphMounts(t *testing.T) {
+	data := &DockerfileData{
+		Stages: []Stage{
+			NewStage("unused", From{Image: "alpine"}),
+			NewStage("deps", From{Image: "golang"}, RunCommand{Params: []string{"go mod download"}}),
+			NewStage("config", From{Image: "alpine"}),
+			NewStage("final", From{Image: "golang"}, RunCommand{Params: []string{"go build"}, Mounts: []Mount{
+				{Type: BindMount, From: "deps", Target: "/go/pkg"},
+				{Type: BindMount, From: "2", Target: "/config"},
+			}}),
+		},
+	}
+
+	graph, err := data.Graph()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"deps", "config"}, graph.Dependencies("final"))
+
+	// the mounted stages are kept and the stage indexes are updated
+	pruned, err := data.Prune("final")
+	assert.NoError(t, err)
+	assert.Equal(t, []Stage{
+		data.Stages[1],
+		data.Stages[2],
+		NewStage("final", From{Image: "golang"}, RunCommand{Params: []string{"go build"}, Mounts: []Mount{
+			{Type: BindMount, From: "deps", Target: "/go/pkg"},
+			{Type: BindMount, From: "1", Target: "/config"},
+		}}),
+	}, pruned.Stages)
+
+	data.Stages = []Stage{
+		NewStage("final", From{Image: "golang"}, RunCommand{Params: []string{"go build"}, Mounts: []Mount{
+			{Type: BindMount, From: "deps", Target: "/go/pkg"},
+			{Type: BindMount, From: "3", Target: "/src"},
+		}}),
+		NewStage("deps", From{Image: "golang"}),
+	}
+	assert.EqualError(t, data.Validate(), `stages.final[1]: RUN --mount from refers to stage "deps", which is defined later
+stages.final[1]: RUN --mount from refers to stage index 3, there are 2 stages`)
+}
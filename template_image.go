@@ -0,0 +1,230 @@
+package dockerfilegenerator
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// BuildPlatform is the platform of the builder, stages that only produce files for other stages are run on it
+const BuildPlatform = "$BUILDPLATFORM"
+
+// ImageReference is an image reference split into its parts, see https://docs.docker.com/engine/reference/commandline/tag/
+// It's rendered as [<registry>/]<repository>[:<tag>][@<digest>].
+type ImageReference struct {
+	Registry   string `yaml:"registry"`
+	Repository string `yaml:"repository"`
+	Tag        string `yaml:"tag"`
+	Digest     string `yaml:"digest"`
+}
+
+var (
+	registryRegexp   = regexp.MustCompile(`^(localhost|[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)+|\[[0-9A-Fa-f:]+\])(:[0-9]+)?$|^[A-Za-z0-9-]+:[0-9]+$`)
+	repositoryRegexp = regexp.MustCompile(`^[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*(/[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*)*$`)
+	tagRegexp        = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+	digestRegexp     = regexp.MustCompile(`^[a-z0-9]+([+._-][a-z0-9]+)*:[A-Za-z0-9=_-]{32,}$`)
+	platformRegexp   = regexp.MustCompile(`^[a-z0-9]+/[a-z0-9_]+(/[a-z0-9]+)?$`)
+)
+
+// ParseImageReference splits an image, e.g. registry.example.com:5000/team/app:1.0@sha256:..., into its parts.
+// The first path component is the registry when it has a dot, a port or is localhost, as the docker CLI reads it.
+func ParseImageReference(image string) (ImageReference, error) {
+	var ref ImageReference
+	rest := image
+	invalid := func(format string, args ...interface{}) (ImageReference, error) {
+		return ImageReference{}, fmt.Errorf("Invalid image %q: "+format, append([]interface{}{image}, args...)...)
+	}
+
+	if index := strings.Index(rest, "@"); index >= 0 {
+		ref.Digest = rest[index+1:]
+		rest = rest[:index]
+
+		if ref.Digest == "" {
+			return invalid("the digest after @ is empty")
+		}
+	}
+
+	if index := strings.LastIndex(rest, ":"); index > strings.LastIndex(rest, "/") {
+		ref.Tag = rest[index+1:]
+		rest = rest[:index]
+
+		if ref.Tag == "" {
+			return invalid("the tag after : is empty")
+		}
+	}
+
+	if index := strings.Index(rest, "/"); index >= 0 {
+		first := rest[:index]
+		if strings.ContainsAny(first, ".:[") || first == "localhost" {
+			ref.Registry = first
+			rest = rest[index+1:]
+		}
+	}
+
+	ref.Repository = rest
+
+	if err := ref.Validate(); err != nil {
+		return invalid("%v", err)
+	}
+
+	return ref, nil
+}
+
+// String returns the reference in the form of [<registry>/]<repository>[:<tag>][@<digest>]
+func (r ImageReference) String() string {
+	res := r.Repository
+
+	if r.Registry != "" {
+		res = r.Registry + "/" + res
+	}
+
+	if r.Tag != "" {
+		res += ":" + r.Tag
+	}
+
+	if r.Digest != "" {
+		res += "@" + r.Digest
+	}
+
+	return res
+}
+
+// Validate checks every part of the reference, the repository is required
+func (r ImageReference) Validate() error {
+	if r.Repository == "" {
+		return errors.New("the repository is required")
+	}
+
+	if r.Registry != "" && !registryRegexp.MatchString(r.Registry) {
+		return fmt.Errorf("invalid registry %q, expected a host with an optional port, e.g. registry.example.com:5000", r.Registry)
+	}
+
+	if !repositoryRegexp.MatchString(r.Repository) {
+		return fmt.Errorf("invalid repository %q, expected lowercase path components separated by /", r.Repository)
+	}
+
+	if r.Tag != "" && !tagRegexp.MatchString(r.Tag) {
+		return fmt.Errorf("invalid tag %q, expected up to 128 letters, digits, _, . or -", r.Tag)
+	}
+
+	if r.Digest != "" && !digestRegexp.MatchString(r.Digest) {
+		return fmt.Errorf("invalid digest %q, expected <algorithm>:<hex>, e.g. sha256:...", r.Digest)
+	}
+
+	if strings.HasPrefix(r.Digest, "sha256:") && len(r.Digest) != len("sha256:")+64 {
+		return fmt.Errorf("invalid digest %q, sha256 digests have 64 hex characters", r.Digest)
+	}
+
+	return nil
+}
+
+// validatePlatform checks a platform in the form of <os>/<arch>[/<variant>], ARG references are accepted as they are
+func validatePlatform(platform string) error {
+	if strings.Contains(platform, "$") || platformRegexp.MatchString(platform) {
+		return nil
+	}
+
+	return fmt.Errorf("Invalid platform %q, expected <os>/<arch>[/<variant>], e.g. linux/arm64", platform)
+}
+
+// crossCompileArgs returns the ARGs the builder stages need to build for the given target platforms
+func crossCompileArgs(platforms []string) []string {
+	args := []string{"TARGETOS", "TARGETARCH"}
+
+	for _, platform := range platforms {
+		if strings.Count(platform, "/") == 2 {
+			return append(args, "TARGETVARIANT")
+		}
+	}
+
+	return args
+}
+
+// withCrossCompilation returns the instructions of the builder stages run on the build platform for a
+// multi-platform build. A builder stage is one that other stages copy files from, its first FROM gets
+// --platform=$BUILDPLATFORM and is followed by the TARGETOS and TARGETARCH ARGs it doesn't declare.
+// Stages that set a platform or build on another stage are left as they are.
+func (d *DockerfileData) withCrossCompilation(stages [][]Instruction) ([][]Instruction, error) {
+	if len(d.Platforms) == 0 {
+		return stages, nil
+	}
+
+	// the validation errors are the ones of the stage references
+	if _, err := d.Graph(); err != nil {
+		return nil, err
+	}
+
+	builders := d.builderStages()
+
+	res := make([][]Instruction, len(stages))
+	for i, instructions := range stages {
+		res[i] = instructions
+		if !builders[i] {
+			continue
+		}
+
+		for j, instruction := range instructions {
+			from, ok := instruction.(From)
+			if !ok {
+				continue
+			}
+
+			if from.Platform != "" || d.aliasIndex(from.Image) >= 0 {
+				break
+			}
+
+			// ARGs declared in front of FROM aren't visible in the stage
+			declared := map[string]bool{}
+			for _, next := range instructions[j+1:] {
+				if arg, ok := next.(Arg); ok {
+					declared[arg.Name] = true
+				}
+			}
+
+			from.Platform = BuildPlatform
+			stage := append(append([]Instruction{}, instructions[:j]...), from)
+			for _, name := range crossCompileArgs(d.Platforms) {
+				if !declared[name] {
+					stage = append(stage, Arg{Name: name})
+				}
+			}
+			res[i] = append(stage, instructions[j+1:]...)
+
+			break
+		}
+	}
+
+	return res, nil
+}
+
+// builderStages returns the stages other stages copy files from with COPY --from or RUN --mount from=.
+// A stage used as a FROM base isn't one, its image is the one of the target platform, e.g. a base of the final stage.
+func (d *DockerfileData) builderStages() map[int]bool {
+	builders := map[int]bool{}
+
+	addBuilder := func(from string) {
+		if from == "" {
+			return
+		}
+
+		if index, err := d.copyFromIndex(from); err == nil && index >= 0 {
+			builders[index] = true
+		}
+	}
+
+	for _, stage := range d.Stages {
+		for _, instruction := range stage.Instructions {
+			switch v := instruction.(type) {
+			case CopyCommand:
+				addBuilder(v.From)
+			case RunCommand:
+				for _, mount := range v.Mounts {
+					addBuilder(mount.From)
+				}
+			}
+		}
+	}
+
+	return builders
+}
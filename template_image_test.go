@@ -0,0 +1,168 @@
+package dockerfilegenerator
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestImageReference(t *testing.T) {
+	digest := "sha256:24454f830cdb571e2c4ad15481119c43b3cafd48dd869a9b2945d1036d1dc68d"
+
+	for image, expected := range map[string]ImageReference{
+		"alpine":                                {Repository: "alpine"},
+		"golang:1.13-alpine":                    {Repository: "golang", Tag: "1.13-alpine"},
+		"library/ubuntu@" + digest:              {Repository: "library/ubuntu", Digest: digest},
+		"ghcr.io/org/app:1.0@" + digest:         {Registry: "ghcr.io", Repository: "org/app", Tag: "1.0", Digest: digest},
+		"localhost/app":                         {Registry: "localhost", Repository: "app"},
+		"registry.example.com:5000/team/app:v2": {Registry: "registry.example.com:5000", Repository: "team/app", Tag: "v2"},
+		"registry:5000/app":                     {Registry: "registry:5000", Repository: "app"},
+	} {
+		ref, err := ParseImageReference(image)
+		assert.NoError(t, err, image)
+		assert.Equal(t, expected, ref, image)
+		assert.Equal(t, image, ref.String())
+	}
+
+	for image, expected := range map[string]string{
+		"":                                `Invalid image "": the repository is required`,
+		"Alpine":                          `Invalid image "Alpine": invalid repository "Alpine", expected lowercase path components separated by /`,
+		"alpine:":                         `Invalid image "alpine:": the tag after : is empty`,
+		"alpine:-latest":                  `Invalid image "alpine:-latest": invalid tag "-latest", expected up to 128 letters, digits, _, . or -`,
+		"alpine@":                         `Invalid image "alpine@": the digest after @ is empty`,
+		"alpine@sha256:abc":               `Invalid image "alpine@sha256:abc": invalid digest "sha256:abc", expected <algorithm>:<hex>, e.g. sha256:...`,
+		"my_registry.com:x/app":           `Invalid image "my_registry.com:x/app": invalid registry "my_registry.com:x", expected a host with an optional port, e.g. registry.example.com:5000`,
+		"app@sha256:" + digest[7:] + "00": `Invalid image "app@sha256:` + digest[7:] + `00": invalid digest "sha256:` + digest[7:] + `00", sha256 digests have 64 hex characters`,
+	} {
+		_, err := ParseImageReference(image)
+		assert.EqualError(t, err, expected, image)
+	}
+}
+
+func TestValidateFrom(t *testing.T) {
+	data := &DockerfileData{
+		Platforms: []string{"linux/amd64", "linux"},
+		Stages: []Stage{
+			NewStage("builder", Arg{Name: "VERSION"}, From{Image: "golang:${VERSION}", Platform: "$BUILDPLATFORM"}),
+			NewStage("test", From{Image: "builder", Platform: "linux/ARM64"}),
+			NewStage("final", From{Image: "Alpine"}),
+		},
+	}
+
+	assert.EqualError(t, data.Validate(), `Invalid platform "linux", expected <os>/<arch>[/<variant>], e.g. linux/arm64
+stages.test[0]: Invalid platform "linux/ARM64", expected <os>/<arch>[/<variant>], e.g. linux/arm64
+stages.final[0]: Invalid image "Alpine": invalid repository "Alpine", expected lowercase path components separated by /`)
+}
+
+func TestCrossCompilation(t *testing.T) {
+	data := &DockerfileData{
+		Platforms: []string{"linux/amd64", "linux/arm64"},
+		Stages: []Stage{
+			NewStage("builder",
+				From{Image: "golang:1.13"},
+				Arg{Name: "TARGETARCH"},
+				RunCommand{Params: []string{"GOOS=$TARGETOS GOARCH=$TARGETARCH go build -o /app"}},
+			),
+			NewStage("tools", From{Image: "alpine", Platform: "linux/amd64"}),
+			NewStage("test", From{Image: "builder"}),
+			{Name: "final", DependsOn: []string{"tools", "test"}, Instructions: []Instruction{
+				From{Image: "alpine"},
+				CopyCommand{From: "builder", Sources: []string{"/app"}, Destination: "/app"},
+			}},
+		},
+	}
+
+	output := &bytes.Buffer{}
+	assert.NoError(t, NewDockerfileTemplate(data).Render(output))
+	assert.Equal(t, `FROM --platform=$BUILDPLATFORM golang:1.13 as builder
+ARG TARGETOS
+ARG TARGETARCH
+RUN GOOS=$TARGETOS GOARCH=$TARGETARCH go build -o /app
+
+FROM --platform=linux/amd64 alpine as tools
+
+FROM builder as test
+
+FROM alpine as final
+COPY --from=builder /app /app
+
+`, output.String())
+
+	// the variant is only declared when a platform has one
+	data.Platforms = append(data.Platforms, "linux/arm/v7")
+	output.Reset()
+	assert.NoError(t, NewDockerfileTemplate(data).Render(output))
+	assert.True(t, strings.HasPrefix(output.String(), "FROM --platform=$BUILDPLATFORM golang:1.13 as builder\nARG TARGETOS\nARG TARGETVARIANT\nARG TARGETARCH\n"), output.String())
+
+	// validation indexes aren't shifted by the inserted ARGs
+	data.Stages[0].Instructions = append(data.Stages[0].Instructions, Expose{Ports: []string{"0"}})
+	assert.EqualError(t, data.Validate(), `stages.builder[3]: Invalid port "0", expected a number between 1 and 65535`)
+}
+
+func TestPlatformsYaml(t *testing.T) {
+	data, err := NewDockerFileDataFromYamlReader(strings.NewReader(`
+platforms: [linux/amd64, linux/arm64]
+stages:
+  final:
+    - from:
+        image:
+          registry: ghcr.io
+          repository: org/app
+          tag: "1.10"
+`), "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"linux/amd64", "linux/arm64"}, data.Platforms)
+	assert.Equal(t, []Instruction{From{Image: "ghcr.io/org/app:1.10"}}, data.Stages[0].Instructions)
+
+	_, err = NewDockerFileDataFromYamlReader(strings.NewReader("platforms: [linux/amd64, windows, [a]]\nstages: {}\n"), "")
+	assert.EqualError(t, err, `1:26: Invalid platform "windows", expected <os>/<arch>[/<variant>], e.g. linux/arm64
+1:35: Platform should be a string`)
+
+	_, err = NewDockerFileDataFromYamlReader(strings.NewReader("platforms: linux/amd64\nstages: {}\n"), "")
+	assert.EqualError(t, err, "1:12: Platforms should be a list, e.g. [linux/amd64, linux/arm64]")
+
+	_, err = NewDockerFileDataFromYamlReader(strings.NewReader(`
+stages:
+  final:
+    - from:
+        image:
+          repository: Org/App
+    - from:
+        image:
+          name: app
+`), "")
+	assert.EqualError(t, err, `4:7: stages.final[0]: Failed to parse from instruction: Invalid image: invalid repository "Org/App", expected lowercase path components separated by /
+7:7: stages.final[1]: Failed to parse from instruction: Unknown image key "name", expected registry, repository, tag or digest`)
+}
+
+func TestCrossCompilationBaseStages(t *testing.T) {
+	data := &DockerfileData{
+		Platforms: []string{"linux/amd64", "linux/arm64"},
+		Stages: []Stage{
+			NewStage("base", From{Image: "alpine"}, RunCommand{Params: []string{"apk add ca-certificates"}}),
+			NewStage("assets", From{Image: "node"}, RunCommand{Params: []string{"npm run build"}}),
+			NewStage("final",
+				From{Image: "base"},
+				RunCommand{Params: []string{"cp -r /assets /srv"}, Mounts: []Mount{{Type: BindMount, From: "assets", Target: "/assets"}}},
+			),
+		},
+	}
+
+	// base stays on the target platform since the final image builds on it
+	output := &bytes.Buffer{}
+	assert.NoError(t, NewDockerfileTemplate(data).Render(output))
+	assert.Equal(t, `# syntax=docker/dockerfile:1.2
+FROM alpine as base
+RUN apk add ca-certificates
+
+FROM --platform=$BUILDPLATFORM node as assets
+ARG TARGETOS
+ARG TARGETARCH
+RUN npm run build
+
+FROM base as final
+RUN --mount=type=bind,target=/assets,from=assets cp -r /assets /srv
+
+`, output.String())
+}
@@ -0,0 +1,256 @@
+package dockerfilegenerator
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// includedFile is a file read for an include or an extends key or an overlay,
+// note tells where it comes from in the errors, e.g. included from app.yaml:3:5
+type includedFile struct {
+	filename string
+	note     string
+}
+
+// nodeOrigins maps the nodes of the included files to the index of their file, the errors found after the stages
+// are merged are traced back to the included file with the node they were found at
+type nodeOrigins map[*yaml.Node]int
+
+// add maps the node and its children to the file
+func (o nodeOrigins) add(node *yaml.Node, file int) {
+	o[node] = file
+	for _, child := range node.Content {
+		o.add(child, file)
+	}
+}
+
+// clone returns a deep copy of the node, the copied nodes keep the file of the nodes they are copied from
+func (o nodeOrigins) clone(node *yaml.Node) *yaml.Node {
+	clone := cloneNode(node)
+	o.copy(node, clone)
+
+	return clone
+}
+
+func (o nodeOrigins) copy(node, clone *yaml.Node) {
+	if file, ok := o[node]; ok {
+		o[clone] = file
+	}
+
+	for i, child := range node.Content {
+		o.copy(child, clone.Content[i])
+	}
+}
+
+// includes merges the included files, the extended configs and the overlays into the config,
+// extending is the chain of the configs being extended, e.g. to report cycles
+type includes struct {
+	filename  string
+	files     []includedFile
+	origins   nodeOrigins
+	loaded    map[string]bool
+	extending []string
+}
+
+func newIncludes(filename string) *includes {
+	return &includes{filename: filename, origins: nodeOrigins{}, loaded: map[string]bool{}}
+}
+
+// apply merges the files included by the node, the paths are relative to the file of the node.
+// A file included twice is merged once, e.g. a base included by two other files, and cycles are errors.
+// The included stages come first, in the order of the include key, followed by the stages of the node.
+func (in *includes) apply(node *yaml.Node, filename string, chain []string) ConfigErrors {
+	includeNode := takeMappingValueNode(node, "include")
+	if includeNode == nil {
+		return nil
+	}
+
+	if includeNode.Kind != yaml.SequenceNode {
+		return ConfigErrors{newConfigError(includeNode, "Include should be a list of file paths, e.g. [base.yaml]")}
+	}
+
+	var errs ConfigErrors
+	var stages, snippets []*yaml.Node
+
+	for _, pathNode := range includeNode.Content {
+		if pathNode.Kind != yaml.ScalarNode || pathNode.Value == "" {
+			errs = append(errs, newConfigError(pathNode, "Include should be a list of file paths, e.g. [base.yaml]"))
+			continue
+		}
+
+		path := pathNode.Value
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(filename), path)
+		}
+		path = filepath.Clean(path)
+
+		if containsString(chain, path) {
+			cycle := append(append([]string{}, chain...), path)
+			errs = append(errs, newConfigError(pathNode, "Include cycle %s", strings.Join(cycle, " -> ")))
+			continue
+		}
+
+		if in.loaded[path] {
+			continue
+		}
+		in.loaded[path] = true
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			errs = append(errs, newConfigError(pathNode, "Can't read included file: %v", err))
+			continue
+		}
+
+		document, fileErrs := in.load(path, content, "included from "+in.position(pathNode))
+		if len(fileErrs) == 0 && document.Content[0].Kind != yaml.MappingNode {
+			fileErrs = ConfigErrors{newConfigError(document.Content[0], "Included file should be a map with stages or snippets keys")}
+		}
+		if len(fileErrs) == 0 {
+			fileErrs = in.apply(document.Content[0], path, append(chain, path))
+		}
+		if len(fileErrs) > 0 {
+			errs = append(errs, fileErrs...)
+			continue
+		}
+
+		root := document.Content[0]
+
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			keyNode, valueNode := root.Content[i], root.Content[i+1]
+
+			switch {
+			case keyNode.Value != "stages" && keyNode.Value != "snippets":
+				errs = append(errs, newConfigError(keyNode, "Included files can only have stages, snippets and include keys"))
+			case valueNode.Kind != yaml.MappingNode:
+				errs = append(errs, newConfigError(valueNode, "Included %s should be a map that has names as keys", keyNode.Value))
+			case keyNode.Value == "stages":
+				stages = append(stages, valueNode.Content...)
+			default:
+				snippets = append(snippets, valueNode.Content...)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	errs = append(errs, in.merge(node, "stages", "Stage", stages)...)
+	errs = append(errs, in.merge(node, "snippets", "Snippet", snippets)...)
+
+	return errs
+}
+
+// load decodes an included file into a document node, its format is picked from the extension, YAML by default.
+// Its nodes are mapped to the file so that the errors found later can be traced back to it.
+func (in *includes) load(path string, content []byte, note string) (*yaml.Node, ConfigErrors) {
+	var err error
+	node := &yaml.Node{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = unmarshallJSON(content, path, node)
+	case ".toml":
+		err = unmarshallTOML(content, path, node)
+	default:
+		err = unmarshallYaml(content, path, node)
+	}
+
+	if errs, ok := err.(ConfigErrors); ok {
+		for _, configErr := range errs {
+			configErr.Reason = withNote(configErr.Reason, note)
+		}
+		return nil, errs
+	}
+	if err != nil {
+		return nil, ConfigErrors{&ConfigError{Filename: path, Instruction: -1, Reason: withNote(err.Error(), note)}}
+	}
+
+	in.files = append(in.files, includedFile{filename: path, note: note})
+	in.origins.add(node, len(in.files)-1)
+
+	return node, nil
+}
+
+// merge adds the included pairs of names and values before the ones of the given key of the node
+func (in *includes) merge(node *yaml.Node, key, kind string, included []*yaml.Node) ConfigErrors {
+	if len(included) == 0 {
+		return nil
+	}
+
+	valueNode := getMappingValueNode(node, key)
+	if valueNode == nil {
+		valueNode = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		setMappingValueNode(node, key, valueNode)
+	}
+
+	// the value is reported by the decoder as it is for a config without includes
+	if valueNode.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var errs ConfigErrors
+	defined := map[string]*yaml.Node{}
+	content := append(append([]*yaml.Node{}, included...), valueNode.Content...)
+
+	for i := 0; i+1 < len(content); i += 2 {
+		keyNode := content[i]
+		if first, ok := defined[keyNode.Value]; ok {
+			errs = append(errs, newConfigError(keyNode, "%s %q is already defined at %s", kind, keyNode.Value, in.position(first)))
+			continue
+		}
+		defined[keyNode.Value] = keyNode
+	}
+
+	valueNode.Content = content
+
+	return errs
+}
+
+// position returns the file, line and column of the node, e.g. base.yaml:3:5
+func (in *includes) position(node *yaml.Node) string {
+	err := &ConfigError{Filename: in.filename, Line: node.Line, Column: node.Column, node: node}
+	in.locate(err)
+
+	position := err.Filename
+	if err.Line > 0 {
+		position = fmt.Sprintf("%s:%d:%d", position, err.Line, err.Column)
+	}
+
+	return strings.TrimPrefix(position, ":")
+}
+
+// locate sets the included file of the error, errors of the including file keep their filename.
+// The node isn't needed afterwards, it is dropped so that the returned errors can be compared.
+func (in *includes) locate(err *ConfigError) {
+	index, ok := in.origins[err.node]
+	err.node = nil
+	if !ok {
+		if err.Filename == "" {
+			err.Filename = in.filename
+		}
+		return
+	}
+
+	err.Filename = in.files[index].filename
+	err.Reason = withNote(err.Reason, in.files[index].note)
+}
+
+// withNote appends the note that tells where an included file comes from to the reason of an error
+func withNote(reason, note string) string {
+	if note == "" {
+		return reason
+	}
+
+	return fmt.Sprintf("%s (%s)", reason, note)
+}
+
+// setFilename sets the file of each error, the one that includes the others by default
+func (in *includes) setFilename(errs ConfigErrors) {
+	for _, err := range errs {
+		in.locate(err)
+	}
+}
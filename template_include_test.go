@@ -0,0 +1,101 @@
+package dockerfilegenerator
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeIncludeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	return dir
+}
+
+func TestInclude(t *testing.T) {
+	data, err := NewDockerFileDataFromYamlFile("./example-input-files/test-input-with-include.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, []Stage{
+		{Name: "builder", Instructions: []Instruction{
+			From{Image: "golang:1.13-alpine"},
+			Workdir{Dir: "/src"},
+			RunCommand{Params: []string{"go build -o /app ."}, RunForm: ShellForm},
+		}},
+		{Name: "final", Instructions: []Instruction{
+			From{Image: "alpine:3.12"},
+			RunCommand{Params: []string{"apk add --no-cache ca-certificates"}, RunForm: ShellForm},
+			RunCommand{Params: []string{"addgroup -S app && adduser -S app -G app"}, RunForm: ShellForm},
+			User{User: "app"},
+			CopyCommand{From: "builder", Sources: []string{"/app"}, Destination: "/app"},
+		}},
+	}, data.Stages)
+
+	// a file included twice is merged once and the included stages use the vars of the including file
+	dir := writeIncludeFiles(t, map[string]string{
+		"base.yaml": "stages:\n  base:\n    - from:\n        image: alpine:${{ .vars.alpine }}\n",
+		"a.yaml":    "include: [base.yaml]\nsnippets:\n  a:\n    - workdir:\n        dir: /a\n",
+		"app.toml":  "include = [\"a.yaml\", \"base.yaml\"]\n\n[vars]\nalpine = \"3.12\"\n\n[[stages.final]]\nuse = \"a\"\n",
+	})
+	data, err = NewDockerFileDataFromTOMLFile(filepath.Join(dir, "app.toml"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Stage{
+		{Name: "base", Instructions: []Instruction{From{Image: "alpine:3.12"}}},
+		{Name: "final", Instructions: []Instruction{Workdir{Dir: "/a"}}},
+	}, data.Stages)
+}
+
+func TestIncludeErrors(t *testing.T) {
+	dir := writeIncludeFiles(t, map[string]string{
+		"app.yaml":       "include:\n  - base.yaml\nstages:\n  base:\n    - from:\n        image: alpine\n",
+		"base.yaml":      "stages:\n  base:\n    - from:\n        image: ${{ .vars.image }}\n    - use: missing\n",
+		"cycle.yaml":     "include: [loop.yaml]\nstages: {}\n",
+		"loop.yaml":      "include:\n  - cycle.yaml\n",
+		"missing.yaml":   "include: [nothing.yaml]\nstages: {}\n",
+		"vars.yaml":      "include: [with-vars.yaml]\nstages: {}\n",
+		"with-vars.yaml": "vars:\n  a: b\n",
+		"invalid.yaml":   "include: [broken.json]\nstages: {}\n",
+		"broken.json":    "{\"stages\": [}",
+		"decode.yaml":    "include: [stage.yaml]\nstages: {}\n",
+		"stage.yaml":     "stages:\n  final:\n    - from:\n        image: alpine\n    - expose:\n        ports: [abc]\n",
+		"use.yaml":       "include: [snippets.yaml]\nstages:\n  final:\n    - from:\n        image: alpine\n    - use: bad\n",
+		"snippets.yaml":  "snippets:\n  bad:\n    - expose:\n        ports: [abc]\n",
+		"looped.yaml":    "include: [each.yaml]\nstages: {}\n",
+		"each.yaml":      "stages:\n  final:\n    - from:\n        image: alpine\n    - expose:\n        ports: [abc]\n        forEach: [a]\n",
+	})
+
+	name := func(file string) string {
+		return filepath.Join(dir, file)
+	}
+
+	for file, expected := range map[string]string{
+		"app.yaml": name("app.yaml") + `:4:3: Stage "base" is already defined at ` + name("base.yaml") + ":2:3",
+		"cycle.yaml": name("loop.yaml") + ":2:5: Include cycle " + strings.Join([]string{name("cycle.yaml"), name("loop.yaml"), name("cycle.yaml")}, " -> ") +
+			" (included from " + name("cycle.yaml") + ":1:11)",
+		"missing.yaml": name("missing.yaml") + ":1:11: Can't read included file: open " + name("nothing.yaml") + ": no such file or directory",
+		"vars.yaml":    name("with-vars.yaml") + ":1:1: Included files can only have stages, snippets and include keys (included from " + name("vars.yaml") + ":1:11)",
+		"invalid.yaml": name("broken.json") + ":1:13: Unexpected '}' in json (included from " + name("invalid.yaml") + ":1:11)",
+		"decode.yaml":  name("stage.yaml") + ":5:7: stages.final[1]: Failed to parse expose instruction: Invalid port \"abc\", expected a number between 1 and 65535 (included from " + name("decode.yaml") + ":1:11)",
+		"use.yaml":     name("snippets.yaml") + ":3:7: stages.final[1]: Failed to parse expose instruction: Invalid port \"abc\", expected a number between 1 and 65535 (included from " + name("use.yaml") + ":1:11)",
+		"looped.yaml":  name("each.yaml") + ":5:7: stages.final[1]: Failed to parse expose instruction: Invalid port \"abc\", expected a number between 1 and 65535 (included from " + name("looped.yaml") + ":1:11)",
+	} {
+		_, err := NewDockerFileDataFromYamlFile(name(file))
+		assert.EqualError(t, err, expected, file)
+	}
+}
+
+func TestIncludeKeepsLines(t *testing.T) {
+	in := newIncludes("app.yaml")
+	document, errs := in.load("base.yaml", []byte("stages:\n  base:\n    - from:\n        image: alpine\n"), "")
+	assert.Empty(t, errs)
+
+	// the nodes of an included file keep their lines, the file is known from the node itself
+	fromNode := document.Content[0].Content[1].Content[1].Content[0]
+	assert.Equal(t, 3, fromNode.Line)
+	assert.Equal(t, "base.yaml:3:7", in.position(in.origins.clone(fromNode)))
+	assert.Equal(t, "app.yaml:3:7", in.position(cloneNode(fromNode)))
+}
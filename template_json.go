@@ -0,0 +1,320 @@
+package dockerfilegenerator
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonParser converts json into a *yaml.Node tree, unlike decoding into a map it keeps the order of the keys
+// and the position of every value, so the yaml decoding functions can be used as they are.
+type jsonParser struct {
+	data   []byte
+	pos    int
+	line   int
+	column int
+}
+
+func newJSONParser(data []byte) *jsonParser {
+	return &jsonParser{data: data, line: 1, column: 1}
+}
+
+func (p *jsonParser) errorf(format string, args ...interface{}) *ConfigError {
+	return &ConfigError{Line: p.line, Column: p.column, Instruction: -1, Reason: fmt.Sprintf(format, args...)}
+}
+
+func (p *jsonParser) advance() {
+	// columns count characters, the continuation bytes of a multi-byte character don't move the column
+	if p.data[p.pos] == '\n' {
+		p.line++
+		p.column = 1
+	} else if utf8.RuneStart(p.data[p.pos]) {
+		p.column++
+	}
+
+	p.pos++
+}
+
+func (p *jsonParser) skipWhitespace() {
+	for p.pos < len(p.data) && strings.IndexByte(" \t\r\n", p.data[p.pos]) >= 0 {
+		p.advance()
+	}
+}
+
+// peek returns the next non whitespace character, 0 at the end of the input
+func (p *jsonParser) peek() byte {
+	p.skipWhitespace()
+
+	if p.pos >= len(p.data) {
+		return 0
+	}
+
+	return p.data[p.pos]
+}
+
+func (p *jsonParser) expect(char byte) error {
+	if p.peek() != char {
+		return p.errorf("Expected %q in json, found %s", char, p.describeNext())
+	}
+
+	p.advance()
+	return nil
+}
+
+func (p *jsonParser) describeNext() string {
+	if p.peek() == 0 {
+		return "end of input"
+	}
+
+	char, size := utf8.DecodeRune(p.data[p.pos:])
+	if char == utf8.RuneError && size <= 1 {
+		return fmt.Sprintf("byte %#x", p.data[p.pos])
+	}
+
+	return fmt.Sprintf("%q", char)
+}
+
+func (p *jsonParser) parseDocument() (*yaml.Node, error) {
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek() != 0 {
+		return nil, p.errorf("Unexpected %s after the json value", p.describeNext())
+	}
+
+	return &yaml.Node{Kind: yaml.DocumentNode, Line: value.Line, Column: value.Column, Content: []*yaml.Node{value}}, nil
+}
+
+func (p *jsonParser) parseValue() (*yaml.Node, error) {
+	char := p.peek()
+	line, column := p.line, p.column
+
+	var node *yaml.Node
+	var err error
+
+	switch {
+	case char == '{':
+		node, err = p.parseObject()
+	case char == '[':
+		node, err = p.parseArray()
+	case char == '"':
+		node, err = p.parseString()
+	case char == '-' || (char >= '0' && char <= '9'):
+		node, err = p.parseNumber()
+	case char == 't' || char == 'f' || char == 'n':
+		node, err = p.parseLiteral()
+	default:
+		return nil, p.errorf("Unexpected %s in json", p.describeNext())
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	node.Line = line
+	node.Column = column
+
+	return node, nil
+}
+
+func (p *jsonParser) parseObject() (*yaml.Node, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	p.advance()
+
+	if p.peek() == '}' {
+		p.advance()
+		return node, nil
+	}
+
+	for {
+		if p.peek() != '"' {
+			return nil, p.errorf("Expected an object key in json, found %s", p.describeNext())
+		}
+
+		key, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expect(':'); err != nil {
+			return nil, err
+		}
+
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		node.Content = append(node.Content, key, value)
+
+		if p.peek() == ',' {
+			p.advance()
+			continue
+		}
+
+		return node, p.expect('}')
+	}
+}
+
+func (p *jsonParser) parseArray() (*yaml.Node, error) {
+	node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	p.advance()
+
+	if p.peek() == ']' {
+		p.advance()
+		return node, nil
+	}
+
+	for {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		node.Content = append(node.Content, value)
+
+		if p.peek() == ',' {
+			p.advance()
+			continue
+		}
+
+		return node, p.expect(']')
+	}
+}
+
+func (p *jsonParser) parseString() (*yaml.Node, error) {
+	start, line, column := p.pos, p.line, p.column
+	p.advance()
+
+	for escaped := false; ; p.advance() {
+		if p.pos >= len(p.data) || p.data[p.pos] == '\n' {
+			return nil, p.errorf("Unterminated string in json")
+		}
+
+		char := p.data[p.pos]
+		if char == '"' && !escaped {
+			p.advance()
+			break
+		}
+
+		escaped = char == '\\' && !escaped
+	}
+
+	// the raw token is decoded by encoding/json to get the escape sequences right
+	var value string
+	if err := json.Unmarshal(p.data[start:p.pos], &value); err != nil {
+		p.line, p.column = line, column
+		return nil, p.errorf("Invalid string in json: %v", err)
+	}
+
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value, Style: yaml.DoubleQuotedStyle}, nil
+}
+
+func (p *jsonParser) parseNumber() (*yaml.Node, error) {
+	start, line, column := p.pos, p.line, p.column
+	for p.pos < len(p.data) && strings.IndexByte("+-0123456789.eE", p.data[p.pos]) >= 0 {
+		p.advance()
+	}
+
+	raw := string(p.data[start:p.pos])
+
+	var number json.Number
+	if err := json.Unmarshal([]byte(raw), &number); err != nil {
+		p.line, p.column = line, column
+		return nil, p.errorf("Invalid number %s in json", raw)
+	}
+
+	tag := "!!int"
+	if strings.ContainsAny(raw, ".eE") {
+		tag = "!!float"
+	}
+
+	// the original text is kept, e.g. a version number like 1.10 isn't turned into 1.1
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: raw}, nil
+}
+
+func (p *jsonParser) parseLiteral() (*yaml.Node, error) {
+	for _, literal := range []struct{ value, tag string }{{"true", "!!bool"}, {"false", "!!bool"}, {"null", "!!null"}} {
+		if strings.HasPrefix(string(p.data[p.pos:]), literal.value) {
+			for range literal.value {
+				p.advance()
+			}
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: literal.tag, Value: literal.value}, nil
+		}
+	}
+
+	return nil, p.errorf("Unexpected %s in json", p.describeNext())
+}
+
+func unmarshallJSONFile(filename string, node *yaml.Node) error {
+	jsonFile, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("jsonFile.Get err #%v", err)
+	}
+
+	return unmarshallJSON(jsonFile, filename, node)
+}
+
+func unmarshallJSON(content []byte, filename string, node *yaml.Node) error {
+	document, err := newJSONParser(content).parseDocument()
+	if configErr, ok := err.(*ConfigError); ok {
+		configErr.Filename = filename
+		return ConfigErrors{configErr}
+	}
+
+	*node = *document
+	return nil
+}
+
+// NewDockerFileDataFromJSONField reads a JSON file and tries to extract Dockerfile data
+// from the specified targetField option, it accepts the same keys and targetField syntax as the YAML input, e.g.
+// --target-field ".serverConfigs[0].docker.server"
+// Problems in the config are reported together as ConfigErrors.
+func NewDockerFileDataFromJSONField(filename, targetField string) (*DockerfileData, error) {
+	node := yaml.Node{}
+
+	err := unmarshallJSONFile(filename, &node)
+	if _, ok := err.(ConfigErrors); ok {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unmarshal: %v", err)
+	}
+
+	return newDockerFileDataFromYamlNode(&node, targetField, DecodeOptions{Filename: filename})
+}
+
+// NewDockerFileDataFromJSONFile reads a JSON file and returns a *DockerfileData, the order of the stages is kept.
+// Problems in the config are reported together as ConfigErrors.
+func NewDockerFileDataFromJSONFile(filename string) (*DockerfileData, error) {
+	return NewDockerFileDataFromJSONField(filename, "")
+}
+
+// NewDockerFileDataFromJSONReader reads JSON from r, e.g. os.Stdin, and extracts Dockerfile data from the targetField,
+// an empty targetField means the whole document. ConfigErrors returned by it have no Filename, the included files are
+// relative to the working directory.
+func NewDockerFileDataFromJSONReader(r io.Reader, targetField string) (*DockerfileData, error) {
+	return NewDockerFileDataFromJSONReaderWithOptions(r, targetField, DecodeOptions{})
+}
+
+// NewDockerFileDataFromJSONReaderWithOptions works like NewDockerFileDataFromJSONReader with the given DecodeOptions,
+// e.g. the variables of dfg generate --set.
+func NewDockerFileDataFromJSONReaderWithOptions(r io.Reader, targetField string, opts DecodeOptions) (*DockerfileData, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Unmarshal: jsonReader.Read err #%v", err)
+	}
+
+	node := yaml.Node{}
+	if err := unmarshallJSON(content, opts.Filename, &node); err != nil {
+		return nil, err
+	}
+
+	return newDockerFileDataFromYamlNode(&node, targetField, opts)
+}
@@ -0,0 +1,102 @@
+package dockerfilegenerator
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestJSONRendering(t *testing.T) {
+	data, err := NewDockerFileDataFromJSONFile("./example-input-files/test-input.json")
+	assert.NoError(t, err)
+
+	output := &bytes.Buffer{}
+	err = NewDockerfileTemplate(data).Render(output)
+	assert.NoError(t, err)
+
+	assert.Equal(t, expectedGenericOutput, output.String())
+}
+
+func TestJSONRenderingOnbuild(t *testing.T) {
+	data, err := NewDockerFileDataFromJSONFile("./example-input-files/test-input-with-onbuild.json")
+	assert.NoError(t, err)
+
+	output := &bytes.Buffer{}
+	err = NewDockerfileTemplate(data).Render(output)
+	assert.NoError(t, err)
+
+	assert.Equal(t, expectedOnbuildOutput, output.String())
+}
+
+func TestJSONRenderingTargetField(t *testing.T) {
+	data, err := NewDockerFileDataFromJSONField("./example-input-files/test-input-with-target-key.json", ".services[1].dockerfile")
+	assert.NoError(t, err)
+
+	output := &bytes.Buffer{}
+	err = NewDockerfileTemplate(data).Render(output)
+	assert.NoError(t, err)
+
+	assert.Equal(t, expectedGenericOutput, output.String())
+
+	_, err = NewDockerFileDataFromJSONField("./example-input-files/test-input-with-target-key.json", ".services[2]")
+	assert.EqualError(t, err, "Can't decode target val: Index 2 is out of range")
+}
+
+func TestJSONRenderingFail(t *testing.T) {
+	_, err := NewDockerFileDataFromJSONFile("./example-input-files/invalid-input.json")
+	assert.EqualError(t, err, `./example-input-files/invalid-input.json:5:34: Expected ']' in json, found '"'`)
+
+	_, err = NewDockerFileDataFromJSONFile("non-existent.json")
+	assert.EqualError(t, err, "Unmarshal: jsonFile.Get err #open non-existent.json: no such file or directory")
+}
+
+func TestJSONReader(t *testing.T) {
+	data, err := NewDockerFileDataFromJSONReader(strings.NewReader(`{"stages": {"final": [{"from": {"image": "alpine"}}]}}`), "")
+	assert.NoError(t, err)
+
+	output := &bytes.Buffer{}
+	err = NewDockerfileTemplate(data).Render(output)
+	assert.NoError(t, err)
+	assert.Equal(t, "FROM alpine as final\n\n", output.String())
+
+	_, err = NewDockerFileDataFromJSONReader(strings.NewReader(`{"stages": 1`), "")
+	assert.EqualError(t, err, "1:13: Expected '}' in json, found end of input")
+}
+
+func TestJSONParser(t *testing.T) {
+	node, err := newJSONParser([]byte(`{"stages": {"z": [{"arg": {"name": "v", "value": 1.10, "test": true}}], "a": []}, "x": null}`)).parseDocument()
+	assert.NoError(t, err)
+
+	stages, err := getStagesOrderFromYamlNode(node.Content[0])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"z", "a"}, stages)
+
+	stage, errs := decodeStageNode(node.Content[0].Content[1].Content[1])
+	assert.Empty(t, errs)
+	assert.Equal(t, []Instruction{Arg{Name: "v", Value: "1.10", Test: true}}, stage.Instructions)
+
+	for input, expectedError := range map[string]string{
+		`{"a": "b",}`:    `1:11: Expected an object key in json, found '}'`,
+		`{"a": "b`:       `1:9: Unterminated string in json`,
+		`{"a": tru}`:     `1:7: Unexpected 't' in json`,
+		"[1,\n 2] 3":     `2:5: Unexpected '3' after the json value`,
+		`{"a": [1, 2}`:   `1:12: Expected ']' in json, found '}'`,
+		`{"a": 1.2.3}`:   `1:7: Invalid number 1.2.3 in json`,
+		``:               `1:1: Unexpected end of input in json`,
+		`{"a": "é"}`:     ``,
+		`{"é": "b" ü}`:   `1:11: Expected '}' in json, found 'ü'`,
+		"\xef\xbb\xbf{}": `1:1: Unexpected '\ufeff' in json`,
+		"[\xff]":         `1:2: Unexpected byte 0xff in json`,
+	} {
+		_, err := newJSONParser([]byte(input)).parseDocument()
+		if expectedError == "" {
+			assert.NoError(t, err)
+			continue
+		}
+		assert.EqualError(t, err, expectedError, input)
+	}
+
+	_, err = newJSONParser([]byte(`["a", "b\x"]`)).parseDocument()
+	assert.Contains(t, err.Error(), "1:7: Invalid string in json: ")
+}
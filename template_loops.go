@@ -0,0 +1,274 @@
+package dockerfilegenerator
+
+import (
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// forEachKey repeats a map-form stage or an instruction for each value of a list, referenced as ${{ .vars.item }}
+	forEachKey = "forEach"
+
+	// matrixKey repeats a map-form stage or an instruction for every combination of the values of its variables
+	matrixKey = "matrix"
+
+	// forEachVar is the variable that holds the value of the forEach repetition
+	forEachVar = "item"
+)
+
+// resolveStagesVars resolves the variable references of the stages and expands their forEach and matrix keys,
+// a repeated stage is named by resolving the references in its key with the variables of the repetition.
+// The when expressions of the repetitions are evaluated here since the loop variables aren't known afterwards.
+func resolveStagesVars(stagesNode *yaml.Node, vars map[string]string, origins nodeOrigins) ConfigErrors {
+	if stagesNode.Kind != yaml.MappingNode {
+		return resolveVars(stagesNode, vars)
+	}
+
+	var errs ConfigErrors
+	var content []*yaml.Node
+
+	for i := 0; i+1 < len(stagesNode.Content); i += 2 {
+		keyNode, stageNode := stagesNode.Content[i], stagesNode.Content[i+1]
+
+		repetitions, err := takeLoop(stageNode, vars)
+		if err != nil {
+			err.Stage = keyNode.Value
+			errs = append(errs, err)
+			continue
+		}
+
+		if repetitions == nil {
+			errs = append(errs, resolveStageVars(stageNode, keyNode.Value, vars, origins)...)
+			content = append(content, keyNode, stageNode)
+			continue
+		}
+
+		for _, loopVars := range repetitions {
+			name, err := interpolate(keyNode.Value, loopVars)
+			if err != nil {
+				errs = append(errs, newConfigError(keyNode, "%v", err))
+				break
+			}
+
+			clone := origins.clone(stageNode)
+			stageErrs := resolveStageVars(clone, name, loopVars, origins)
+
+			keep := true
+			if len(stageErrs) == 0 {
+				keep, stageErrs = applyStageWhen(clone, loopVars)
+				for _, stageErr := range stageErrs {
+					stageErr.Stage = name
+				}
+			}
+
+			// the same mistake is reported once rather than for every repetition
+			if len(stageErrs) > 0 {
+				errs = append(errs, stageErrs...)
+				break
+			}
+
+			if keep {
+				nameNode := origins.clone(keyNode)
+				nameNode.Value = name
+				content = append(content, nameNode, clone)
+			}
+		}
+	}
+
+	names := map[string]bool{}
+	for i := 0; i < len(content); i += 2 {
+		if names[content[i].Value] {
+			errs = append(errs, newConfigError(content[i], "Duplicate stage name %q, the name of a repeated stage should reference a loop variable, e.g. build-${{ .vars.item }}", content[i].Value))
+		}
+		names[content[i].Value] = true
+	}
+
+	stagesNode.Content = content
+
+	return errs
+}
+
+// resolveStageVars resolves the variable references of a stage and expands the loops of its instructions
+func resolveStageVars(stageNode *yaml.Node, name string, vars map[string]string, origins nodeOrigins) ConfigErrors {
+	if stageNode.Kind == yaml.SequenceNode {
+		return resolveInstructionsVars(stageNode, name, vars, origins)
+	}
+
+	if stageNode.Kind != yaml.MappingNode {
+		return resolveVars(stageNode, vars)
+	}
+
+	var errs ConfigErrors
+	for i := 0; i+1 < len(stageNode.Content); i += 2 {
+		if stageNode.Content[i].Value == "instructions" {
+			errs = append(errs, resolveInstructionsVars(stageNode.Content[i+1], name, vars, origins)...)
+		} else {
+			errs = append(errs, resolveVars(stageNode.Content[i+1], vars)...)
+		}
+	}
+
+	return errs
+}
+
+// resolveInstructionsVars resolves the variable references of the instructions and repeats the ones with a loop key,
+// the loop key is next to the fields of the instruction, e.g. copy: {sources: [...], forEach: [...]}
+func resolveInstructionsVars(instructionsNode *yaml.Node, stage string, vars map[string]string, origins nodeOrigins) ConfigErrors {
+	if instructionsNode.Kind != yaml.SequenceNode {
+		return resolveVars(instructionsNode, vars)
+	}
+
+	var errs ConfigErrors
+	var content []*yaml.Node
+
+	for _, instructionNode := range instructionsNode.Content {
+		if instructionNode.Kind != yaml.MappingNode || len(instructionNode.Content) != 2 || instructionNode.Content[1].Kind != yaml.MappingNode {
+			errs = append(errs, resolveVars(instructionNode, vars)...)
+			content = append(content, instructionNode)
+			continue
+		}
+
+		repetitions, err := takeLoop(instructionNode.Content[1], vars)
+		if err != nil {
+			err.Stage, err.Instruction = stage, len(content)
+			errs = append(errs, err)
+			continue
+		}
+
+		if repetitions == nil {
+			errs = append(errs, resolveVars(instructionNode, vars)...)
+			content = append(content, instructionNode)
+			continue
+		}
+
+		for _, loopVars := range repetitions {
+			clone := origins.clone(instructionNode)
+			if cloneErrs := resolveVars(clone, loopVars); len(cloneErrs) > 0 {
+				errs = append(errs, cloneErrs...)
+				break
+			}
+
+			keep, err := evaluateWhenKey(clone.Content[1], loopVars)
+			if err != nil {
+				err.Stage, err.Instruction = stage, len(content)
+				errs = append(errs, err)
+				break
+			}
+
+			if keep {
+				content = append(content, clone)
+			}
+		}
+	}
+
+	instructionsNode.Content = content
+
+	return errs
+}
+
+// takeLoop removes the forEach or matrix key of the mapping node and returns the variables of each repetition,
+// the given ones extended with the loop variables. It returns nil when the node has no loop key, and an empty slice
+// when a list of values is empty.
+func takeLoop(node *yaml.Node, vars map[string]string) ([]map[string]string, *ConfigError) {
+	if node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+
+	var loopKey, loopNode *yaml.Node
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode := node.Content[i]
+		if keyNode.Value != forEachKey && keyNode.Value != matrixKey {
+			continue
+		}
+
+		if loopKey != nil {
+			return nil, newConfigError(keyNode, "Use either forEach or matrix, not both")
+		}
+		loopKey, loopNode = keyNode, node.Content[i+1]
+	}
+
+	if loopKey == nil {
+		return nil, nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i] == loopKey {
+			node.Content = append(node.Content[:i:i], node.Content[i+2:]...)
+			break
+		}
+	}
+
+	if loopKey.Value == forEachKey {
+		values, err := decodeLoopValues(loopNode, vars, "forEach should be a list of values, e.g. [api, web]")
+		if err != nil {
+			return nil, err
+		}
+
+		repetitions := []map[string]string{}
+		for _, value := range values {
+			repetitions = append(repetitions, withVar(vars, forEachVar, value))
+		}
+
+		return repetitions, nil
+	}
+
+	if loopNode.Kind != yaml.MappingNode || len(loopNode.Content) == 0 {
+		return nil, newConfigError(loopNode, "matrix should be a map of variables to lists of values, e.g. go: [\"1.13\", \"1.14\"]")
+	}
+
+	// the first variable changes the slowest, e.g. go: [a, b], os: [c, d] gives a c, a d, b c, b d
+	repetitions := []map[string]string{vars}
+	for i := 0; i+1 < len(loopNode.Content); i += 2 {
+		keyNode, valuesNode := loopNode.Content[i], loopNode.Content[i+1]
+
+		if err := ValidateVarName(keyNode.Value); err != nil {
+			return nil, newConfigError(keyNode, "%v", err)
+		}
+
+		values, err := decodeLoopValues(valuesNode, vars, "Matrix variable %q should be a list of values", keyNode.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		combinations := []map[string]string{}
+		for _, repetition := range repetitions {
+			for _, value := range values {
+				combinations = append(combinations, withVar(repetition, keyNode.Value, value))
+			}
+		}
+		repetitions = combinations
+	}
+
+	return repetitions, nil
+}
+
+// decodeLoopValues returns the values of a forEach list or a matrix variable, they can reference the variables
+func decodeLoopValues(node *yaml.Node, vars map[string]string, format string, args ...interface{}) ([]string, *ConfigError) {
+	if node.Kind != yaml.SequenceNode {
+		return nil, newConfigError(node, format, args...)
+	}
+
+	var values []string
+	for _, valueNode := range node.Content {
+		if valueNode.Kind != yaml.ScalarNode {
+			return nil, newConfigError(valueNode, format, args...)
+		}
+
+		value, err := interpolate(valueNode.Value, vars)
+		if err != nil {
+			return nil, newConfigError(valueNode, "%v", err)
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// withVar returns a copy of the variables with the given one set, a loop variable overrides the one with its name
+func withVar(vars map[string]string, name, value string) map[string]string {
+	res := make(map[string]string, len(vars)+1)
+	for k, v := range vars {
+		res[k] = v
+	}
+	res[name] = value
+
+	return res
+}
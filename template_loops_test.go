@@ -0,0 +1,127 @@
+package dockerfilegenerator
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestLoops(t *testing.T) {
+	input := `
+vars:
+  registry: ghcr.io/org
+stages:
+  test-${{ .vars.go }}-${{ .vars.os }}:
+    matrix:
+      go: ["1.13", "1.14"]
+      os: [alpine, buster]
+    when: go != "1.13" || os == "alpine"
+    instructions:
+      - from:
+          image: golang:${{ .vars.go }}-${{ .vars.os }}
+  final:
+    - from:
+        image: ${{ .vars.registry }}/base
+    - copy:
+        sources:
+          - services/${{ .vars.item }}
+        destination: /srv/${{ .vars.item }}
+        forEach: [api, web, worker]
+        when: item != "worker"
+    - run:
+        params: ["echo $${{ .vars.item }}"]
+`
+
+	data, err := NewDockerFileDataFromYamlReader(strings.NewReader(input), "")
+	assert.NoError(t, err)
+
+	var names []string
+	for _, stage := range data.Stages {
+		names = append(names, stage.Name)
+	}
+	assert.Equal(t, []string{"test-1.13-alpine", "test-1.14-alpine", "test-1.14-buster", "final"}, names)
+	assert.Equal(t, []Instruction{From{Image: "golang:1.14-buster"}}, data.Stages[2].Instructions)
+	assert.Equal(t, []Instruction{
+		From{Image: "ghcr.io/org/base"},
+		CopyCommand{Sources: []string{"services/api"}, Destination: "/srv/api"},
+		CopyCommand{Sources: []string{"services/web"}, Destination: "/srv/web"},
+		RunCommand{Params: []string{"echo ${{ .vars.item }}"}, RunForm: ShellForm},
+	}, data.Stages[3].Instructions)
+
+	data, err = NewDockerFileDataFromYamlReaderWithOptions(strings.NewReader(`
+stages:
+  final:
+    forEach: ["${{ .vars.version }}"]
+    instructions:
+      - from:
+          image: alpine:${{ .vars.item }}
+      - env:
+          envs:
+            ${{ .vars.name }}: "1"
+          matrix:
+            name: []
+`), "", DecodeOptions{Vars: map[string]string{"version": "3.12"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Stage{{Name: "final", Instructions: []Instruction{From{Image: "alpine:3.12"}}}}, data.Stages)
+}
+
+func TestLoopErrors(t *testing.T) {
+	for input, expected := range map[string]string{
+		`
+stages:
+  build:
+    forEach: [a, b]
+    instructions:
+      - from:
+          image: alpine
+`: `3:3: Duplicate stage name "build", the name of a repeated stage should reference a loop variable, e.g. build-${{ .vars.item }}`,
+		`
+stages:
+  final:
+    - run:
+        params: [echo]
+        forEach: a
+`: `6:18: stages.final[0]: forEach should be a list of values, e.g. [api, web]`,
+		`
+stages:
+  final:
+    - run:
+        params: [echo]
+        forEach: [a]
+        matrix: {b: [c]}
+`: `7:9: stages.final[0]: Use either forEach or matrix, not both`,
+		`
+stages:
+  build-${{ .vars.os }}:
+    matrix:
+      os: [{a: b}]
+    instructions: []
+`: `5:12: stages.build-${{ .vars.os }}: Matrix variable "os" should be a list of values`,
+		`
+stages:
+  build-${{ .vars.item }}:
+    matrix: []
+    instructions: []
+`: `4:13: stages.build-${{ .vars.item }}: matrix should be a map of variables to lists of values, e.g. go: ["1.13", "1.14"]`,
+		`
+stages:
+  final:
+    - run:
+        params: ["echo ${{ .vars.name }}"]
+        forEach: [a, b]
+`: `5:18: Undefined variable "name", defined variables are item`,
+		`
+stages:
+  final:
+    - from:
+        image: alpine
+    - run:
+        params: [echo]
+        forEach: [a, b]
+        when: item
+`: `9:15: stages.final[1]: Invalid when expression "item": "a" isn't a boolean, compare it with == or !=`,
+	} {
+		_, err := NewDockerFileDataFromYamlReader(strings.NewReader(input), "")
+		assert.EqualError(t, err, expected, input)
+	}
+}
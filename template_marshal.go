@@ -0,0 +1,527 @@
+package dockerfilegenerator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The Marshal* methods below encode DockerfileData using the same keys the yaml decoder accepts, so the output can be
+// used as an input file. MarshalYAML methods return a *yaml.Node to keep the order of the keys, MarshalJSON methods
+// convert that node to json.
+
+func newScalarNode(value string) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+
+	// go-yaml picks the literal style for multi-line strings, which it can't always indent correctly
+	if strings.Contains(value, "\n") {
+		node.Style = yaml.DoubleQuotedStyle
+	}
+
+	return node
+}
+
+func newSequenceNode(values []string) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	for _, value := range values {
+		node.Content = append(node.Content, newScalarNode(escapeVars(value)))
+	}
+
+	return node
+}
+
+// newMappingNode expects key, value pairs; values are either string, bool or *yaml.Node, empty strings and false are omitted.
+// String values are escaped so that they aren't read as variable references.
+func newMappingNode(pairs ...interface{}) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+
+	for i := 0; i+1 < len(pairs); i += 2 {
+		var value *yaml.Node
+
+		switch v := pairs[i+1].(type) {
+		case string:
+			if v == "" {
+				continue
+			}
+			value = newScalarNode(escapeVars(v))
+		case bool:
+			if !v {
+				continue
+			}
+			value = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"}
+		case *yaml.Node:
+			value = v
+		}
+
+		node.Content = append(node.Content, newScalarNode(pairs[i].(string)), value)
+	}
+
+	return node
+}
+
+// runFormValue returns the runForm value the decoder accepts, an empty RunForm is omitted to keep the instruction's default
+func runFormValue(runForm RunForm) string {
+	switch runForm {
+	case ExecForm:
+		return "exec"
+	case ShellForm:
+		return "shell"
+	}
+
+	return ""
+}
+
+// durationValue returns the duration in the form the decoder parses, empty for a zero duration
+func durationValue(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+
+	return formatDuration(d)
+}
+
+// marshalNode returns the *yaml.Node of any value, the values that implement yaml.Marshaler are asked first
+func marshalNode(value interface{}) (*yaml.Node, error) {
+	if marshaler, ok := value.(yaml.Marshaler); ok {
+		v, err := marshaler.MarshalYAML()
+		if err != nil {
+			return nil, err
+		}
+
+		if node, ok := v.(*yaml.Node); ok {
+			return node, nil
+		}
+
+		value = v
+	}
+
+	out, err := yaml.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal(out, &node); err != nil {
+		return nil, err
+	}
+
+	return node.Content[0], nil
+}
+
+// writeJSONNode writes the node as json, mapping keys keep their order
+func writeJSONNode(buf *bytes.Buffer, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		return writeJSONNode(buf, node.Content[0])
+	case yaml.AliasNode:
+		return writeJSONNode(buf, node.Alias)
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, item := range node.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSONNode(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case yaml.MappingNode:
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSONNode(buf, newScalarNode(node.Content[i].Value)); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := writeJSONNode(buf, node.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case yaml.ScalarNode:
+		switch node.ShortTag() {
+		case "!!bool", "!!int", "!!float":
+			buf.WriteString(node.Value)
+		case "!!null":
+			buf.WriteString("null")
+		default:
+			value, err := json.Marshal(node.Value)
+			if err != nil {
+				return err
+			}
+			buf.Write(value)
+		}
+	default:
+		return fmt.Errorf("Can't convert yaml node kind %v to json", node.Kind)
+	}
+
+	return nil
+}
+
+func marshalJSON(value interface{}) ([]byte, error) {
+	node, err := marshalNode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := writeJSONNode(buf, node); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// stageName returns the name of the stage, its AS alias or stage<index> when it has neither. A generated name gets a
+// suffix while another stage has it as its name or alias, e.g. stage1-2, so the stages keys stay unique.
+func (d *DockerfileData) stageName(index int) string {
+	stage := d.Stages[index]
+	if stage.Name != "" {
+		return stage.Name
+	}
+
+	if alias := stage.Alias(); alias != "" {
+		return alias
+	}
+
+	name := fmt.Sprintf("stage%d", index)
+	for suffix := 2; d.stageIndex(name) >= 0; suffix++ {
+		name = fmt.Sprintf("stage%d-%d", index, suffix)
+	}
+
+	return name
+}
+
+// MarshalYAML encodes the data in the form of stages: {<name>: <stage>}, see stageName for the names
+func (d DockerfileData) MarshalYAML() (interface{}, error) {
+	stages := newMappingNode()
+
+	for i, stage := range d.Stages {
+		node, err := marshalNode(stage)
+		if err != nil {
+			return nil, err
+		}
+
+		stages.Content = append(stages.Content, newScalarNode(d.stageName(i)), node)
+	}
+
+	var directives interface{} = ""
+	if d.Directives != (Directives{}) {
+		directives = newMappingNode("syntax", d.Directives.Syntax, "escape", d.Directives.Escape, "check", d.Directives.Check)
+	}
+
+	var platforms interface{} = ""
+	if len(d.Platforms) > 0 {
+		platforms = newSequenceNode(d.Platforms)
+	}
+
+	return newMappingNode(
+		"directives", directives, "scriptForm", string(d.ScriptForm), "platforms", platforms, "stages", stages,
+	), nil
+}
+
+// MarshalJSON encodes the data with the same keys as MarshalYAML
+func (d DockerfileData) MarshalJSON() ([]byte, error) {
+	return marshalJSON(d)
+}
+
+// MarshalYAML encodes the stage as a sequence of instructions, or as a map with an instructions key when the stage
+// has a platform, dependencies or a comment. Every instruction has to implement yaml.Marshaler. The name is the key of the stage.
+func (s Stage) MarshalYAML() (interface{}, error) {
+	node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+
+	for _, instruction := range s.Instructions {
+		if _, ok := instruction.(yaml.Marshaler); !ok {
+			return nil, fmt.Errorf("Can't marshal instruction type %T, it doesn't implement yaml.Marshaler", instruction)
+		}
+
+		instructionNode, err := marshalNode(instruction)
+		if err != nil {
+			return nil, err
+		}
+
+		node.Content = append(node.Content, instructionNode)
+	}
+
+	if s.Platform == "" && len(s.DependsOn) == 0 && s.Comment == "" {
+		return node, nil
+	}
+
+	var dependsOn interface{} = ""
+	if len(s.DependsOn) > 0 {
+		dependsOn = newSequenceNode(s.DependsOn)
+	}
+
+	return newMappingNode("platform", s.Platform, "dependsOn", dependsOn, "comment", s.Comment, "instructions", node), nil
+}
+
+// MarshalJSON encodes the stage with the same keys as MarshalYAML
+func (s Stage) MarshalJSON() ([]byte, error) {
+	return marshalJSON(s)
+}
+
+// MarshalYAML encodes the instruction under the arg key
+func (a Arg) MarshalYAML() (interface{}, error) {
+	return newMappingNode("arg", newMappingNode(
+		"name", a.Name, "value", a.Value, "literal", a.Literal, "test", a.Test, "envVariable", a.EnvVariable, "comment", a.Comment,
+	)), nil
+}
+
+// MarshalJSON encodes the instruction with the same keys as MarshalYAML
+func (a Arg) MarshalJSON() ([]byte, error) {
+	return marshalJSON(a)
+}
+
+// MarshalYAML encodes the instruction under the from key
+func (f From) MarshalYAML() (interface{}, error) {
+	return newMappingNode("from", newMappingNode("image", f.Image, "as", f.As, "platform", f.Platform, "comment", f.Comment)), nil
+}
+
+// MarshalJSON encodes the instruction with the same keys as MarshalYAML
+func (f From) MarshalJSON() ([]byte, error) {
+	return marshalJSON(f)
+}
+
+// MarshalYAML encodes the instruction under the label key
+func (l Label) MarshalYAML() (interface{}, error) {
+	return newMappingNode("label", newMappingNode("name", l.Name, "value", l.Value, "literal", l.Literal, "comment", l.Comment)), nil
+}
+
+// MarshalJSON encodes the instruction with the same keys as MarshalYAML
+func (l Label) MarshalJSON() ([]byte, error) {
+	return marshalJSON(l)
+}
+
+// MarshalYAML encodes the instruction under the volume key
+func (v Volume) MarshalYAML() (interface{}, error) {
+	return newMappingNode("volume", newMappingNode("source", v.Source, "destination", v.Destination, "comment", v.Comment)), nil
+}
+
+// MarshalJSON encodes the instruction with the same keys as MarshalYAML
+func (v Volume) MarshalJSON() ([]byte, error) {
+	return marshalJSON(v)
+}
+
+// MarshalYAML encodes the instruction under the run key
+func (r RunCommand) MarshalYAML() (interface{}, error) {
+	var mounts interface{} = ""
+	if len(r.Mounts) > 0 {
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, mount := range r.Mounts {
+			node.Content = append(node.Content, newMappingNode(
+				"type", string(mount.Type), "id", mount.ID, "target", mount.Target, "source", mount.Source,
+				"from", mount.From, "sharing", mount.Sharing, "mode", mount.Mode, "uid", mount.UID, "gid", mount.GID,
+				"size", mount.Size, "rw", mount.ReadWrite, "required", mount.Required,
+			))
+		}
+		mounts = node
+	}
+
+	// params are left out of scripts, the decoder doesn't require them then
+	var params interface{} = newSequenceNode(r.Params)
+	if r.Script != "" && len(r.Params) == 0 {
+		params = ""
+	}
+
+	return newMappingNode("run", newMappingNode(
+		"runForm", runFormValue(r.RunForm), "params", params, "mounts", mounts,
+		"network", r.Network, "security", r.Security,
+		"script", r.Script, "delimiter", r.Delimiter, "scriptForm", string(r.ScriptForm), "comment", r.Comment,
+	)), nil
+}
+
+// MarshalJSON encodes the instruction with the same keys as MarshalYAML
+func (r RunCommand) MarshalJSON() ([]byte, error) {
+	return marshalJSON(r)
+}
+
+// MarshalYAML encodes the instruction under the envVariable key
+func (e EnvVariable) MarshalYAML() (interface{}, error) {
+	return newMappingNode("envVariable", newMappingNode("name", e.Name, "value", e.Value, "literal", e.Literal, "comment", e.Comment)), nil
+}
+
+// MarshalJSON encodes the instruction with the same keys as MarshalYAML
+func (e EnvVariable) MarshalJSON() ([]byte, error) {
+	return marshalJSON(e)
+}
+
+// MarshalYAML encodes the instruction under the copy key
+func (c CopyCommand) MarshalYAML() (interface{}, error) {
+	var sources interface{} = newSequenceNode(c.Sources)
+	if c.Content != "" && len(c.Sources) == 0 {
+		sources = ""
+	}
+
+	var exclude interface{} = ""
+	if len(c.Exclude) > 0 {
+		exclude = newSequenceNode(c.Exclude)
+	}
+
+	return newMappingNode("copy", newMappingNode(
+		"sources", sources, "destination", c.Destination, "chown", c.Chown, "chmod", c.Chmod, "from", c.From,
+		"link", c.Link, "parents", c.Parents, "exclude", exclude, "content", c.Content, "delimiter", c.Delimiter, "scriptForm", string(c.ScriptForm), "comment", c.Comment,
+	)), nil
+}
+
+// MarshalJSON encodes the instruction with the same keys as MarshalYAML
+func (c CopyCommand) MarshalJSON() ([]byte, error) {
+	return marshalJSON(c)
+}
+
+// MarshalYAML encodes the instruction under the add key
+func (a Add) MarshalYAML() (interface{}, error) {
+	return newMappingNode("add", newMappingNode(
+		"sources", newSequenceNode(a.Sources), "destination", a.Destination, "chown", a.Chown, "chmod", a.Chmod,
+		"checksum", a.Checksum, "keepGitDir", a.KeepGitDir, "link", a.Link, "comment", a.Comment,
+	)), nil
+}
+
+// MarshalJSON encodes the instruction with the same keys as MarshalYAML
+func (a Add) MarshalJSON() ([]byte, error) {
+	return marshalJSON(a)
+}
+
+// MarshalYAML encodes the instruction under the cmd key
+func (c Cmd) MarshalYAML() (interface{}, error) {
+	return newMappingNode("cmd", newMappingNode(
+		"runForm", runFormValue(c.RunForm), "params", newSequenceNode(c.Params), "comment", c.Comment,
+	)), nil
+}
+
+// MarshalJSON encodes the instruction with the same keys as MarshalYAML
+func (c Cmd) MarshalJSON() ([]byte, error) {
+	return marshalJSON(c)
+}
+
+// MarshalYAML encodes the instruction under the entrypoint key
+func (e Entrypoint) MarshalYAML() (interface{}, error) {
+	return newMappingNode("entrypoint", newMappingNode(
+		"runForm", runFormValue(e.RunForm), "params", newSequenceNode(e.Params), "comment", e.Comment,
+	)), nil
+}
+
+// MarshalJSON encodes the instruction with the same keys as MarshalYAML
+func (e Entrypoint) MarshalJSON() ([]byte, error) {
+	return marshalJSON(e)
+}
+
+// MarshalYAML encodes the instruction under the onbuild key
+func (o Onbuild) MarshalYAML() (interface{}, error) {
+	if o.Instruction == nil {
+		return newMappingNode("onbuild", newMappingNode("params", newSequenceNode(o.Params), "comment", o.Comment)), nil
+	}
+
+	node, err := marshalNode(o.Instruction)
+	if err != nil {
+		return nil, err
+	}
+
+	// the trigger is encoded under its own key next to the comment, e.g. onbuild: {copy: {...}}
+	if node.Kind != yaml.MappingNode || len(node.Content) != 2 {
+		return nil, fmt.Errorf("Can't marshal ONBUILD trigger type %T, it isn't encoded under a single key", o.Instruction)
+	}
+
+	return newMappingNode("onbuild", newMappingNode(node.Content[0].Value, node.Content[1], "comment", o.Comment)), nil
+}
+
+// MarshalJSON encodes the instruction with the same keys as MarshalYAML
+func (o Onbuild) MarshalJSON() ([]byte, error) {
+	return marshalJSON(o)
+}
+
+// MarshalYAML encodes the instruction under the healthCheck key
+func (h HealthCheck) MarshalYAML() (interface{}, error) {
+	// params are left out of a disabled health check, the decoder doesn't require them then
+	var params interface{} = newSequenceNode(h.Params)
+	if h.None && len(h.Params) == 0 {
+		params = ""
+	}
+
+	var retries string
+	if h.Retries != 0 {
+		retries = strconv.Itoa(h.Retries)
+	}
+
+	return newMappingNode("healthCheck", newMappingNode(
+		"runForm", runFormValue(h.RunForm), "params", params,
+		"interval", durationValue(h.Interval), "timeout", durationValue(h.Timeout),
+		"startPeriod", durationValue(h.StartPeriod), "startInterval", durationValue(h.StartInterval),
+		"retries", retries, "none", h.None, "comment", h.Comment,
+	)), nil
+}
+
+// MarshalJSON encodes the instruction with the same keys as MarshalYAML
+func (h HealthCheck) MarshalJSON() ([]byte, error) {
+	return marshalJSON(h)
+}
+
+// MarshalYAML encodes the instruction under the shell key
+func (s Shell) MarshalYAML() (interface{}, error) {
+	return newMappingNode("shell", newMappingNode("params", newSequenceNode(s.Params), "comment", s.Comment)), nil
+}
+
+// MarshalJSON encodes the instruction with the same keys as MarshalYAML
+func (s Shell) MarshalJSON() ([]byte, error) {
+	return marshalJSON(s)
+}
+
+// MarshalYAML encodes the instruction under the workdir key
+func (w Workdir) MarshalYAML() (interface{}, error) {
+	return newMappingNode("workdir", newMappingNode("dir", w.Dir, "comment", w.Comment)), nil
+}
+
+// MarshalJSON encodes the instruction with the same keys as MarshalYAML
+func (w Workdir) MarshalJSON() ([]byte, error) {
+	return marshalJSON(w)
+}
+
+// MarshalYAML encodes the instruction in the short form `user: <user>` unless a group or a comment is given
+func (u User) MarshalYAML() (interface{}, error) {
+	if u.User != "" && u.Group == "" && u.Comment == "" {
+		return newMappingNode("user", u.User), nil
+	}
+
+	return newMappingNode("user", newMappingNode("user", u.User, "group", u.Group, "comment", u.Comment)), nil
+}
+
+// MarshalYAML encodes the instruction in the form of `comment: <text>`
+func (c Comment) MarshalYAML() (interface{}, error) {
+	return newMappingNode("comment", c.Text), nil
+}
+
+// MarshalJSON encodes the instruction with the same keys as MarshalYAML
+func (c Comment) MarshalJSON() ([]byte, error) {
+	return marshalJSON(c)
+}
+
+// MarshalJSON encodes the instruction with the same keys as MarshalYAML
+func (u User) MarshalJSON() ([]byte, error) {
+	return marshalJSON(u)
+}
+
+// MarshalYAML encodes the instruction under the expose key
+func (e Expose) MarshalYAML() (interface{}, error) {
+	return newMappingNode("expose", newMappingNode("ports", newSequenceNode(e.Ports), "comment", e.Comment)), nil
+}
+
+// MarshalJSON encodes the instruction with the same keys as MarshalYAML
+func (e Expose) MarshalJSON() ([]byte, error) {
+	return marshalJSON(e)
+}
+
+// MarshalYAML encodes the instruction under the stopSignal key
+func (s StopSignal) MarshalYAML() (interface{}, error) {
+	return newMappingNode("stopSignal", newMappingNode("signal", s.Signal, "comment", s.Comment)), nil
+}
+
+// MarshalJSON encodes the instruction with the same keys as MarshalYAML
+func (s StopSignal) MarshalJSON() ([]byte, error) {
+	return marshalJSON(s)
+}
@@ -0,0 +1,319 @@
+package dockerfilegenerator
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+const randomStringChars = "abcXYZ019 -_./:=\"'#&*!|>%@$`{}[],?~\\\n\tç"
+
+func randomString(r *rand.Rand) string {
+	res := make([]rune, r.Intn(12))
+	chars := []rune(randomStringChars)
+	for i := range res {
+		res[i] = chars[r.Intn(len(chars))]
+	}
+
+	// values the yaml resolver would otherwise treat as a different type, and ones that look like variable references
+	specials := []string{"true", "no", "1.10", "0x1F", "null", "~", "", " leading", "trailing ", "${{ .vars.a }}", "$${{ x }}"}
+	if r.Intn(5) == 0 {
+		return specials[r.Intn(len(specials))]
+	}
+
+	return string(res)
+}
+
+func randomStrings(r *rand.Rand) []string {
+	res := make([]string, r.Intn(4)+1)
+	for i := range res {
+		res[i] = randomString(r)
+	}
+
+	return res
+}
+
+func randomRunForm(r *rand.Rand) RunForm {
+	if r.Intn(2) == 0 {
+		return ExecForm
+	}
+
+	return ShellForm
+}
+
+func randomScriptForm(r *rand.Rand) ScriptForm {
+	return []ScriptForm{"", HeredocScriptForm, ChainedScriptForm}[r.Intn(3)]
+}
+
+func randomInstruction(r *rand.Rand) Instruction {
+	switch r.Intn(18) {
+	case 0:
+		return Arg{Name: randomString(r), Value: randomString(r), Literal: r.Intn(2) == 0, Test: r.Intn(2) == 0, EnvVariable: r.Intn(2) == 0}
+	case 1:
+		return From{Image: randomString(r), As: randomString(r)}
+	case 2:
+		return Label{Name: randomString(r), Value: randomString(r), Literal: r.Intn(2) == 0}
+	case 3:
+		return Volume{Source: randomString(r), Destination: randomString(r)}
+	case 4:
+		run := RunCommand{Params: randomStrings(r), RunForm: randomRunForm(r)}
+		// mounts, network and security are validated while decoding
+		if r.Intn(2) == 0 {
+			run.Mounts = []Mount{
+				{Type: CacheMount, ID: randomString(r), Target: "/" + randomString(r), Sharing: "locked", Mode: "0755", UID: "1000"},
+				{Type: SecretMount, ID: randomString(r) + "id", Required: r.Intn(2) == 0},
+				{Type: BindMount, Target: "/src", From: randomString(r), ReadWrite: r.Intn(2) == 0},
+			}[:r.Intn(3)+1]
+			run.Network = []string{"", "none", "host", "default"}[r.Intn(4)]
+			run.Security = []string{"", "insecure", "sandbox"}[r.Intn(3)]
+		}
+		if r.Intn(3) == 0 {
+			run.Params = nil
+			run.Script = "echo " + randomString(r) + "\n" + randomString(r)
+			run.Delimiter = []string{"", "SCRIPT"}[r.Intn(2)]
+			run.ScriptForm = randomScriptForm(r)
+		}
+		return run
+	case 5:
+		return EnvVariable{Name: randomString(r), Value: randomString(r), Literal: r.Intn(2) == 0}
+	case 6:
+		if r.Intn(3) == 0 {
+			return CopyCommand{
+				Destination: "/" + randomString(r), Chown: randomString(r), Content: randomString(r) + "\n" + randomString(r),
+				Delimiter: []string{"", "CONTENT"}[r.Intn(2)], ScriptForm: randomScriptForm(r),
+			}
+		}
+		var exclude []string
+		for i := r.Intn(3); i > 0; i-- {
+			exclude = append(exclude, []string{"*.md", "**/testdata", "docs/", "[abc]?.go"}[r.Intn(4)])
+		}
+		return CopyCommand{
+			Sources: randomStrings(r), Destination: randomString(r), Chown: randomString(r), Chmod: []string{"", "644", "0755"}[r.Intn(3)],
+			From: randomString(r), Link: r.Intn(2) == 0, Parents: r.Intn(2) == 0, Exclude: exclude,
+		}
+	case 7:
+		return Cmd{Params: randomStrings(r), RunForm: randomRunForm(r)}
+	case 8:
+		return Entrypoint{Params: randomStrings(r), RunForm: randomRunForm(r)}
+	case 9:
+		// the trigger is validated while decoding, the comment of a wrapped instruction isn't decoded
+		if r.Intn(2) == 0 {
+			return Onbuild{Params: append([]string{"echo"}, randomStrings(r)...)}
+		}
+		for {
+			onbuild := Onbuild{Instruction: setInstructionComment(randomInstruction(r), "")}
+			if onbuild.validateTrigger() == nil {
+				return onbuild
+			}
+		}
+	case 10:
+		// options are validated while decoding, a leading CMD or --option would be read as the legacy params form
+		if r.Intn(4) == 0 {
+			return HealthCheck{None: true, RunForm: randomRunForm(r)}
+		}
+		durations := []time.Duration{0, time.Millisecond, 30 * time.Second, 90 * time.Second, time.Hour}
+		return HealthCheck{
+			Params: append([]string{"curl"}, randomStrings(r)...), RunForm: randomRunForm(r),
+			Interval: durations[r.Intn(5)], Timeout: durations[r.Intn(5)], StartPeriod: durations[r.Intn(5)],
+			StartInterval: durations[r.Intn(5)], Retries: r.Intn(4),
+		}
+	case 11:
+		return Shell{Params: randomStrings(r)}
+	case 12:
+		return Workdir{Dir: randomString(r)}
+	case 13:
+		// the mode and the checksum are validated while decoding
+		add := Add{
+			Sources: randomStrings(r), Destination: randomString(r), Chown: randomString(r),
+			KeepGitDir: r.Intn(2) == 0, Link: r.Intn(2) == 0,
+		}
+		if r.Intn(2) == 0 {
+			add.Chmod = fmt.Sprintf("%o", 0400+r.Intn(0400))
+			add.Checksum = fmt.Sprintf("sha256:%064x", r.Uint64())
+		}
+		return add
+	case 14:
+		// the ports and the signal are validated while decoding
+		ports := []string{"80", "53/udp", "8000-8010/tcp", "${PORT}", "65535"}
+		return Expose{Ports: ports[:r.Intn(len(ports))+1]}
+	case 15:
+		signals := []string{"SIGTERM", "KILL", "9", "SIGRTMIN+3", "$STOP_SIGNAL"}
+		return StopSignal{Signal: signals[r.Intn(len(signals))]}
+	case 16:
+		// an empty comment has nothing to decode
+		return Comment{Text: "x" + randomString(r)}
+	}
+
+	return User{User: randomString(r), Group: randomString(r)}
+}
+
+func randomDockerfileData(r *rand.Rand) *DockerfileData {
+	data := &DockerfileData{ScriptForm: randomScriptForm(r)}
+	if r.Intn(3) == 0 {
+		// directives are validated while decoding
+		data.Directives = Directives{
+			Syntax: []string{"", "docker/dockerfile:1", "docker/dockerfile:1.4-labs"}[r.Intn(3)],
+			Escape: []string{"", "\\", "`"}[r.Intn(3)],
+			Check:  []string{"", "skip=all", "skip=JSONArgsRecommended;error=true"}[r.Intn(3)],
+		}
+	}
+	if r.Intn(3) == 0 {
+		// platforms are validated while decoding
+		data.Platforms = []string{"linux/amd64", "linux/arm64", "linux/arm/v7"}[:r.Intn(3)+1]
+	}
+
+	for i := r.Intn(3) + 1; i > 0; i-- {
+		// the name is the stage map key, it has to be unique
+		stage := Stage{
+			Name:         randomStringChars[:len(data.Stages)+1],
+			Instructions: []Instruction{From{Image: randomString(r), As: randomString(r), Platform: randomString(r)}},
+		}
+		if r.Intn(3) == 0 {
+			stage.Platform = randomString(r)
+			stage.DependsOn = randomStrings(r)
+			stage.Comment = randomString(r)
+		}
+		for j := r.Intn(8); j > 0; j-- {
+			instruction := randomInstruction(r)
+			if r.Intn(4) == 0 {
+				instruction = setInstructionComment(instruction, randomString(r))
+			}
+			stage.Instructions = append(stage.Instructions, instruction)
+		}
+		data.Stages = append(data.Stages, stage)
+	}
+
+	return data
+}
+
+func decodeYamlForTest(t *testing.T, in []byte) *DockerfileData {
+	node := yaml.Node{}
+	err := yaml.Unmarshal(in, &node)
+	assert.NoError(t, err)
+
+	data, err := newDockerFileDataFromYamlNode(&node, "", DecodeOptions{})
+	assert.NoError(t, err)
+
+	return data
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 500; i++ {
+		data := randomDockerfileData(r)
+
+		out, err := yaml.Marshal(data)
+		assert.NoError(t, err)
+		assert.Equal(t, data, decodeYamlForTest(t, out), string(out))
+
+		// json is a subset of yaml, so the yaml decoder can read the json output too
+		out, err = json.Marshal(data)
+		assert.NoError(t, err)
+		assert.Equal(t, data, decodeYamlForTest(t, out), string(out))
+	}
+}
+
+func TestMarshalYAML(t *testing.T) {
+	data := &DockerfileData{
+		Stages: []Stage{
+			{Instructions: []Instruction{
+				From{Image: "golang:1.13", As: "builder"},
+				Arg{Name: "version", Value: "1.10", Test: true},
+				RunCommand{Params: []string{"go", "build"}},
+				User{User: "ozan"},
+			}},
+			{Instructions: []Instruction{
+				From{Image: "alpine:latest"},
+				CopyCommand{From: "builder", Sources: []string{"/app"}, Destination: "."},
+				Cmd{Params: []string{"./app"}, RunForm: ExecForm},
+			}},
+			{Name: "final", Platform: "linux/arm64", DependsOn: []string{"builder"}, Instructions: []Instruction{
+				From{Image: "scratch"},
+			}},
+		},
+	}
+
+	out, err := yaml.Marshal(data)
+	assert.NoError(t, err)
+	assert.Equal(t, `stages:
+    builder:
+      - from:
+            image: golang:1.13
+            as: builder
+      - arg:
+            name: version
+            value: "1.10"
+            test: true
+      - run:
+            params:
+              - go
+              - build
+      - user: ozan
+    stage1:
+      - from:
+            image: alpine:latest
+      - copy:
+            sources:
+              - /app
+            destination: .
+            from: builder
+      - cmd:
+            runForm: exec
+            params:
+              - ./app
+    final:
+        platform: linux/arm64
+        dependsOn:
+          - builder
+        instructions:
+          - from:
+                image: scratch
+`, string(out))
+
+	out, err = json.Marshal(data)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"stages":{"builder":[{"from":{"image":"golang:1.13","as":"builder"}},{"arg":{"name":"version","value":"1.10","test":true}},{"run":{"params":["go","build"]}},{"user":"ozan"}],"stage1":[{"from":{"image":"alpine:latest"}},{"copy":{"sources":["/app"],"destination":".","from":"builder"}},{"cmd":{"runForm":"exec","params":["./app"]}}],"final":{"platform":"linux/arm64","dependsOn":["builder"],"instructions":[{"from":{"image":"scratch"}}]}}}`, string(out))
+}
+
+func TestMarshalYAMLUniqueStageNames(t *testing.T) {
+	data := DockerfileData{Stages: []Stage{
+		{Instructions: []Instruction{From{Image: "alpine"}}},
+		{Instructions: []Instruction{From{Image: "alpine"}}},
+		{Instructions: []Instruction{From{Image: "alpine", As: "stage1"}}},
+		{Name: "stage1-2", Instructions: []Instruction{From{Image: "alpine"}}},
+	}}
+
+	out, err := yaml.Marshal(data)
+	assert.NoError(t, err)
+	assert.Equal(t, `stages:
+    stage0:
+      - from:
+            image: alpine
+    stage1-3:
+      - from:
+            image: alpine
+    stage1:
+      - from:
+            image: alpine
+            as: stage1
+    stage1-2:
+      - from:
+            image: alpine
+`, string(out))
+}
+
+type customInstruction struct{}
+
+func (c customInstruction) Render() string {
+	return "CUSTOM"
+}
+
+func TestMarshalCustomInstruction(t *testing.T) {
+	_, err := yaml.Marshal(Stage{Instructions: []Instruction{customInstruction{}}})
+	assert.EqualError(t, err, "Can't marshal instruction type dockerfilegenerator.customInstruction, it doesn't implement yaml.Marshaler")
+}
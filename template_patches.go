@@ -0,0 +1,206 @@
+package dockerfilegenerator
+
+import (
+	"gopkg.in/yaml.v3"
+)
+
+// patchOperations are the keys of a patch that change the instructions of its stage,
+// append takes the instructions and the others match an instruction, e.g. remove: {envVariable: {name: DEBUG}}
+var patchOperations = []string{"insertBefore", "insertAfter", "replace", "remove", "append"}
+
+// applyPatches applies the patches key of the node to its stages in order and removes it, e.g. for an overlay
+// that changes a line or two of the config it extends. The snippets used by the stages are expanded first so that
+// the patches can match their instructions, the loops and the when expressions are resolved afterwards.
+func applyPatches(node *yaml.Node, origins nodeOrigins) ConfigErrors {
+	patchesNode := takeMappingValueNode(node, "patches")
+	if patchesNode == nil {
+		return nil
+	}
+
+	if patchesNode.Kind != yaml.SequenceNode {
+		return ConfigErrors{newConfigError(patchesNode, "Patches should be a list of patches, e.g. [{stage: final, remove: cmd}]")}
+	}
+
+	// the snippets key is kept, the instructions added by the patches and the configs extending the node can use them
+	if errs := useSnippets(node, getMappingValueNode(node, "snippets"), origins); len(errs) > 0 {
+		return errs
+	}
+
+	var errs ConfigErrors
+	for _, patchNode := range patchesNode.Content {
+		if err := applyPatch(node, patchNode); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// applyPatch changes the instructions of the stage of the patch
+func applyPatch(node, patchNode *yaml.Node) *ConfigError {
+	if patchNode.Kind != yaml.MappingNode {
+		return newConfigError(patchNode, "A patch should be a map with a stage and an operation, e.g. {stage: final, remove: cmd}")
+	}
+
+	var stageNode, instructionsNode, operationKey, operationNode *yaml.Node
+	for i := 0; i+1 < len(patchNode.Content); i += 2 {
+		keyNode, valueNode := patchNode.Content[i], patchNode.Content[i+1]
+
+		switch {
+		case keyNode.Value == "stage":
+			stageNode = valueNode
+		case keyNode.Value == "instructions":
+			instructionsNode = valueNode
+		case containsString(patchOperations, keyNode.Value):
+			if operationKey != nil {
+				return newConfigError(keyNode, "A patch should have a single operation, found %s and %s", operationKey.Value, keyNode.Value)
+			}
+			operationKey, operationNode = keyNode, valueNode
+		default:
+			return newConfigError(keyNode, "Unknown patch key %q, expected stage, instructions, insertBefore, insertAfter, replace, remove or append", keyNode.Value)
+		}
+	}
+
+	if stageNode == nil || stageNode.Kind != yaml.ScalarNode {
+		return newConfigError(patchNode, "A patch should have the name of its stage, e.g. stage: final")
+	}
+	if operationKey == nil {
+		return newConfigError(patchNode, "A patch should have one of insertBefore, insertAfter, replace, remove or append")
+	}
+
+	stageInstructionsNode := getStageInstructionsNode(node, stageNode.Value)
+	if stageInstructionsNode == nil {
+		return newConfigError(stageNode, "Patch refers to stage %q, which isn't defined", stageNode.Value)
+	}
+
+	operation := operationKey.Value
+	switch {
+	case operation == "append":
+		if operationNode.Kind != yaml.SequenceNode {
+			return newConfigError(operationNode, "append should be a sequence of instructions")
+		}
+		if instructionsNode != nil {
+			return newConfigError(instructionsNode, "append takes the instructions as its value")
+		}
+		stageInstructionsNode.Content = append(stageInstructionsNode.Content, operationNode.Content...)
+		return nil
+	case operation == "remove" && instructionsNode != nil:
+		return newConfigError(instructionsNode, "remove doesn't take instructions")
+	case operation != "remove" && (instructionsNode == nil || instructionsNode.Kind != yaml.SequenceNode):
+		return newConfigError(operationKey, "%s requires a sequence of instructions under the instructions key", operation)
+	}
+
+	pattern := operationNode
+	if pattern.Kind == yaml.ScalarNode {
+		// the type alone matches any instruction of the type, e.g. remove: cmd
+		pattern = &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{operationNode, {Kind: yaml.MappingNode}}}
+	}
+	if pattern.Kind != yaml.MappingNode || len(pattern.Content) != 2 {
+		return newConfigError(operationNode, "%s should match an instruction by its type and fields, e.g. {copy: {destination: /app}}", operation)
+	}
+
+	index := -1
+	for i, instructionNode := range stageInstructionsNode.Content {
+		if !matchNode(pattern, instructionNode) {
+			continue
+		}
+
+		if index >= 0 {
+			return newConfigError(operationNode, "%s matches several instructions of stage %q, add fields to match one of them", operation, stageNode.Value)
+		}
+		index = i
+	}
+
+	if index < 0 && hasLoop(stageInstructionsNode) {
+		return newConfigError(operationNode, "%s matches no instruction of stage %q, patches apply before the forEach and matrix loops are expanded", operation, stageNode.Value)
+	}
+	if index < 0 {
+		return newConfigError(operationNode, "%s matches no instruction of stage %q", operation, stageNode.Value)
+	}
+
+	var replacement []*yaml.Node
+	switch operation {
+	case "insertBefore":
+		replacement = append(append(replacement, instructionsNode.Content...), stageInstructionsNode.Content[index])
+	case "insertAfter":
+		replacement = append(append(replacement, stageInstructionsNode.Content[index]), instructionsNode.Content...)
+	case "replace":
+		replacement = instructionsNode.Content
+	}
+
+	content := append([]*yaml.Node{}, stageInstructionsNode.Content[:index]...)
+	content = append(content, replacement...)
+	stageInstructionsNode.Content = append(content, stageInstructionsNode.Content[index+1:]...)
+
+	return nil
+}
+
+// hasLoop reports whether an instruction is repeated by a forEach or a matrix key
+func hasLoop(instructionsNode *yaml.Node) bool {
+	for _, instructionNode := range instructionsNode.Content {
+		if getMappingValueNode(instructionNode, forEachKey) != nil || getMappingValueNode(instructionNode, matrixKey) != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getStageInstructionsNode returns the instructions of a stage in its sequence or map form, nil if there is no such stage
+func getStageInstructionsNode(node *yaml.Node, stage string) *yaml.Node {
+	stagesNode := getMappingValueNode(node, "stages")
+	if stagesNode == nil {
+		return nil
+	}
+
+	stageNode := getMappingValueNode(stagesNode, stage)
+	if stageNode != nil && stageNode.Kind == yaml.MappingNode {
+		stageNode = getMappingValueNode(stageNode, "instructions")
+	}
+	if stageNode == nil || stageNode.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	return stageNode
+}
+
+// matchNode reports whether the node has the values of the pattern, a map matches when it has the keys of the pattern
+// with matching values, e.g. {copy: {destination: /app}} matches a copy instruction with any sources
+func matchNode(pattern, node *yaml.Node) bool {
+	switch pattern.Kind {
+	case yaml.ScalarNode:
+		return node.Kind == yaml.ScalarNode && node.Value == pattern.Value
+	case yaml.SequenceNode:
+		if node.Kind != yaml.SequenceNode || len(node.Content) != len(pattern.Content) {
+			return false
+		}
+
+		for i := range pattern.Content {
+			if !matchNode(pattern.Content[i], node.Content[i]) {
+				return false
+			}
+		}
+
+		return true
+	case yaml.MappingNode:
+		// an empty map matches any value, e.g. {user: {}} matches user: app
+		if len(pattern.Content) == 0 {
+			return true
+		}
+
+		if node.Kind != yaml.MappingNode {
+			return false
+		}
+
+		for i := 0; i+1 < len(pattern.Content); i += 2 {
+			valueNode := getMappingValueNode(node, pattern.Content[i].Value)
+			if valueNode == nil || !matchNode(pattern.Content[i+1], valueNode) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,150 @@
+package dockerfilegenerator
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestPatches(t *testing.T) {
+	data, err := NewDockerFileDataFromYamlReader(strings.NewReader(`
+stages:
+  final:
+    instructions:
+      - from:
+          image: alpine
+      - envVariable:
+          name: A
+          value: a
+      - envVariable:
+          name: B
+          value: b
+      - user: app
+      - cmd:
+          params: [app]
+patches:
+  - stage: final
+    insertBefore:
+      envVariable:
+        name: A
+    instructions:
+      - workdir:
+          dir: /app
+  - stage: final
+    insertAfter: {user: app}
+    instructions:
+      - label:
+          name: a
+          value: b
+  - stage: final
+    replace: cmd
+    instructions:
+      - cmd:
+          params: [app, --debug]
+  - stage: final
+    remove:
+      envVariable: {name: B}
+  - stage: final
+    append:
+      - stopSignal:
+          signal: SIGTERM
+`), "")
+	assert.NoError(t, err)
+	assert.Equal(t, []Instruction{
+		From{Image: "alpine"},
+		Workdir{Dir: "/app"},
+		EnvVariable{Name: "A", Value: "a"},
+		User{User: "app"},
+		Label{Name: "a", Value: "b"},
+		Cmd{Params: []string{"app", "--debug"}, RunForm: ExecForm},
+		StopSignal{Signal: "SIGTERM"},
+	}, data.Stages[0].Instructions)
+}
+
+func TestPatchErrors(t *testing.T) {
+	_, err := NewDockerFileDataFromYamlReader(strings.NewReader(`
+stages:
+  final:
+    - from:
+        image: alpine
+    - envVariable:
+        name: A
+        value: a
+    - envVariable:
+        name: B
+        value: b
+patches:
+  - stage: final
+    remove: envVariable
+  - stage: final
+    remove: cmd
+  - stage: builder
+    remove: cmd
+  - stage: final
+    replace: from
+  - stage: final
+    remove: from
+    append: []
+  - stage: final
+    move: from
+  - remove: from
+  - stage: final
+    remove: {from: {}, user: {}}
+`), "")
+	assert.EqualError(t, err, `14:13: remove matches several instructions of stage "final", add fields to match one of them
+16:13: remove matches no instruction of stage "final"
+17:12: Patch refers to stage "builder", which isn't defined
+20:5: replace requires a sequence of instructions under the instructions key
+23:5: A patch should have a single operation, found remove and append
+25:5: Unknown patch key "move", expected stage, instructions, insertBefore, insertAfter, replace, remove or append
+26:5: A patch should have the name of its stage, e.g. stage: final
+28:13: remove should match an instruction by its type and fields, e.g. {copy: {destination: /app}}`)
+}
+
+func TestPatchesSnippets(t *testing.T) {
+	data, err := NewDockerFileDataFromYamlReader(strings.NewReader(`
+snippets:
+  nonRootUser:
+    - run:
+        params: [adduser, -D, app]
+    - user: app
+  workdir:
+    - workdir:
+        dir: /app
+stages:
+  final:
+    - from:
+        image: alpine
+    - use: nonRootUser
+patches:
+  - stage: final
+    replace: {user: app}
+    instructions:
+      - user: app:app
+  - stage: final
+    insertBefore: {run: {}}
+    instructions:
+      - use: workdir
+`), "")
+	assert.NoError(t, err)
+	assert.Equal(t, []Instruction{
+		From{Image: "alpine"},
+		Workdir{Dir: "/app"},
+		RunCommand{Params: []string{"adduser", "-D", "app"}, RunForm: ShellForm},
+		User{User: "app:app"},
+	}, data.Stages[0].Instructions)
+
+	_, err = NewDockerFileDataFromYamlReader(strings.NewReader(`
+stages:
+  final:
+    - from:
+        image: alpine
+    - run:
+        params: [echo, "${{ .vars.item }}"]
+      forEach: [a, b]
+patches:
+  - stage: final
+    remove: {run: {params: [echo, a]}}
+`), "")
+	assert.EqualError(t, err, `11:13: remove matches no instruction of stage "final", patches apply before the forEach and matrix loops are expanded`)
+}
@@ -0,0 +1,156 @@
+package dockerfilegenerator
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ScriptForm specifies how the Script of a RunCommand and the Content of a CopyCommand are rendered
+type ScriptForm string
+
+const (
+	// HeredocScriptForm renders scripts as heredocs, e.g. RUN <<EOF, this is the default.
+	// Heredocs require BuildKit, the syntax directive is added to the Dockerfile when they are used.
+	HeredocScriptForm ScriptForm = "heredoc"
+
+	// ChainedScriptForm renders the lines of a script chained with && and continued with the escape character,
+	// a backslash by default, for the classic builder that doesn't support heredocs
+	ChainedScriptForm ScriptForm = "chained"
+
+	// DefaultHeredocDelimiter is the heredoc delimiter used when none is given
+	DefaultHeredocDelimiter = "EOF"
+)
+
+var heredocDelimiterRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateScript checks the script can be rendered in the given form with the given delimiter
+func validateScript(script, delimiter string, form ScriptForm) error {
+	switch form {
+	case "", HeredocScriptForm, ChainedScriptForm:
+	default:
+		return fmt.Errorf("Invalid script form %q, expected heredoc or chained", form)
+	}
+
+	if delimiter == "" {
+		delimiter = DefaultHeredocDelimiter
+	}
+
+	if !heredocDelimiterRegexp.MatchString(delimiter) {
+		return fmt.Errorf("Invalid heredoc delimiter %q, expected a word such as EOF", delimiter)
+	}
+
+	for _, line := range strings.Split(script, "\n") {
+		if strings.TrimSpace(line) == delimiter {
+			return fmt.Errorf("The script contains the heredoc delimiter %s on its own line, use a different delimiter", delimiter)
+		}
+	}
+
+	if form == ChainedScriptForm && strings.HasPrefix(script, "#!") {
+		return errors.New("A script with a shebang can't be chained, use the heredoc script form")
+	}
+
+	// the patterns of a case statement end with ) and ;;, the lines can't be told apart from commands
+	if form == ChainedScriptForm {
+		for _, line := range strings.Split(script, "\n") {
+			if startsWithWord(strings.TrimSpace(line), "case") {
+				return errors.New("A script with a case statement can't be chained, use the heredoc script form")
+			}
+		}
+	}
+
+	return nil
+}
+
+// renderHeredoc returns the heredoc of the script in the form of <<EOF\n<script>\nEOF, prefix is written after <<EOF
+func renderHeredoc(script, delimiter, prefix string) string {
+	if delimiter == "" {
+		delimiter = DefaultHeredocDelimiter
+	}
+
+	return fmt.Sprintf("<<%s%s\n%s\n%s", delimiter, prefix, strings.TrimSuffix(script, "\n"), delimiter)
+}
+
+// renderChainedScript joins the commands of the script with && and continuations with the escape character, empty
+// lines and comments are dropped, lines that already end with a backslash continue the previous command.
+// The lines of if, for, while and { } blocks and pipes are joined with ; or only continued, see chainSeparator.
+func renderChainedScript(script string, escape rune) string {
+	var commands []string
+	continued := false
+
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if continued {
+			commands[len(commands)-1] += "\n    " + line
+		} else {
+			commands = append(commands, line)
+		}
+
+		// the builder only joins the lines that end with its escape character, the shell gets the joined line
+		continued = strings.HasSuffix(line, "\\")
+		if continued {
+			commands[len(commands)-1] = strings.TrimSuffix(commands[len(commands)-1], "\\") + string(escape)
+		}
+	}
+
+	var res strings.Builder
+	for i, command := range commands {
+		if i > 0 {
+			res.WriteString(chainSeparator(commands[i-1], command, escape))
+		}
+		res.WriteString(command)
+	}
+
+	return res.String()
+}
+
+// chainSeparator returns what joins two commands of a chained script. A line that expects the rest of its
+// construct, e.g. then, do, a pipe or a ;, is only continued, a line that ends a block, e.g. fi or done, follows a ;
+// and other commands are chained with &&.
+func chainSeparator(previous, next string, escape rune) string {
+	continuation := fmt.Sprintf(" %c\n    ", escape)
+
+	for _, word := range []string{"then", "do", "else"} {
+		if endsWithWord(previous, word) {
+			return continuation
+		}
+	}
+
+	for _, suffix := range []string{"{", "|", "&&", "(", ";"} {
+		if strings.HasSuffix(previous, suffix) {
+			return continuation
+		}
+	}
+
+	if strings.HasPrefix(next, "}") {
+		return ";" + continuation
+	}
+
+	for _, word := range []string{"then", "do", "else", "elif", "fi", "done"} {
+		if startsWithWord(next, word) {
+			return ";" + continuation
+		}
+	}
+
+	return " &&" + continuation
+}
+
+// startsWithWord reports whether the line starts with the shell word, e.g. fi in fi or fi;
+func startsWithWord(line, word string) bool {
+	return line == word || strings.HasPrefix(line, word+" ") || strings.HasPrefix(line, word+";")
+}
+
+// endsWithWord reports whether the line ends with the shell word, e.g. then in if true; then
+func endsWithWord(line, word string) bool {
+	return line == word || strings.HasSuffix(line, " "+word) || strings.HasSuffix(line, ";"+word)
+}
+
+// shellQuote quotes the value with single quotes for a POSIX shell
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
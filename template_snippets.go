@@ -0,0 +1,129 @@
+package dockerfilegenerator
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"sort"
+	"strings"
+)
+
+// applySnippets replaces the `use: <name>` items of the stages with the instructions of the snippet, snippets can use
+// other snippets. The snippets key is removed, a snippet is only decoded where it is used.
+func applySnippets(targetNode *yaml.Node, origins nodeOrigins) ConfigErrors {
+	return useSnippets(targetNode, takeMappingValueNode(targetNode, "snippets"), origins)
+}
+
+// useSnippets replaces the use items of the stages of the target node with the instructions of the snippets node,
+// which may be nil when no snippets are defined. The copies of the snippets keep the files they are included from.
+func useSnippets(targetNode, snippetsNode *yaml.Node, origins nodeOrigins) ConfigErrors {
+	var errs ConfigErrors
+	snippets := map[string]*yaml.Node{}
+
+	if snippetsNode != nil {
+		if snippetsNode.Kind != yaml.MappingNode {
+			return ConfigErrors{newConfigError(snippetsNode, "Snippets should be a map of names to sequences of instructions, e.g. nonRootUser: [...]")}
+		}
+
+		for i := 0; i+1 < len(snippetsNode.Content); i += 2 {
+			keyNode, valueNode := snippetsNode.Content[i], snippetsNode.Content[i+1]
+			if valueNode.Kind != yaml.SequenceNode {
+				errs = append(errs, newConfigError(valueNode, "Snippet %q should be a sequence of instructions", keyNode.Value))
+				continue
+			}
+			snippets[keyNode.Value] = valueNode
+		}
+	}
+
+	stagesNode := getMappingValueNode(targetNode, "stages")
+	if len(errs) > 0 || stagesNode == nil || stagesNode.Kind != yaml.MappingNode {
+		return errs
+	}
+
+	for i := 0; i+1 < len(stagesNode.Content); i += 2 {
+		instructionsNode := stagesNode.Content[i+1]
+		if instructionsNode.Kind == yaml.MappingNode {
+			instructionsNode = getMappingValueNode(instructionsNode, "instructions")
+		}
+		if instructionsNode == nil || instructionsNode.Kind != yaml.SequenceNode {
+			continue
+		}
+
+		content, stageErrs := expandSnippets(instructionsNode, snippets, nil, origins)
+		for _, stageErr := range stageErrs {
+			stageErr.Stage = stagesNode.Content[i].Value
+		}
+		errs = append(errs, stageErrs...)
+		instructionsNode.Content = content
+	}
+
+	return errs
+}
+
+// expandSnippets returns the instructions with the use items replaced, used is the chain of snippets being expanded
+func expandSnippets(instructionsNode *yaml.Node, snippets map[string]*yaml.Node, used []string, origins nodeOrigins) ([]*yaml.Node, ConfigErrors) {
+	var errs ConfigErrors
+	var content []*yaml.Node
+
+	for _, instructionNode := range instructionsNode.Content {
+		if instructionNode.Kind != yaml.MappingNode || len(instructionNode.Content) != 2 || instructionNode.Content[0].Value != "use" {
+			content = append(content, instructionNode)
+			continue
+		}
+
+		useNode := instructionNode.Content[1]
+		if useNode.Kind != yaml.ScalarNode {
+			errs = append(errs, newConfigError(useNode, "use should be the name of a snippet, e.g. use: nonRootUser"))
+			continue
+		}
+
+		snippet, ok := snippets[useNode.Value]
+		if !ok {
+			errs = append(errs, newConfigError(useNode, "Undefined snippet %q%s", useNode.Value, definedSnippets(snippets)))
+			continue
+		}
+
+		if containsString(used, useNode.Value) {
+			cycle := append(append([]string{}, used...), useNode.Value)
+			errs = append(errs, newConfigError(useNode, "Snippet cycle %s", strings.Join(cycle, " -> ")))
+			continue
+		}
+
+		expanded, snippetErrs := expandSnippets(snippet, snippets, append(used, useNode.Value), origins)
+		if len(snippetErrs) > 0 {
+			errs = append(errs, snippetErrs...)
+			continue
+		}
+
+		// every use gets its own copy, the loops and the variables are resolved on each of them
+		for _, node := range expanded {
+			content = append(content, origins.clone(node))
+		}
+	}
+
+	return content, errs
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// definedSnippets lists the snippet names for the undefined snippet errors
+func definedSnippets(snippets map[string]*yaml.Node) string {
+	if len(snippets) == 0 {
+		return ", no snippets are defined under snippets"
+	}
+
+	names := make([]string, 0, len(snippets))
+	for name := range snippets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return fmt.Sprintf(", defined snippets are %s", strings.Join(names, ", "))
+}
@@ -0,0 +1,52 @@
+package dockerfilegenerator
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestSnippets(t *testing.T) {
+	data, err := NewDockerFileDataFromYamlReader(strings.NewReader(`
+snippets:
+  caCertificates:
+    - run:
+        params: [apk add --no-cache ca-certificates]
+  nonRootUser:
+    - use: caCertificates
+    - user:
+        user: ${{ .vars.item }}
+stages:
+  final:
+    forEach: [app]
+    instructions:
+      - from:
+          image: alpine
+      - use: nonRootUser
+`), "")
+	assert.NoError(t, err)
+	assert.Equal(t, []Instruction{
+		From{Image: "alpine"},
+		RunCommand{Params: []string{"apk add --no-cache ca-certificates"}, RunForm: ShellForm},
+		User{User: "app"},
+	}, data.Stages[0].Instructions)
+
+	_, err = NewDockerFileDataFromYamlReader(strings.NewReader(`
+snippets:
+  a:
+    - use: b
+  b:
+    - use: a
+stages:
+  final:
+    - use: a
+    - use: c
+    - use: [a]
+`), "")
+	assert.EqualError(t, err, `6:12: stages.final: Snippet cycle a -> b -> a
+10:12: stages.final: Undefined snippet "c", defined snippets are a, b
+11:12: stages.final: use should be the name of a snippet, e.g. use: nonRootUser`)
+
+	_, err = NewDockerFileDataFromYamlReader(strings.NewReader("snippets:\n  a: {}\nstages: {}\n"), "")
+	assert.EqualError(t, err, `2:6: Snippet "a" should be a sequence of instructions`)
+}
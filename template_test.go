@@ -3,7 +3,11 @@ package dockerfilegenerator
 import (
 	"bytes"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"strings"
 	"testing"
+	"time"
 )
 
 var expectedGenericOutput = `FROM alpine:latest as builder
@@ -26,17 +30,25 @@ LABEL label1=label-value
 ENV DB_PASSWORD=password
 CMD echo test
 ENTRYPOINT ["echo", "test"]
-HEALTHCHECK --interval=DURATION --timeout=3s CMD curl -f http://localhost/
+HEALTHCHECK --interval=30s --timeout=3s CMD curl -f http://localhost/
 SHELL ["powershell", "-command"]
 WORKDIR test dir
 
 `
 
+var expectedOnbuildOutput = `FROM golang:1.13 as base
+WORKDIR /src
+ONBUILD COPY --chown=app . /src
+ONBUILD RUN go build ./...
+ONBUILD ENV CGO_ENABLED=0
+
+`
+
 func TestCodeRendering(t *testing.T) {
 	data := &DockerfileData{
 		Stages: []Stage{
 			// Stage 1 - Builder
-			[]Instruction{
+			{Instructions: []Instruction{
 				From{
 					Image: "golang:1.7.3", As: "builder",
 				},
@@ -58,9 +70,9 @@ func TestCodeRendering(t *testing.T) {
 				RunCommand{
 					Params: []string{"CGO_ENABLED=0", "GOOS=linux", "go", "build", "-a", "-installsuffix", "cgo", "-o", "app", "."},
 				},
-			},
+			}},
 			// Stage 2 - Final
-			[]Instruction{
+			{Instructions: []Instruction{
 				From{
 					Image: "alpine:latest", As: "final",
 				},
@@ -76,7 +88,7 @@ func TestCodeRendering(t *testing.T) {
 				Cmd{
 					Params: []string{"./app"},
 				},
-			},
+			}},
 		},
 	}
 
@@ -110,19 +122,19 @@ func TestCodeRenderingWithoutUser(t *testing.T) {
 	data := &DockerfileData{
 		Stages: []Stage{
 			// Stage 1 - Builder
-			[]Instruction{
+			{Instructions: []Instruction{
 				From{
 					Image: "golang:1.7.3", As: "builder",
 				},
-			},
-			[]Instruction{
+			}},
+			{Instructions: []Instruction{
 				From{
 					Image: "alpine:latest", As: "final",
 				},
 				Cmd{
 					Params: []string{"./app"},
 				},
-			},
+			}},
 		},
 	}
 
@@ -144,14 +156,14 @@ func TestCodeRenderingWithUserMap(t *testing.T) {
 	data := &DockerfileData{
 		Stages: []Stage{
 			// Stage 1 - Builder
-			[]Instruction{
+			{Instructions: []Instruction{
 				From{
 					Image: "golang:1.7.3", As: "builder",
 				},
 				User{
 					User: "ozan", Group: "admin",
 				},
-			},
+			}},
 		},
 	}
 
@@ -168,6 +180,483 @@ USER ozan:admin
 	assert.Equal(t, expectedOutput, output.String())
 }
 
+func TestStageHelpers(t *testing.T) {
+	data := &DockerfileData{
+		Stages: []Stage{
+			NewStage("builder", Arg{Name: "VERSION"}, From{Image: "golang:1.13"}, Arg{Name: "GOOS", Value: "linux"}),
+			{Name: "final", Platform: "linux/arm64", Instructions: []Instruction{From{Image: "alpine", As: "runtime", Platform: "linux/amd64"}}},
+			{Platform: "linux/arm64", Instructions: []Instruction{From{Image: "scratch"}}},
+		},
+	}
+
+	assert.Equal(t, "golang:1.13", data.Stage("builder").BaseImage())
+	assert.Equal(t, []Arg{{Name: "VERSION"}, {Name: "GOOS", Value: "linux"}}, data.Stage("builder").BuildArgs())
+	assert.Equal(t, "runtime", data.Stage("final").Alias())
+	assert.Equal(t, "alpine", data.Stage("runtime").BaseImage())
+	assert.Nil(t, data.Stage("missing"))
+
+	output := &bytes.Buffer{}
+	err := NewDockerfileTemplate(data).Render(output)
+	assert.NoError(t, err)
+
+	// the name and the platform of the stage don't override the ones the FROM instruction sets
+	expectedOutput := `ARG VERSION
+FROM golang:1.13 as builder
+ARG GOOS=linux
+
+FROM --platform=linux/amd64 alpine as runtime
+
+FROM --platform=linux/arm64 scratch
+
+`
+	assert.Equal(t, expectedOutput, output.String())
+}
+
+func TestAddInstruction(t *testing.T) {
+	checksum := "sha256:24454f830cdb571e2c4ad15481119c43b3cafd48dd869a9b2945d1036d1dc68d"
+	add := Add{
+		Sources: []string{"https://example.com/app.tar.gz"}, Destination: "/opt/", Chown: "app:app", Chmod: "755",
+		Checksum: checksum, KeepGitDir: true, Link: true,
+	}
+	assert.Equal(t, "ADD --chown=app:app --chmod=755 --checksum="+checksum+" --keep-git-dir=true --link https://example.com/app.tar.gz /opt/", add.Render())
+	assert.Equal(t, "ADD a b /c/", Add{Sources: []string{"a", "b"}, Destination: "/c/"}.Render())
+
+	var stage Stage
+	err := yaml.Unmarshal([]byte(`
+- add:
+    sources: [https://example.com/app.tar.gz]
+    destination: /opt/
+    chown: app:app
+    chmod: 755
+    checksum: sha256:24454f830cdb571e2c4ad15481119c43b3cafd48dd869a9b2945d1036d1dc68d
+    keepGitDir: true
+    link: true
+`), &stage)
+	assert.NoError(t, err)
+	assert.Equal(t, []Instruction{add}, stage.Instructions)
+
+	err = yaml.Unmarshal([]byte("- add:\n    destination: /opt/\n"), &stage)
+	assert.EqualError(t, err, "1:3: Failed to parse add instruction sources: the field is missing")
+
+	for expectedError, invalid := range map[string]Add{
+		`Invalid ADD --chmod "u+x", expected an octal file mode, e.g. 0755`:                                    {Sources: []string{"a"}, Destination: "/a", Chmod: "u+x"},
+		`Invalid ADD --checksum "abc", expected <algorithm>:<hex digest> with sha256, sha384 or sha512`:        {Sources: []string{"a"}, Destination: "/a", Checksum: "abc"},
+		`Invalid ADD --checksum "md5:abc", expected <algorithm>:<hex digest> with sha256, sha384 or sha512`:    {Sources: []string{"a"}, Destination: "/a", Checksum: "md5:abc"},
+		`Invalid ADD --checksum "sha256:abc", expected <algorithm>:<hex digest> with sha256, sha384 or sha512`: {Sources: []string{"a"}, Destination: "/a", Checksum: "sha256:abc"},
+	} {
+		assert.EqualError(t, invalid.Validate(), expectedError)
+	}
+
+	err = yaml.Unmarshal([]byte("- add:\n    sources: [a]\n    destination: /a\n    chmod: 999\n"), &stage)
+	assert.EqualError(t, err, `1:3: Failed to parse add instruction: Invalid ADD --chmod "999", expected an octal file mode, e.g. 0755`)
+}
+
+func TestCopyFlags(t *testing.T) {
+	copyCommand := CopyCommand{
+		Sources: []string{"services/api/go.mod", "libs/*/go.mod"}, Destination: "/src/", From: "context", Chown: "app:app",
+		Chmod: "0755", Link: true, Parents: true, Exclude: []string{"*.md", "**/testdata"},
+	}
+	assert.NoError(t, copyCommand.Validate())
+	assert.Equal(t, "COPY --from=context --chown=app:app --chmod=0755 --link --parents --exclude=*.md --exclude=**/testdata "+
+		"services/api/go.mod libs/*/go.mod /src/", copyCommand.Render())
+
+	chained := CopyCommand{Content: "#!/bin/sh\necho hi", Destination: "/entrypoint.sh", Chmod: "755", ScriptForm: ChainedScriptForm}
+	assert.Equal(t, "RUN printf '%s\\n' '#!/bin/sh' 'echo hi' > '/entrypoint.sh' && \\\n    chmod 755 '/entrypoint.sh'", chained.Render())
+
+	for expectedError, invalid := range map[string]CopyCommand{
+		`Invalid COPY --chmod "u+x", expected an octal file mode, e.g. 0755`:                             {Sources: []string{"a"}, Destination: "/a", Chmod: "u+x"},
+		`Invalid COPY --chmod "0789", expected an octal file mode, e.g. 0755`:                            {Sources: []string{"a"}, Destination: "/a", Chmod: "0789"},
+		"COPY --exclude patterns can't be empty":                                                         {Sources: []string{"a"}, Destination: "/a", Exclude: []string{""}},
+		`Invalid COPY --exclude pattern "[a-": syntax error in pattern`:                                  {Sources: []string{"a"}, Destination: "/a", Exclude: []string{"[a-"}},
+		"COPY --parents and --exclude only apply to sources, not to content":                             {Content: "a", Destination: "/a", Parents: true},
+		"COPY --link doesn't apply to content in the chained script form, it is written with RUN printf": {Content: "a", Destination: "/a", Link: true, ScriptForm: ChainedScriptForm},
+	} {
+		assert.EqualError(t, invalid.Validate(), expectedError)
+	}
+
+	// the chained form can't render the flags that only apply to sources
+	chained = CopyCommand{Content: "a", Destination: "/a", Exclude: []string{"*.md"}, ScriptForm: ChainedScriptForm}
+	assert.EqualError(t, chained.Validate(), "COPY --parents and --exclude only apply to sources, not to content")
+
+	var stage Stage
+	err := yaml.Unmarshal([]byte(`
+- copy:
+    sources: [services/api/go.mod, libs/*/go.mod]
+    destination: /src/
+    from: context
+    chown: app:app
+    chmod: 0755
+    link: true
+    parents: true
+    exclude: ["*.md", "**/testdata"]
+`), &stage)
+	assert.NoError(t, err)
+	assert.Equal(t, []Instruction{copyCommand}, stage.Instructions)
+
+	err = yaml.Unmarshal([]byte("- copy:\n    sources: [a]\n    destination: /a\n    chmod: +x\n"), &stage)
+	assert.EqualError(t, err, `1:3: Failed to parse copy instruction: Invalid COPY --chmod "+x", expected an octal file mode, e.g. 0755`)
+
+	err = yaml.Unmarshal([]byte("- copy:\n    sources: [a]\n    destination: /a\n    exclude: \"*.md\"\n"), &stage)
+	assert.EqualError(t, err, "1:3: Failed to parse copy instruction exclude: expected a list, got *.md")
+}
+
+func TestOnbuildInstruction(t *testing.T) {
+	onbuild := Onbuild{Instruction: CopyCommand{Sources: []string{"."}, Destination: "/src", Chown: "app"}}
+	assert.NoError(t, onbuild.Validate())
+	assert.Equal(t, "ONBUILD COPY --chown=app . /src", onbuild.Render())
+	assert.Equal(t, `ONBUILD RUN ["go", "build"]`, Onbuild{Instruction: RunCommand{Params: []string{"go", "build"}, RunForm: ExecForm}}.Render())
+	assert.Equal(t, "ONBUILD echo test", Onbuild{Params: []string{"echo", "test"}}.Render())
+	assert.Equal(t, "ONBUILD ENV GREETING=\"hello`\"world\"", Onbuild{Instruction: EnvVariable{Name: "GREETING", Value: `hello"world`}}.renderEscaped('`'))
+
+	for expectedError, invalid := range map[string]Onbuild{
+		"FROM isn't allowed as an ONBUILD trigger":                     {Instruction: From{Image: "alpine"}},
+		"ONBUILD isn't allowed as an ONBUILD trigger":                  {Instruction: Onbuild{Params: []string{"echo"}}},
+		"MAINTAINER isn't allowed as an ONBUILD trigger":               {Params: []string{"maintainer", "me"}},
+		"ONBUILD can't wrap a comment":                                 {Instruction: Comment{Text: "note"}},
+		"ONBUILD requires an instruction":                              {},
+		"ONBUILD RUN can't be a heredoc, use the chained script form":  {Instruction: RunCommand{Script: "go build"}},
+		"ONBUILD COPY can't be a heredoc, use the chained script form": {Instruction: CopyCommand{Content: "a", Destination: "/a"}},
+		`Invalid port "0", expected a number between 1 and 65535`:      {Instruction: Expose{Ports: []string{"0"}}},
+		"ONBUILD trigger should render a single instruction":           {Instruction: Arg{Name: "VERSION", Test: true, EnvVariable: true}},
+	} {
+		assert.EqualError(t, invalid.Validate(), expectedError)
+	}
+
+	// the trigger is rendered and validated in the script form of the data
+	data := &DockerfileData{ScriptForm: ChainedScriptForm, Stages: []Stage{NewStage("base",
+		From{Image: "golang"},
+		Onbuild{Instruction: RunCommand{Script: "go mod download\ngo build ./..."}},
+	)}}
+	assert.NoError(t, data.Validate())
+	output := &bytes.Buffer{}
+	assert.NoError(t, NewDockerfileTemplate(data).Render(output))
+	assert.Equal(t, "FROM golang as base\nONBUILD RUN go mod download && \\\n    go build ./...\n\n", output.String())
+
+	var stage Stage
+	err := yaml.Unmarshal([]byte(`
+- onbuild:
+    copy:
+      sources: [.]
+      destination: /src
+      chown: app
+    comment: copies the sources of the child image
+- onbuild:
+    params: [echo, test]
+`), &stage)
+	assert.NoError(t, err)
+	onbuild.Comment = "copies the sources of the child image"
+	assert.Equal(t, []Instruction{onbuild, Onbuild{Params: []string{"echo", "test"}}}, stage.Instructions)
+
+	err = yaml.Unmarshal([]byte("- onbuild:\n    from:\n      image: alpine\n"), &stage)
+	assert.EqualError(t, err, "1:3: Failed to parse onBuild instruction: FROM isn't allowed as an ONBUILD trigger")
+
+	err = yaml.Unmarshal([]byte("- onbuild:\n    workdir: /src\n    user: app\n"), &stage)
+	assert.EqualError(t, err, "1:3: Failed to parse onBuild instruction: An instruction should be a map with a single key, found 2 keys")
+}
+
+func TestRunCommandFlags(t *testing.T) {
+	run := RunCommand{
+		Params:   []string{"go", "build", "./..."},
+		Network:  "none",
+		Security: "insecure",
+		Mounts: []Mount{
+			{Type: CacheMount, Target: "/root/.cache/go-build", ID: "gobuild", Sharing: "locked", UID: "1000", GID: "1000", Mode: "0755"},
+			{Type: SecretMount, ID: "netrc", Target: "/root/.netrc", Required: true, Mode: "0400"},
+			{Type: SSHMount},
+			{Type: BindMount, Target: "/src", From: "builder", Source: "/app", ReadWrite: true},
+			{Type: TmpfsMount, Target: "/tmp", Size: "64m"},
+		},
+	}
+	assert.NoError(t, run.Validate())
+	assert.Equal(t, "RUN --mount=type=cache,id=gobuild,target=/root/.cache/go-build,sharing=locked,mode=0755,uid=1000,gid=1000 "+
+		"--mount=type=secret,id=netrc,target=/root/.netrc,mode=0400,required=true "+
+		"--mount=type=ssh "+
+		"--mount=type=bind,target=/src,source=/app,from=builder,rw=true "+
+		"--mount=type=tmpfs,target=/tmp,size=64m "+
+		"--network=none --security=insecure go build ./...", run.Render())
+
+	assert.Equal(t, `RUN --network=host ["go", "test"]`, RunCommand{Params: []string{"go", "test"}, RunForm: ExecForm, Network: "host"}.Render())
+
+	for expectedError, invalid := range map[string]RunCommand{
+		`Invalid network "bridge", expected none, host or default`:                {Network: "bridge"},
+		`Invalid security "privileged", expected insecure or sandbox`:             {Security: "privileged"},
+		`Invalid mount type "volume", expected bind, cache, tmpfs, secret or ssh`: {Mounts: []Mount{{Type: "volume"}}},
+		"Mount option sharing isn't supported by secret mounts":                   {Mounts: []Mount{{Type: SecretMount, ID: "a", Sharing: "locked"}}},
+		"Mount option required isn't supported by cache mounts":                   {Mounts: []Mount{{Type: CacheMount, Target: "/a", Required: true}}},
+		"Mount option rw isn't supported by tmpfs mounts":                         {Mounts: []Mount{{Type: TmpfsMount, Target: "/a", ReadWrite: true}}},
+		"bind mounts require a target":                                            {Mounts: []Mount{{Type: BindMount, Source: "/a"}}},
+		"secret mounts require an id or a target":                                 {Mounts: []Mount{{Type: SecretMount, Required: true}}},
+		`Invalid mount sharing "exclusive", expected shared, private or locked`:   {Mounts: []Mount{{Type: CacheMount, Target: "/a", Sharing: "exclusive"}}},
+		`Invalid mount mode "rw", expected an octal file mode, e.g. 0400`:         {Mounts: []Mount{{Type: SSHMount, Mode: "rw"}}},
+		`Invalid mount gid "admin", expected a number`:                            {Mounts: []Mount{{Type: SSHMount, UID: "0", GID: "admin"}}},
+	} {
+		assert.EqualError(t, invalid.Validate(), expectedError)
+	}
+
+	var stage Stage
+	err := yaml.Unmarshal([]byte(`
+- run:
+    params: [go build ./...]
+    mounts:
+      - type: cache
+        target: /root/.cache/go-build
+        sharing: locked
+        uid: 1000
+      - type: secret
+        id: netrc
+        required: true
+    network: none
+    security: sandbox
+`), &stage)
+	assert.NoError(t, err)
+	assert.Equal(t, []Instruction{RunCommand{
+		Params: []string{"go build ./..."}, RunForm: ShellForm, Network: "none", Security: "sandbox",
+		Mounts: []Mount{
+			{Type: CacheMount, Target: "/root/.cache/go-build", Sharing: "locked", UID: "1000"},
+			{Type: SecretMount, ID: "netrc", Required: true},
+		},
+	}}, stage.Instructions)
+
+	err = yaml.Unmarshal([]byte("- run:\n    params: [ls]\n    mounts:\n      - type: cache\n"), &stage)
+	assert.EqualError(t, err, "1:3: Failed to parse run instruction: cache mounts require a target")
+
+	err = yaml.Unmarshal([]byte("- run:\n    params: [ls]\n    mounts: [cache]\n"), &stage)
+	assert.EqualError(t, err, "1:3: Failed to parse run instruction mounts: Yaml contains an unexpected data, caused by cache, type: string")
+}
+
+func TestExposeInstruction(t *testing.T) {
+	expose := Expose{Ports: []string{"80", "53/udp", "8000-8010/tcp", "${PORT}/tcp", "$METRICS_PORT"}}
+	assert.Equal(t, "EXPOSE 80 53/udp 8000-8010/tcp ${PORT}/tcp $METRICS_PORT", expose.Render())
+	assert.NoError(t, expose.Validate())
+
+	for ports, expectedError := range map[string]string{
+		"":            "EXPOSE requires at least one port",
+		"0":           `Invalid port "0", expected a number between 1 and 65535`,
+		"65536/tcp":   `Invalid port "65536/tcp", expected a number between 1 and 65535`,
+		"http":        `Invalid port "http", expected a number between 1 and 65535`,
+		"80/sctp":     `Invalid protocol "sctp" in port "80/sctp", expected tcp or udp`,
+		"9000-8000":   `Invalid port range "9000-8000", the start is greater than the end`,
+		"8000-":       `Invalid port "8000-", expected a number between 1 and 65535`,
+		"${PORT":      `Invalid port "${PORT", expected a number between 1 and 65535`,
+		"80 ${PORT}x": `Invalid port "${PORT}x", expected a number between 1 and 65535`,
+	} {
+		err := Expose{Ports: strings.Fields(ports)}.Validate()
+		assert.EqualError(t, err, expectedError, ports)
+	}
+
+	var stage Stage
+	err := yaml.Unmarshal([]byte("- expose:\n    ports: [8080, 53/udp]\n"), &stage)
+	assert.NoError(t, err)
+	assert.Equal(t, []Instruction{Expose{Ports: []string{"8080", "53/udp"}}}, stage.Instructions)
+
+	err = yaml.Unmarshal([]byte("- expose:\n    ports: [80, 99999]\n"), &stage)
+	assert.EqualError(t, err, `1:3: Failed to parse expose instruction: Invalid port "99999", expected a number between 1 and 65535`)
+}
+
+func TestStopSignalInstruction(t *testing.T) {
+	assert.Equal(t, "STOPSIGNAL SIGTERM", StopSignal{Signal: "SIGTERM"}.Render())
+
+	for _, signal := range []string{"SIGTERM", "TERM", "sigquit", "9", "64", "SIGRTMIN+3", "RTMAX-2", "${STOP_SIGNAL}"} {
+		assert.NoError(t, StopSignal{Signal: signal}.Validate(), signal)
+	}
+
+	for signal, expectedError := range map[string]string{
+		"":            "STOPSIGNAL requires a signal",
+		"0":           "Invalid signal number 0, expected a number between 1 and 64",
+		"65":          "Invalid signal number 65, expected a number between 1 and 64",
+		"SIGFOO":      `Unknown signal "SIGFOO"`,
+		"SIGRTMIN+16": `Unknown signal "SIGRTMIN+16"`,
+	} {
+		assert.EqualError(t, StopSignal{Signal: signal}.Validate(), expectedError, signal)
+	}
+
+	var stage Stage
+	err := yaml.Unmarshal([]byte("- stopSignal:\n    signal: 9\n"), &stage)
+	assert.NoError(t, err)
+	assert.Equal(t, []Instruction{StopSignal{Signal: "9"}}, stage.Instructions)
+
+	err = yaml.Unmarshal([]byte("- stopSignal:\n    signal: SIGNOPE\n"), &stage)
+	assert.EqualError(t, err, `1:3: Failed to parse stopSignal instruction: Unknown signal "SIGNOPE"`)
+
+	data := &DockerfileData{Stages: []Stage{NewStage("final", From{Image: "alpine"}, StopSignal{Signal: "SIGNOPE"})}}
+	assert.EqualError(t, data.Validate(), `stages.final[1]: Unknown signal "SIGNOPE"`)
+}
+
+func TestHealthCheckInstruction(t *testing.T) {
+	assert.Equal(t, "HEALTHCHECK NONE", HealthCheck{None: true}.Render())
+	assert.Equal(t, "HEALTHCHECK CMD curl -f http://localhost/", HealthCheck{Params: []string{"curl -f http://localhost/"}}.Render())
+	assert.Equal(t,
+		`HEALTHCHECK --interval=1m30s --timeout=3s --start-period=1h --start-interval=500ms --retries=3 CMD ["curl", "-f", "http://localhost/"]`,
+		HealthCheck{
+			Params: []string{"curl", "-f", "http://localhost/"}, RunForm: ExecForm,
+			Interval: 90 * time.Second, Timeout: 3 * time.Second, StartPeriod: time.Hour, StartInterval: 500 * time.Millisecond, Retries: 3,
+		}.Render(),
+	)
+
+	for _, h := range []HealthCheck{
+		{None: true},
+		{Params: []string{"true"}, Interval: time.Millisecond},
+	} {
+		assert.NoError(t, h.Validate())
+	}
+
+	for expectedError, h := range map[string]HealthCheck{
+		"HEALTHCHECK NONE doesn't accept a command or options":       {None: true, Retries: 3},
+		"HEALTHCHECK requires a command unless none is set":          {Interval: time.Second},
+		"HEALTHCHECK --timeout can't be less than 1ms, got 10µs":     {Params: []string{"true"}, Timeout: 10 * time.Microsecond},
+		"HEALTHCHECK --start-period can't be less than 1ms, got -1s": {Params: []string{"true"}, StartPeriod: -time.Second},
+		"HEALTHCHECK --retries can't be negative, got -1":            {Params: []string{"true"}, Retries: -1},
+	} {
+		assert.EqualError(t, h.Validate(), expectedError)
+	}
+
+	// the struct form of the options in params, used before the fields, is read into the fields
+	legacy := HealthCheck{Params: []string{"--interval=5s", "CMD", "curl", "-f", "localhost"}}
+	assert.Equal(t, "HEALTHCHECK --interval=5s CMD curl -f localhost", legacy.Render())
+	assert.NoError(t, legacy.Validate())
+	assert.Equal(t, "HEALTHCHECK --interval=5s --retries=3 CMD curl", HealthCheck{Params: []string{"CMD", "curl"}, Interval: 5 * time.Second, Retries: 3}.Render())
+	assert.Equal(t, "HEALTHCHECK NONE", HealthCheck{Params: []string{"NONE"}}.Render())
+	assert.EqualError(t, HealthCheck{Params: []string{"--interval=soon", "CMD", "true"}}.Validate(), `Invalid HEALTHCHECK --interval "soon", expected a duration, e.g. 30s`)
+	assert.EqualError(t, HealthCheck{Params: []string{"--retries=3", "curl"}}.Validate(), `HEALTHCHECK expects options followed by CMD or NONE, got "curl"`)
+	assert.EqualError(t, HealthCheck{Params: []string{"NONE"}, Retries: 3}.Validate(), "HEALTHCHECK NONE doesn't accept a command or options")
+
+	var stage Stage
+	err := yaml.Unmarshal([]byte(`
+- healthCheck:
+    interval: 30s
+    startInterval: 2s
+    retries: 5
+    runForm: exec
+    params: [curl, -f, http://localhost/]
+- healthCheck:
+    none: true
+- healthCheck:
+    params: [--timeout=3s, CMD, curl, -f, http://localhost/]
+`), &stage)
+	assert.NoError(t, err)
+	assert.Equal(t, []Instruction{
+		HealthCheck{Params: []string{"curl", "-f", "http://localhost/"}, RunForm: ExecForm, Interval: 30 * time.Second, StartInterval: 2 * time.Second, Retries: 5},
+		HealthCheck{None: true, RunForm: ShellForm},
+		HealthCheck{Params: []string{"curl", "-f", "http://localhost/"}, RunForm: ShellForm, Timeout: 3 * time.Second},
+	}, stage.Instructions)
+	assert.Equal(t, "docker/dockerfile:1.6", minimalSyntax([][]Instruction{stage.Instructions}))
+
+	err = yaml.Unmarshal([]byte(`
+- healthCheck:
+    interval: 30
+    params: [curl]
+- healthCheck:
+    none: true
+    params: [curl]
+- healthCheck:
+    params: [--interval=DURATION, CMD, curl]
+`), &stage)
+	assert.EqualError(t, err, `2:3: Failed to parse healthCheck instruction: Invalid HEALTHCHECK --interval "30", expected a duration, e.g. 30s
+5:3: Failed to parse healthCheck instruction: HEALTHCHECK NONE doesn't accept a command or options
+8:3: Failed to parse healthCheck instruction params: Invalid HEALTHCHECK --interval "DURATION", expected a duration, e.g. 30s`)
+}
+
+func TestScripts(t *testing.T) {
+	script := "set -e\n# update the index first\napt-get update\n\napt-get install -y \\\n  git\n"
+	data := &DockerfileData{Stages: []Stage{NewStage("final",
+		From{Image: "debian"},
+		RunCommand{Script: script, Mounts: []Mount{{Type: CacheMount, Target: "/var/cache/apt"}}},
+		CopyCommand{Content: "[user]\n  name = ozan", Destination: "/root/.gitconfig", Chown: "root", Delimiter: "CONFIG"},
+	)}}
+
+	output := &bytes.Buffer{}
+	assert.NoError(t, data.Validate())
+	assert.NoError(t, NewDockerfileTemplate(data).Render(output))
+	assert.Equal(t, `# syntax=docker/dockerfile:1.4
+FROM debian as final
+RUN --mount=type=cache,target=/var/cache/apt <<EOF
+set -e
+# update the index first
+apt-get update
+
+apt-get install -y \
+  git
+EOF
+COPY --chown=root <<CONFIG /root/.gitconfig
+[user]
+  name = ozan
+CONFIG
+
+`, output.String())
+
+	data.ScriptForm = ChainedScriptForm
+	output.Reset()
+	assert.NoError(t, data.Validate())
+	assert.NoError(t, NewDockerfileTemplate(data).Render(output))
+	assert.Equal(t, `# syntax=docker/dockerfile:1.2
+FROM debian as final
+RUN --mount=type=cache,target=/var/cache/apt set -e && \
+    apt-get update && \
+    apt-get install -y \
+    git
+RUN printf '%s\n' '[user]' '  name = ozan' > '/root/.gitconfig' && \
+    chown 'root' '/root/.gitconfig'
+
+`, output.String())
+
+	// the lines of blocks and pipes aren't chained with &&, the builder would run a shell syntax error
+	for script, expected := range map[string]string{
+		"if [ -f /x ]; then\n  echo yes\nelse\n  echo no\n  exit 1\nfi\necho done": "RUN if [ -f /x ]; then \\\n    echo yes; \\\n    else \\\n    echo no && \\\n    exit 1; \\\n    fi && \\\n    echo done",
+		"for f in a b\ndo\n  echo $f\ndone":                                        "RUN for f in a b; \\\n    do \\\n    echo $f; \\\n    done",
+		"while read -r line; do echo \"$line\"; done < /x |\n  sort":               "RUN while read -r line; do echo \"$line\"; done < /x | \\\n    sort",
+		"cleanup() {\n  rm -rf /tmp/x\n}\ncleanup":                                 "RUN cleanup() { \\\n    rm -rf /tmp/x; \\\n    } && \\\n    cleanup",
+	} {
+		run := RunCommand{Script: script, ScriptForm: ChainedScriptForm}
+		assert.NoError(t, run.Validate())
+		assert.Equal(t, expected, run.Render(), script)
+	}
+
+	for expectedError, invalid := range map[string]validator{
+		"A script with a case statement can't be chained, use the heredoc script form":             RunCommand{Script: "case $1 in\n  a) echo a;;\nesac", ScriptForm: ChainedScriptForm},
+		"RUN accepts either params or a script, not both":                                          RunCommand{Params: []string{"ls"}, Script: "ls"},
+		`Invalid heredoc delimiter "END OF", expected a word such as EOF`:                          RunCommand{Script: "ls", Delimiter: "END OF"},
+		"The script contains the heredoc delimiter EOF on its own line, use a different delimiter": RunCommand{Script: "cat <<EOF\nhi\nEOF"},
+		`Invalid script form "inline", expected heredoc or chained`:                                RunCommand{Script: "ls", ScriptForm: "inline"},
+		"A script with a shebang can't be chained, use the heredoc script form":                    RunCommand{Script: "#!/usr/bin/env python\nprint(1)", ScriptForm: ChainedScriptForm},
+		"COPY accepts either sources or content, content can't be copied from another stage":       CopyCommand{Content: "a", Sources: []string{"b"}, Destination: "/c"},
+		"COPY requires a destination for the content":                                              CopyCommand{Content: "a"},
+	} {
+		assert.EqualError(t, invalid.Validate(), expectedError)
+	}
+
+	// scripts are validated in the script form of the data
+	data = &DockerfileData{ScriptForm: ChainedScriptForm, Stages: []Stage{NewStage("final",
+		From{Image: "python"},
+		RunCommand{Script: "#!/usr/bin/env python\nprint(1)"},
+	)}}
+	assert.EqualError(t, data.Validate(), "stages.final[1]: A script with a shebang can't be chained, use the heredoc script form")
+
+	decoded, err := NewDockerFileDataFromYamlReader(strings.NewReader(`
+scriptForm: chained
+stages:
+  final:
+    - run:
+        script: |
+          go mod download
+          go build ./...
+    - copy:
+        content: hello
+        destination: /hello.txt
+`), "")
+	assert.NoError(t, err)
+	assert.Equal(t, &DockerfileData{ScriptForm: ChainedScriptForm, Stages: []Stage{{Name: "final", Instructions: []Instruction{
+		RunCommand{Script: "go mod download\ngo build ./...\n", RunForm: ShellForm},
+		CopyCommand{Content: "hello", Destination: "/hello.txt"},
+	}}}}, decoded)
+
+	var stage Stage
+	err = yaml.Unmarshal([]byte("- copy:\n    content: hello\n"), &stage)
+	assert.EqualError(t, err, "1:3: Failed to parse copy instruction: COPY requires a destination for the content")
+
+	_, err = NewDockerFileDataFromYamlReader(strings.NewReader("scriptForm: inline\nstages:\n  final: []\n"), "")
+	assert.EqualError(t, err, `1:13: Invalid script form "inline", expected heredoc or chained`)
+}
+
 func TestYamlRendering(t *testing.T) {
 	data, err := NewDockerFileDataFromYamlFile("./example-input-files/test-input.yaml")
 	tmpl := NewDockerfileTemplate(data)
@@ -276,7 +765,7 @@ func TestYamlRenderingTargetField5(t *testing.T) {
 		{
 			name:        "ProdApache",
 			targetField: ".prod.apache",
-			expectedOutput: `FROM kstaken/apache2
+			expectedOutput: `FROM kstaken/apache2 as final
 RUN apt-get update && apt-get install -y php5 libapache2-mod-php5 && apt-get clean && rm -rf /var/lib/apt/lists/*
 CMD ["/usr/sbin/apache2", "-D", "FOREGROUND"]
 
@@ -285,7 +774,7 @@ CMD ["/usr/sbin/apache2", "-D", "FOREGROUND"]
 		{
 			name:        "DevApache",
 			targetField: ".dev.apache",
-			expectedOutput: `FROM kstaken/apache2
+			expectedOutput: `FROM kstaken/apache2 as final
 RUN apt-get update && apt-get install -y php5 libapache2-mod-php5 && apt-get clean && rm -rf /var/lib/apt/lists/*
 CMD ["/usr/sbin/apache2", "-D", "FOREGROUND"]
 
@@ -336,7 +825,7 @@ func TestYamlRenderingTargetField6(t *testing.T) {
 	output := &bytes.Buffer{}
 	err = tmpl.Render(output)
 	assert.NoError(t, err)
-	expectedOutput := `FROM kstaken/apache2
+	expectedOutput := `FROM kstaken/apache2 as final
 RUN apt-get update && apt-get clean && rm -rf /var/lib/apt/lists/*
 
 `
@@ -344,6 +833,35 @@ RUN apt-get update && apt-get clean && rm -rf /var/lib/apt/lists/*
 	assert.Equal(t, expectedOutput, output.String())
 }
 
+func TestYamlRenderingExtends(t *testing.T) {
+	data, err := NewDockerFileDataFromYamlField("./example-input-files/test-input-with-extends.yaml", ".dev.apache")
+	tmpl := NewDockerfileTemplate(data)
+	assert.NoError(t, err)
+
+	output := &bytes.Buffer{}
+	err = tmpl.Render(output)
+	assert.NoError(t, err)
+	expectedOutput := `FROM kstaken/apache2 as final
+RUN apt-get update && apt-get install -y php5 libapache2-mod-php5 && apt-get clean && rm -rf /var/lib/apt/lists/*
+ENV APP_ENV=dev
+CMD ["/usr/sbin/apache2", "-D", "FOREGROUND"]
+
+`
+
+	assert.Equal(t, expectedOutput, output.String())
+}
+
+func TestYamlRenderingOnbuild(t *testing.T) {
+	data, err := NewDockerFileDataFromYamlFile("./example-input-files/test-input-with-onbuild.yaml")
+	assert.NoError(t, err)
+
+	output := &bytes.Buffer{}
+	err = NewDockerfileTemplate(data).Render(output)
+	assert.NoError(t, err)
+
+	assert.Equal(t, expectedOnbuildOutput, output.String())
+}
+
 func TestYamlRenderingFail(t *testing.T) {
 	data, err := NewDockerFileDataFromYamlFile("./example-input-files/invalid-input.yaml")
 	tmpl := NewDockerfileTemplate(data)
@@ -354,7 +872,75 @@ func TestYamlRenderingFail(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestRenderValidates(t *testing.T) {
+	data := &DockerfileData{Stages: []Stage{
+		NewStage("builder", From{Image: "golang"}, CopyCommand{From: "final", Sources: []string{"/a"}, Destination: "/a"}),
+		NewStage("final", From{Image: "alpine"}, Expose{Ports: []string{"70000"}}, StopSignal{Signal: "SIGNOPE"}),
+	}}
+
+	output := &bytes.Buffer{}
+	err := NewDockerfileTemplate(data).Render(output)
+	assert.IsType(t, ConfigErrors{}, err)
+	assert.EqualError(t, err, strings.Join([]string{
+		`stages.final[1]: Invalid port "70000", expected a number between 1 and 65535`,
+		`stages.final[2]: Unknown signal "SIGNOPE"`,
+		`stages.builder[1]: COPY --from refers to stage "final", which is defined later`,
+	}, "\n"))
+	assert.Empty(t, output.String())
+}
+
+func TestYamlReader(t *testing.T) {
+	content, err := ioutil.ReadFile("./example-input-files/test-input-with-target-key-6.yaml")
+	assert.NoError(t, err)
+
+	data, err := NewDockerFileDataFromYamlReader(bytes.NewReader(content), ".serverConfig.dockerfile")
+	assert.NoError(t, err)
+
+	output := &bytes.Buffer{}
+	err = NewDockerfileTemplate(data).Render(output)
+	assert.NoError(t, err)
+
+	expectedOutput := `FROM kstaken/apache2 as final
+RUN apt-get update && apt-get clean && rm -rf /var/lib/apt/lists/*
+
+`
+	assert.Equal(t, expectedOutput, output.String())
+
+	_, err = NewDockerFileDataFromYamlReader(strings.NewReader("stages:\n  final:\n    - from: alpine\n"), "")
+	assert.EqualError(t, err, "3:7: stages.final[0]: Unknown instruction \"from\"")
+}
+
 func TestInvalidYamlFilePath(t *testing.T) {
 	_, err := NewDockerFileDataFromYamlFile("non-existent.yaml")
 	assert.EqualError(t, err, "Unmarshal: yamlFile.Get err #open non-existent.yaml: no such file or directory")
 }
+
+func TestSetTargetNode(t *testing.T) {
+	node := yaml.Node{}
+	err := yaml.Unmarshal([]byte("someConfig:\n  key: value\nservers:\n  - name: api\n"), &node)
+	assert.NoError(t, err)
+
+	value := &yaml.Node{Kind: yaml.ScalarNode, Value: "replaced"}
+
+	assert.NoError(t, SetTargetNode(&node, ".someConfig.key", value))
+	assert.NoError(t, SetTargetNode(&node, ".servers[0].docker.config", value))
+	assert.NoError(t, SetTargetNode(&node, ".servers[1]", value))
+	assert.EqualError(t, SetTargetNode(&node, ".servers[3]", value), "Index 3 is out of range")
+	assert.EqualError(t, SetTargetNode(&node, ".someConfig[0]", value), "Expected a seq with key 0")
+
+	for _, targetField := range []string{".someConfig.key", ".servers[0].docker.config", ".servers[1]"} {
+		target, err := getTargetNode(&node, targetField)
+		assert.NoError(t, err)
+		assert.Equal(t, value, target)
+	}
+
+	name, err := getTargetNode(&node, ".servers[0].name")
+	assert.NoError(t, err)
+	assert.Equal(t, "api", name.Value)
+
+	empty := yaml.Node{}
+	assert.NoError(t, SetTargetNode(&empty, ".dockerfile", value))
+	target, err := getTargetNode(&empty, ".dockerfile")
+	assert.NoError(t, err)
+	assert.Equal(t, value, target)
+}
@@ -0,0 +1,188 @@
+package dockerfilegenerator
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tomlKeyOrder keeps the declaration order of the keys of every table, since the decoded maps are unordered
+type tomlKeyOrder map[string][]string
+
+func newTOMLKeyOrder(keys []toml.Key) tomlKeyOrder {
+	order := tomlKeyOrder{}
+	seen := map[string]bool{}
+
+	for _, key := range keys {
+		for i := range key {
+			path := strings.Join(key[:i+1], "\x00")
+			if seen[path] {
+				continue
+			}
+
+			seen[path] = true
+			parent := strings.Join(key[:i], "\x00")
+			order[parent] = append(order[parent], key[i])
+		}
+	}
+
+	return order
+}
+
+// keys returns the keys of the table in declaration order
+func (o tomlKeyOrder) keys(path []string, table map[string]interface{}) []string {
+	var res []string
+	found := map[string]bool{}
+
+	for _, key := range o[strings.Join(path, "\x00")] {
+		if _, ok := table[key]; ok && !found[key] {
+			found[key] = true
+			res = append(res, key)
+		}
+	}
+
+	// not expected, but the keys the metadata doesn't know about shouldn't be lost
+	var rest []string
+	for key := range table {
+		if !found[key] {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+
+	return append(res, rest...)
+}
+
+// newTOMLNode converts decoded toml values into a *yaml.Node tree, tables keep the order they are declared in
+func newTOMLNode(value interface{}, path []string, order tomlKeyOrder) (*yaml.Node, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, key := range order.keys(path, v) {
+			child, err := newTOMLNode(v[key], append(path[:len(path):len(path)], key), order)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, newScalarNode(key), child)
+		}
+		return node, nil
+	case []map[string]interface{}:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, table := range v {
+			child, err := newTOMLNode(table, path, order)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+		return node, nil
+	case []interface{}:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range v {
+			child, err := newTOMLNode(item, path, order)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+		return node, nil
+	case string:
+		return newScalarNode(v), nil
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(v)}, nil
+	case int64:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.FormatInt(v, 10)}, nil
+	case float64:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float", Value: strconv.FormatFloat(v, 'f', -1, 64)}, nil
+	case time.Time:
+		return newScalarNode(v.Format(time.RFC3339Nano)), nil
+	}
+
+	return nil, fmt.Errorf("Unexpected toml value %v, type: %T", value, value)
+}
+
+var tomlErrorLineRegexp = regexp.MustCompile(`^Near line (\d+) \(last key parsed '[^']*'\): `)
+
+func unmarshallTOMLFile(filename string, node *yaml.Node) error {
+	tomlFile, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("tomlFile.Get err #%v", err)
+	}
+
+	return unmarshallTOML(tomlFile, filename, node)
+}
+
+func unmarshallTOML(content []byte, filename string, node *yaml.Node) error {
+	var data map[string]interface{}
+	metaData, err := toml.Decode(string(content), &data)
+	if err != nil {
+		configErr := &ConfigError{Filename: filename, Instruction: -1, Reason: err.Error()}
+		if m := tomlErrorLineRegexp.FindStringSubmatch(configErr.Reason); m != nil {
+			configErr.Line, _ = strconv.Atoi(m[1])
+			configErr.Reason = strings.TrimPrefix(configErr.Reason, m[0])
+		}
+		return ConfigErrors{configErr}
+	}
+
+	root, err := newTOMLNode(data, nil, newTOMLKeyOrder(metaData.Keys()))
+	if err != nil {
+		return err
+	}
+
+	*node = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}
+	return nil
+}
+
+// NewDockerFileDataFromTOMLField reads a TOML file and tries to extract Dockerfile data
+// from the specified targetField option, it accepts the same keys and targetField syntax as the YAML input, e.g.
+// --target-field ".services.api.dockerfile"
+// Stages are arrays of tables, e.g. [[stages.builder]], and keep the order they are declared in.
+// Problems in the config are reported together as ConfigErrors, TOML values have no position information.
+func NewDockerFileDataFromTOMLField(filename, targetField string) (*DockerfileData, error) {
+	node := yaml.Node{}
+
+	err := unmarshallTOMLFile(filename, &node)
+	if _, ok := err.(ConfigErrors); ok {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unmarshal: %v", err)
+	}
+
+	return newDockerFileDataFromYamlNode(&node, targetField, DecodeOptions{Filename: filename})
+}
+
+// NewDockerFileDataFromTOMLFile reads a TOML file and returns a *DockerfileData, see NewDockerFileDataFromTOMLField
+func NewDockerFileDataFromTOMLFile(filename string) (*DockerfileData, error) {
+	return NewDockerFileDataFromTOMLField(filename, "")
+}
+
+// NewDockerFileDataFromTOMLReader reads TOML from r, e.g. os.Stdin, and extracts Dockerfile data from the targetField,
+// an empty targetField means the whole document. ConfigErrors returned by it have no Filename, the included files are
+// relative to the working directory.
+func NewDockerFileDataFromTOMLReader(r io.Reader, targetField string) (*DockerfileData, error) {
+	return NewDockerFileDataFromTOMLReaderWithOptions(r, targetField, DecodeOptions{})
+}
+
+// NewDockerFileDataFromTOMLReaderWithOptions works like NewDockerFileDataFromTOMLReader with the given DecodeOptions,
+// e.g. the variables of dfg generate --set.
+func NewDockerFileDataFromTOMLReaderWithOptions(r io.Reader, targetField string, opts DecodeOptions) (*DockerfileData, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Unmarshal: tomlReader.Read err #%v", err)
+	}
+
+	node := yaml.Node{}
+	if err := unmarshallTOML(content, opts.Filename, &node); err != nil {
+		return nil, err
+	}
+
+	return newDockerFileDataFromYamlNode(&node, targetField, opts)
+}
@@ -0,0 +1,69 @@
+package dockerfilegenerator
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestTOMLRendering(t *testing.T) {
+	data, err := NewDockerFileDataFromTOMLFile("./example-input-files/test-input.toml")
+	assert.NoError(t, err)
+
+	output := &bytes.Buffer{}
+	err = NewDockerfileTemplate(data).Render(output)
+	assert.NoError(t, err)
+
+	assert.Equal(t, expectedGenericOutput, output.String())
+}
+
+func TestTOMLRenderingOnbuild(t *testing.T) {
+	data, err := NewDockerFileDataFromTOMLFile("./example-input-files/test-input-with-onbuild.toml")
+	assert.NoError(t, err)
+
+	output := &bytes.Buffer{}
+	err = NewDockerfileTemplate(data).Render(output)
+	assert.NoError(t, err)
+
+	assert.Equal(t, expectedOnbuildOutput, output.String())
+}
+
+func TestTOMLRenderingTargetField(t *testing.T) {
+	data, err := NewDockerFileDataFromTOMLField("./example-input-files/test-input-with-target-key.toml", ".services.api.dockerfile")
+	assert.NoError(t, err)
+
+	output := &bytes.Buffer{}
+	err = NewDockerfileTemplate(data).Render(output)
+	assert.NoError(t, err)
+
+	// stages keep the declaration order, not the alphabetical one
+	expectedOutput := `FROM kstaken/apache2 as zfinal
+RUN apt-get update && apt-get clean && rm -rf /var/lib/apt/lists/*
+
+FROM golang:1.13 as builder
+
+`
+	assert.Equal(t, expectedOutput, output.String())
+}
+
+func TestTOMLRenderingFail(t *testing.T) {
+	_, err := NewDockerFileDataFromTOMLFile("./example-input-files/invalid-input.toml")
+	assert.EqualError(t, err, "./example-input-files/invalid-input.toml:8: expected a comma or array terminator ']', but got '}' instead")
+
+	_, err = NewDockerFileDataFromTOMLFile("non-existent.toml")
+	assert.EqualError(t, err, "Unmarshal: tomlFile.Get err #open non-existent.toml: no such file or directory")
+}
+
+func TestTOMLReader(t *testing.T) {
+	data, err := NewDockerFileDataFromTOMLReader(strings.NewReader("[[stages.final]]\nfrom = { image = \"alpine\" }\n"), "")
+	assert.NoError(t, err)
+
+	output := &bytes.Buffer{}
+	err = NewDockerfileTemplate(data).Render(output)
+	assert.NoError(t, err)
+	assert.Equal(t, "FROM alpine as final\n\n", output.String())
+
+	_, err = NewDockerFileDataFromTOMLReader(strings.NewReader("stages:"), "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,174 @@
+package dockerfilegenerator
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// varReferenceRegexp matches ${{ ... }} references, a leading $ escapes them, e.g. $${{ .vars.a }}
+	varReferenceRegexp = regexp.MustCompile(`\$?\$\{\{(.*?)\}\}`)
+	varPathRegexp      = regexp.MustCompile(`^\s*\.vars\.([A-Za-z_][A-Za-z0-9_]*)\s*$`)
+	varNameRegexp      = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// ValidateVarName checks that a variable can be referenced as ${{ .vars.<name> }}
+func ValidateVarName(name string) error {
+	if !varNameRegexp.MatchString(name) {
+		return fmt.Errorf("Invalid variable name %q, expected letters, digits and _, e.g. goVersion", name)
+	}
+
+	return nil
+}
+
+// decodeVarsNode decodes the vars map, the values are used as they are written
+func decodeVarsNode(node *yaml.Node) (map[string]string, ConfigErrors) {
+	if node.Kind != yaml.MappingNode {
+		return nil, ConfigErrors{newConfigError(node, "Vars should be a map of names to values, e.g. goVersion: \"1.13\"")}
+	}
+
+	vars := map[string]string{}
+	var errs ConfigErrors
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+
+		if err := ValidateVarName(keyNode.Value); err != nil {
+			errs = append(errs, newConfigError(keyNode, "%v", err))
+			continue
+		}
+
+		if valueNode.Kind != yaml.ScalarNode {
+			errs = append(errs, newConfigError(valueNode, "Variable %q should be a string", keyNode.Value))
+			continue
+		}
+
+		vars[keyNode.Value] = valueNode.Value
+	}
+
+	return vars, errs
+}
+
+// resolveVars replaces the variable references in the scalar values of the node and its children,
+// mapping keys are left as they are
+func resolveVars(node *yaml.Node, vars map[string]string) ConfigErrors {
+	var errs ConfigErrors
+
+	switch node.Kind {
+	case yaml.ScalarNode:
+		value, err := interpolate(node.Value, vars)
+		if err != nil {
+			return ConfigErrors{newConfigError(node, "%v", err)}
+		}
+		node.Value = value
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			errs = append(errs, resolveVars(node.Content[i], vars)...)
+		}
+	case yaml.SequenceNode, yaml.DocumentNode:
+		for _, child := range node.Content {
+			errs = append(errs, resolveVars(child, vars)...)
+		}
+	}
+
+	return errs
+}
+
+// interpolate replaces ${{ .vars.<name> }} with the value of the variable, $${{ is kept as a literal ${{.
+// Dockerfile variables such as ${VERSION} aren't references and are kept as they are.
+func interpolate(value string, vars map[string]string) (string, error) {
+	var err error
+
+	res := varReferenceRegexp.ReplaceAllStringFunc(value, func(reference string) string {
+		if strings.HasPrefix(reference, "$$") {
+			return reference[1:]
+		}
+
+		m := varPathRegexp.FindStringSubmatch(varReferenceRegexp.FindStringSubmatch(reference)[1])
+		if m == nil {
+			if err == nil {
+				err = fmt.Errorf("Invalid variable reference %q, expected ${{ .vars.<name> }}, use $${{ for a literal ${{", reference)
+			}
+			return reference
+		}
+
+		v, ok := vars[m[1]]
+		if !ok && err == nil {
+			err = fmt.Errorf("Undefined variable %q%s", m[1], definedVars(vars))
+		}
+
+		return v
+	})
+
+	return res, err
+}
+
+// escapeVars escapes the text that would be read as a variable reference, the marshalled values are decoded as they are
+func escapeVars(value string) string {
+	return varReferenceRegexp.ReplaceAllStringFunc(value, func(reference string) string {
+		return "$" + reference
+	})
+}
+
+// definedVars lists the variable names for the undefined variable errors
+func definedVars(vars map[string]string) string {
+	if len(vars) == 0 {
+		return ", no variables are defined under vars"
+	}
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return fmt.Sprintf(", defined variables are %s", strings.Join(names, ", "))
+}
+
+// resolveTargetVars resolves the references in every key of the target node next to vars and expands the loops of
+// the stages, it returns the variables, the ones of the vars key are overridden by the given ones
+func resolveTargetVars(targetNode *yaml.Node, overrides map[string]string, origins nodeOrigins) (map[string]string, ConfigErrors) {
+	vars := map[string]string{}
+
+	if varsNode := getMappingValueNode(targetNode, "vars"); varsNode != nil {
+		decoded, errs := decodeVarsNode(varsNode)
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		vars = decoded
+	}
+
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs ConfigErrors
+	for _, name := range names {
+		if err := ValidateVarName(name); err != nil {
+			errs = append(errs, newConfigError(nil, "%v", err))
+			continue
+		}
+		vars[name] = overrides[name]
+	}
+
+	if len(errs) > 0 || targetNode.Kind != yaml.MappingNode {
+		return vars, errs
+	}
+
+	for i := 0; i+1 < len(targetNode.Content); i += 2 {
+		switch targetNode.Content[i].Value {
+		case "vars":
+		case "stages":
+			errs = append(errs, resolveStagesVars(targetNode.Content[i+1], vars, origins)...)
+		default:
+			errs = append(errs, resolveVars(targetNode.Content[i+1], vars)...)
+		}
+	}
+
+	return vars, errs
+}
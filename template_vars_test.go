@@ -0,0 +1,89 @@
+package dockerfilegenerator
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"strings"
+	"testing"
+)
+
+func TestVars(t *testing.T) {
+	input := `
+vars:
+  goVersion: "1.13"
+  registry: ghcr.io/org
+stages:
+  builder:
+    - from:
+        image: golang:${{ .vars.goVersion }}-alpine
+    - run:
+        params: ["echo ${VERSION} ${{.vars.goVersion}} $${{ .vars.goVersion }}"]
+  final:
+    - from:
+        image: ${{ .vars.registry }}/app:${{ .vars.tag }}
+`
+
+	data, err := NewDockerFileDataFromYamlReaderWithOptions(strings.NewReader(input), "", DecodeOptions{Vars: map[string]string{"tag": "v2", "goVersion": "1.14"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Instruction{
+		From{Image: "golang:1.14-alpine"},
+		RunCommand{Params: []string{"echo ${VERSION} 1.14 ${{ .vars.goVersion }}"}, RunForm: ShellForm},
+	}, data.Stages[0].Instructions)
+	assert.Equal(t, []Instruction{From{Image: "ghcr.io/org/app:v2"}}, data.Stages[1].Instructions)
+
+	_, err = NewDockerFileDataFromYamlReader(strings.NewReader(input), "")
+	assert.EqualError(t, err, `13:16: Undefined variable "tag", defined variables are goVersion, registry`)
+
+	_, err = NewDockerFileDataFromYamlReader(strings.NewReader(`
+stages:
+  final:
+    - from:
+        image: ${{ .vars.image }}
+    - workdir:
+        dir: ${{ vars.dir }}
+`), "")
+	assert.EqualError(t, err, `5:16: Undefined variable "image", no variables are defined under vars
+7:14: Invalid variable reference "${{ vars.dir }}", expected ${{ .vars.<name> }}, use $${{ for a literal ${{`)
+
+	_, err = NewDockerFileDataFromYamlReader(strings.NewReader("vars:\n  go-version: 1\n  list: [a]\nstages: {}\n"), "")
+	assert.EqualError(t, err, `2:3: Invalid variable name "go-version", expected letters, digits and _, e.g. goVersion
+3:9: Variable "list" should be a string`)
+
+	_, err = NewDockerFileDataFromYamlReader(strings.NewReader("vars: [a]\nstages: {}\n"), "")
+	assert.EqualError(t, err, `1:7: Vars should be a map of names to values, e.g. goVersion: "1.13"`)
+
+	_, err = NewDockerFileDataFromYamlReaderWithOptions(strings.NewReader("stages: {}\n"), "", DecodeOptions{Vars: map[string]string{"a.b": "c"}})
+	assert.EqualError(t, err, `Invalid variable name "a.b", expected letters, digits and _, e.g. goVersion`)
+}
+
+func TestVarsInputFormats(t *testing.T) {
+	expected := []Instruction{From{Image: "golang:1.13"}}
+
+	data, err := NewDockerFileDataFromJSONReaderWithOptions(strings.NewReader(
+		`{"vars": {"goVersion": "1.13"}, "stages": {"final": [{"from": {"image": "golang:${{ .vars.goVersion }}"}}]}}`,
+	), "", DecodeOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, data.Stages[0].Instructions)
+
+	data, err = NewDockerFileDataFromTOMLReaderWithOptions(strings.NewReader(`
+[[stages.final]]
+from = { image = "golang:${{ .vars.goVersion }}" }
+`), "", DecodeOptions{Vars: map[string]string{"goVersion": "1.13"}})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, data.Stages[0].Instructions)
+}
+
+func TestMarshalEscapesVars(t *testing.T) {
+	data := &DockerfileData{Stages: []Stage{NewStage("final",
+		From{Image: "alpine"},
+		RunCommand{Params: []string{"echo ${{ github.sha }} $${{ .vars.a }}"}, RunForm: ShellForm},
+	)}}
+
+	out, err := yaml.Marshal(data)
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), "echo $${{ github.sha }} $$${{ .vars.a }}")
+
+	decoded, err := NewDockerFileDataFromYamlReader(strings.NewReader(string(out)), "")
+	assert.NoError(t, err)
+	assert.Equal(t, data.Stages[0].Instructions, decoded.Stages[0].Instructions)
+}
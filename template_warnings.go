@@ -0,0 +1,76 @@
+package dockerfilegenerator
+
+import (
+	"strings"
+)
+
+// warner is implemented by the instructions that can be valid but most likely not what was intended
+type warner interface {
+	warnings() []string
+}
+
+// Warnings returns the instructions that render fine but should be reconsidered, e.g. an ADD where COPY would do,
+// or that use features the given syntax directive doesn't support.
+// They are in the same form as ConfigErrors so they can be reported the same way, without failing the generation.
+func (d *DockerfileData) Warnings() ConfigErrors {
+	var res ConfigErrors
+
+	for i, stage := range d.Stages {
+		for j, instruction := range stage.renderInstructions(d.ScriptForm) {
+			var warnings []string
+
+			if w, ok := instruction.(warner); ok {
+				warnings = append(warnings, w.warnings()...)
+			}
+
+			switch v := instruction.(type) {
+			case CopyCommand:
+				warnings = append(warnings, d.copyFromWarning("COPY --from", v.From)...)
+			case RunCommand:
+				for _, mount := range v.Mounts {
+					warnings = append(warnings, d.copyFromWarning("RUN --mount from", mount.From)...)
+				}
+			}
+
+			if u, ok := instruction.(syntaxUser); ok && d.Directives.Syntax != "" {
+				warnings = append(warnings, syntaxWarnings(d.Directives.Syntax, u)...)
+			}
+
+			for _, warning := range warnings {
+				res = append(res, &ConfigError{Stage: d.stageName(i), Instruction: j, Reason: warning})
+			}
+		}
+	}
+
+	return res
+}
+
+// addOnlySourcePrefixes are the remote sources only ADD can fetch
+var addOnlySourcePrefixes = []string{"http://", "https://", "git@", "git://", "ssh://"}
+
+// addOnlySourceSuffixes are the local archives ADD extracts and the git repositories it clones
+var addOnlySourceSuffixes = []string{".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".tbz", ".tar.xz", ".txz", ".tar.zst", ".git"}
+
+func (a Add) warnings() []string {
+	if a.Checksum != "" || a.KeepGitDir {
+		return nil
+	}
+
+	for _, source := range a.Sources {
+		lower := strings.ToLower(source)
+
+		for _, prefix := range addOnlySourcePrefixes {
+			if strings.HasPrefix(lower, prefix) {
+				return nil
+			}
+		}
+
+		for _, suffix := range addOnlySourceSuffixes {
+			if strings.HasSuffix(lower, suffix) {
+				return nil
+			}
+		}
+	}
+
+	return []string{"ADD is used for plain local files, COPY is preferred unless a remote source or an archive is added"}
+}
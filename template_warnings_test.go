@@ -0,0 +1,47 @@
+package dockerfilegenerator
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestWarnings(t *testing.T) {
+	data := &DockerfileData{
+		Stages: []Stage{
+			NewStage("final",
+				From{Image: "alpine"},
+				Add{Sources: []string{"app.go", "go.mod"}, Destination: "/app/"},
+				Add{Sources: []string{"https://example.com/app.conf"}, Destination: "/etc/"},
+				Add{Sources: []string{"rootfs.tar.xz"}, Destination: "/"},
+				Add{Sources: []string{"app.bin"}, Destination: "/app", Checksum: "sha256:abc"},
+				CopyCommand{Sources: []string{"app.go"}, Destination: "/app/"},
+			),
+		},
+	}
+
+	assert.EqualError(t, data.Warnings(), "stages.final[1]: ADD is used for plain local files, COPY is preferred unless a remote source or an archive is added")
+
+	data.Stages[0].Instructions = data.Stages[0].Instructions[2:]
+	assert.Empty(t, data.Warnings())
+}
+
+func TestCopyFromWarnings(t *testing.T) {
+	data := &DockerfileData{
+		Stages: []Stage{
+			NewStage("builder", From{Image: "golang"}),
+			NewStage("final",
+				From{Image: "alpine"},
+				CopyCommand{Sources: []string{"/app"}, Destination: "/app", From: "builder"},
+				CopyCommand{Sources: []string{"/app"}, Destination: "/app", From: "buidler"},
+				CopyCommand{Sources: []string{"/etc/nginx"}, Destination: "/etc/nginx", From: "nginx:latest"},
+				CopyCommand{Sources: []string{"/bin"}, Destination: "/bin", From: "${BUILDER}"},
+				CopyCommand{Sources: []string{"/bin"}, Destination: "/bin", From: "0"},
+				RunCommand{Params: []string{"ls"}, Mounts: []Mount{{Type: BindMount, From: "buildr", Target: "/app"}}},
+			),
+		},
+	}
+
+	assert.NoError(t, data.Validate())
+	assert.EqualError(t, data.Warnings(), `stages.final[2]: COPY --from=buidler matches no stage and is pulled as the image buidler:latest, use the tag if the image is intended
+stages.final[6]: RUN --mount from=buildr matches no stage and is pulled as the image buildr:latest, use the tag if the image is intended`)
+}
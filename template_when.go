@@ -0,0 +1,347 @@
+package dockerfilegenerator
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"unicode"
+)
+
+// whenParser evaluates a when expression such as env == "prod" && arch != "arm64" against the variables.
+// Operands are variable names, quoted strings, true and false; == and != compare them as strings, !, && and ||
+// expect booleans, a variable is a boolean when its value is true or false.
+type whenParser struct {
+	tokens []string
+	pos    int
+	vars   map[string]string
+}
+
+var whenOperators = map[string]bool{"&&": true, "||": true, "==": true, "!=": true}
+
+// whenValue is a string operand or the boolean result of an operator
+type whenValue struct {
+	text      string
+	boolean   bool
+	isBoolean bool
+}
+
+// evaluateWhen returns whether the instruction or the stage with the expression is kept
+func evaluateWhen(expression string, vars map[string]string) (bool, error) {
+	tokens, err := tokenizeWhen(expression)
+	if err != nil {
+		return false, fmt.Errorf("Invalid when expression %q: %v", expression, err)
+	}
+
+	p := &whenParser{tokens: tokens, vars: vars}
+	value, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %s", p.tokens[p.pos])
+	}
+
+	var keep bool
+	if err == nil {
+		keep, err = value.toBool()
+	}
+	if err != nil {
+		return false, fmt.Errorf("Invalid when expression %q: %v", expression, err)
+	}
+
+	return keep, nil
+}
+
+// tokenizeWhen splits the expression into operators, parentheses, quoted strings and names
+func tokenizeWhen(expression string) ([]string, error) {
+	var tokens []string
+	chars := []rune(expression)
+
+	for i := 0; i < len(chars); {
+		char := chars[i]
+
+		switch {
+		case unicode.IsSpace(char):
+			i++
+		case char == '(' || char == ')':
+			tokens = append(tokens, string(char))
+			i++
+		case i+1 < len(chars) && whenOperators[string(chars[i:i+2])]:
+			tokens = append(tokens, string(chars[i:i+2]))
+			i += 2
+		case char == '!':
+			tokens = append(tokens, "!")
+			i++
+		case char == '"' || char == '\'':
+			end := i + 1
+			for end < len(chars) && chars[end] != char {
+				end++
+			}
+			if end == len(chars) {
+				return nil, fmt.Errorf("the string at position %d isn't terminated", i+1)
+			}
+			tokens = append(tokens, string(chars[i:end+1]))
+			i = end + 1
+		case char == '_' || unicode.IsLetter(char) || unicode.IsDigit(char):
+			end := i
+			for end < len(chars) && (chars[end] == '_' || unicode.IsLetter(chars[end]) || unicode.IsDigit(chars[end])) {
+				end++
+			}
+			tokens = append(tokens, string(chars[i:end]))
+			i = end
+		default:
+			return nil, fmt.Errorf("unexpected %q at position %d", char, i+1)
+		}
+	}
+
+	if len(tokens) == 0 {
+		return nil, errors.New("the expression is empty")
+	}
+
+	return tokens, nil
+}
+
+func (p *whenParser) next() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+
+	return ""
+}
+
+func (p *whenParser) parseOr() (whenValue, error) {
+	return p.parseBinary("||", p.parseAnd, func(a, b bool) bool { return a || b })
+}
+
+func (p *whenParser) parseAnd() (whenValue, error) {
+	return p.parseBinary("&&", p.parseUnary, func(a, b bool) bool { return a && b })
+}
+
+// parseBinary parses the operands of a left-associative boolean operator
+func (p *whenParser) parseBinary(operator string, operand func() (whenValue, error), apply func(a, b bool) bool) (whenValue, error) {
+	left, err := operand()
+	if err != nil {
+		return whenValue{}, err
+	}
+
+	for p.next() == operator {
+		p.pos++
+
+		right, err := operand()
+		if err != nil {
+			return whenValue{}, err
+		}
+
+		a, err := left.toBool()
+		if err != nil {
+			return whenValue{}, err
+		}
+
+		b, err := right.toBool()
+		if err != nil {
+			return whenValue{}, err
+		}
+
+		left = whenValue{boolean: apply(a, b), isBoolean: true}
+	}
+
+	return left, nil
+}
+
+func (p *whenParser) parseUnary() (whenValue, error) {
+	if p.next() != "!" {
+		return p.parseComparison()
+	}
+	p.pos++
+
+	value, err := p.parseUnary()
+	if err != nil {
+		return whenValue{}, err
+	}
+
+	b, err := value.toBool()
+	if err != nil {
+		return whenValue{}, err
+	}
+
+	return whenValue{boolean: !b, isBoolean: true}, nil
+}
+
+func (p *whenParser) parseComparison() (whenValue, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return whenValue{}, err
+	}
+
+	operator := p.next()
+	if operator != "==" && operator != "!=" {
+		return left, nil
+	}
+	p.pos++
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return whenValue{}, err
+	}
+
+	equal := left.String() == right.String()
+	return whenValue{boolean: equal == (operator == "=="), isBoolean: true}, nil
+}
+
+func (p *whenParser) parseOperand() (whenValue, error) {
+	token := p.next()
+	p.pos++
+
+	switch {
+	case token == "":
+		return whenValue{}, errors.New("unexpected end of the expression")
+	case token == "(":
+		value, err := p.parseOr()
+		if err != nil {
+			return whenValue{}, err
+		}
+
+		if p.next() != ")" {
+			return whenValue{}, errors.New("missing )")
+		}
+		p.pos++
+
+		return value, nil
+	case token == "true" || token == "false":
+		return whenValue{boolean: token == "true", isBoolean: true}, nil
+	case token[0] == '"' || token[0] == '\'':
+		return whenValue{text: token[1 : len(token)-1]}, nil
+	case varNameRegexp.MatchString(token):
+		value, ok := p.vars[token]
+		if !ok {
+			return whenValue{}, fmt.Errorf("undefined variable %q%s", token, definedVars(p.vars))
+		}
+
+		return whenValue{text: value}, nil
+	}
+
+	return whenValue{}, fmt.Errorf("unexpected %s", token)
+}
+
+// toBool returns a boolean, a string is one when it's true or false
+func (v whenValue) toBool() (bool, error) {
+	if v.isBoolean {
+		return v.boolean, nil
+	}
+
+	switch v.text {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+
+	return false, fmt.Errorf("%q isn't a boolean, compare it with == or !=", v.text)
+}
+
+// String returns the text of the value, booleans are compared as true and false
+func (v whenValue) String() string {
+	if v.isBoolean {
+		return fmt.Sprintf("%t", v.boolean)
+	}
+
+	return v.text
+}
+
+// applyWhen drops the stages and the instructions whose when expression is false and removes the when keys,
+// a stage has it next to its instructions and an instruction next to its fields, e.g. run: {params: [...], when: ...}
+func applyWhen(targetNode *yaml.Node, vars map[string]string) ConfigErrors {
+	stagesNode := getMappingValueNode(targetNode, "stages")
+	if stagesNode == nil || stagesNode.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var errs ConfigErrors
+	var content []*yaml.Node
+
+	for i := 0; i+1 < len(stagesNode.Content); i += 2 {
+		keyNode, stageNode := stagesNode.Content[i], stagesNode.Content[i+1]
+
+		keep, stageErrs := applyStageWhen(stageNode, vars)
+		for _, stageErr := range stageErrs {
+			stageErr.Stage = keyNode.Value
+		}
+		errs = append(errs, stageErrs...)
+
+		if keep {
+			content = append(content, keyNode, stageNode)
+		}
+	}
+
+	stagesNode.Content = content
+
+	return errs
+}
+
+// applyStageWhen returns whether the stage is kept and drops its instructions whose when expression is false
+func applyStageWhen(stageNode *yaml.Node, vars map[string]string) (bool, ConfigErrors) {
+	instructionsNode := stageNode
+
+	if stageNode.Kind == yaml.MappingNode {
+		keep, err := evaluateWhenKey(stageNode, vars)
+		if err != nil {
+			return false, ConfigErrors{err}
+		}
+		if !keep {
+			return false, nil
+		}
+
+		instructionsNode = getMappingValueNode(stageNode, "instructions")
+	}
+
+	if instructionsNode == nil || instructionsNode.Kind != yaml.SequenceNode {
+		return true, nil
+	}
+
+	var errs ConfigErrors
+	var content []*yaml.Node
+
+	for i, instructionNode := range instructionsNode.Content {
+		keep := true
+
+		// the when key is next to the fields of the instruction, e.g. run: {params: [...], when: ...}
+		if instructionNode.Kind == yaml.MappingNode && len(instructionNode.Content) == 2 && instructionNode.Content[1].Kind == yaml.MappingNode {
+			var err *ConfigError
+			keep, err = evaluateWhenKey(instructionNode.Content[1], vars)
+			if err != nil {
+				err.Instruction = i
+				errs = append(errs, err)
+			}
+		}
+
+		if keep {
+			content = append(content, instructionNode)
+		}
+	}
+
+	instructionsNode.Content = content
+
+	return true, errs
+}
+
+// evaluateWhenKey removes the when key of the mapping node and evaluates it, a node without one is kept
+func evaluateWhenKey(node *yaml.Node, vars map[string]string) (bool, *ConfigError) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != "when" {
+			continue
+		}
+
+		whenNode := node.Content[i+1]
+		node.Content = append(node.Content[:i:i], node.Content[i+2:]...)
+
+		if whenNode.Kind != yaml.ScalarNode {
+			return false, newConfigError(whenNode, "when should be an expression, e.g. env == \"prod\"")
+		}
+
+		keep, err := evaluateWhen(whenNode.Value, vars)
+		if err != nil {
+			return false, newConfigError(whenNode, "%v", err)
+		}
+
+		return keep, nil
+	}
+
+	return true, nil
+}
@@ -0,0 +1,93 @@
+package dockerfilegenerator
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestEvaluateWhen(t *testing.T) {
+	vars := map[string]string{"env": "prod", "arch": "amd64", "debug": "true"}
+
+	for expression, expected := range map[string]bool{
+		`env == "prod" && arch != "arm64"`: true,
+		`env == 'dev' || debug`:            true,
+		`!debug`:                           false,
+		`!(env == "prod")`:                 false,
+		`debug == true`:                    true,
+		`false && false || true`:           true,
+		`false && (false || true)`:         false,
+	} {
+		keep, err := evaluateWhen(expression, vars)
+		assert.NoError(t, err, expression)
+		assert.Equal(t, expected, keep, expression)
+	}
+
+	for expression, expected := range map[string]string{
+		`env ==`:              "unexpected end of the expression",
+		`env = "prod"`:        "unexpected '=' at position 5",
+		`"prod`:               "the string at position 1 isn't terminated",
+		`region == "eu"`:      `undefined variable "region", defined variables are arch, debug, env`,
+		`env`:                 `"prod" isn't a boolean, compare it with == or !=`,
+		`(debug`:              "missing )",
+		`env == "prod" "dev"`: `unexpected "dev"`,
+		` `:                   "the expression is empty",
+	} {
+		_, err := evaluateWhen(expression, vars)
+		assert.EqualError(t, err, fmt.Sprintf("Invalid when expression %q: %s", expression, expected), expression)
+	}
+}
+
+func TestWhenYaml(t *testing.T) {
+	input := `
+vars:
+  env: dev
+stages:
+  builder:
+    - from:
+        image: golang
+    - run:
+        params: [go build]
+        when: env == "prod"
+    - run:
+        params: [go build -race]
+        when: env != "prod"
+  debug:
+    when: env == "dev" && debug
+    instructions:
+      - from:
+          image: alpine
+  final:
+    - from:
+        image: alpine
+`
+
+	data, err := NewDockerFileDataFromYamlReaderWithOptions(strings.NewReader(input), "", DecodeOptions{Vars: map[string]string{"debug": "false"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Stage{
+		{Name: "builder", Instructions: []Instruction{From{Image: "golang"}, RunCommand{Params: []string{"go build -race"}, RunForm: ShellForm}}},
+		{Name: "final", Instructions: []Instruction{From{Image: "alpine"}}},
+	}, data.Stages)
+
+	data, err = NewDockerFileDataFromYamlReaderWithOptions(strings.NewReader(input), "", DecodeOptions{Vars: map[string]string{"env": "prod", "debug": "true"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"builder", "final"}, []string{data.Stages[0].Name, data.Stages[1].Name})
+	assert.Equal(t, RunCommand{Params: []string{"go build"}, RunForm: ShellForm}, data.Stages[0].Instructions[1])
+
+	_, err = NewDockerFileDataFromYamlReader(strings.NewReader(input), "")
+	assert.EqualError(t, err, `15:11: stages.debug: Invalid when expression "env == \"dev\" && debug": undefined variable "debug", defined variables are env`)
+
+	_, err = NewDockerFileDataFromYamlReader(strings.NewReader(`
+stages:
+  final:
+    - from:
+        image: alpine
+        when: [a]
+    - user:
+        user: app
+        when: user == app
+`), "")
+	assert.EqualError(t, err, `6:15: stages.final[0]: when should be an expression, e.g. env == "prod"
+9:15: stages.final[1]: Invalid when expression "user == app": undefined variable "user", no variables are defined under vars`)
+}
@@ -16,35 +16,49 @@ type DockerfileDataYaml struct {
 type yamlMapStringInterface map[string]interface{}
 type yamlMapInterfaceInterface map[interface{}]interface{}
 
-func errorStringWithType(value interface{}) string {
-	return fmt.Sprintf("Yaml contains an expected data, caused by %[1]v, type: %[1]T\n", value)
+func errorWithType(value interface{}) error {
+	return fmt.Errorf("Yaml contains an unexpected data, caused by %[1]v, type: %[1]T", value)
 }
 
-func ensureMapInterfaceInterface(value interface{}) map[interface{}]interface{} {
-	v, ok := value.(map[interface{}]interface{})
-	if !ok {
-		panic(errorStringWithType(value))
+// ensureMapInterfaceInterface accepts both map types go-yaml produces, the map type depends on the value types
+func ensureMapInterfaceInterface(value interface{}) (map[interface{}]interface{}, error) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		return v, nil
+	case map[string]interface{}:
+		res := make(map[interface{}]interface{}, len(v))
+		for key, value := range v {
+			res[key] = value
+		}
+		return res, nil
 	}
 
-	return v
+	return nil, errorWithType(value)
 }
 
-func ensureMapStringInterface(value interface{}) map[string]interface{} {
-	v, ok := value.(map[string]interface{})
-	if !ok {
-		panic(errorStringWithType(value))
+// ensureMapStringInterface accepts both map types go-yaml produces, the map type depends on the value types
+func ensureMapStringInterface(value interface{}) (map[string]interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, nil
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			res[fmt.Sprintf("%v", key)] = value
+		}
+		return res, nil
 	}
 
-	return v
+	return nil, errorWithType(value)
 }
 
-func ensureMapString(value interface{}) string {
+func ensureMapString(value interface{}) (string, error) {
 	v, ok := value.(string)
 	if !ok {
-		panic(errorStringWithType(value))
+		return "", errorWithType(value)
 	}
 
-	return v
+	return v, nil
 }
 
 func convertMapIIToMapSS(mapInterface map[interface{}]interface{}) map[string]string {
@@ -72,9 +86,13 @@ func convertMapSIToMapSS(mapInterface yamlMapStringInterface) map[string]string
 }
 
 func convertSliceInterfaceToString(s interface{}) ([]string, error) {
+	if s == nil {
+		return nil, errors.New("the field is missing")
+	}
+
 	slice, ok := s.([]interface{})
 	if !ok {
-		return nil, errors.New("Invalid type, can't cast interface{} to []interface{}")
+		return nil, fmt.Errorf("expected a list, got %v", s)
 	}
 
 	res := make([]string, len(slice))
@@ -86,7 +104,7 @@ func convertSliceInterfaceToString(s interface{}) ([]string, error) {
 	return res, nil
 }
 
-func cleanUpFrom(value yamlMapStringInterface) From {
+func cleanUpFrom(value yamlMapStringInterface) (From, error) {
 	v := convertMapSIToMapSS(value)
 	var from From
 
@@ -94,11 +112,48 @@ func cleanUpFrom(value yamlMapStringInterface) From {
 		from.Image = v["image"]
 	}
 
+	// the image can be given in parts, e.g. {registry: ghcr.io, repository: org/app, tag: "1.0"}
+	if image, err := ensureMapStringInterface(value["image"]); err == nil {
+		reference, err := cleanUpImageReference(image)
+		if err != nil {
+			return from, fmt.Errorf("Failed to parse from instruction: %v", err)
+		}
+		from.Image = reference.String()
+	}
+
 	if v["as"] != "" {
 		from.As = v["as"]
 	}
 
-	return from
+	if v["platform"] != "" {
+		from.Platform = v["platform"]
+	}
+
+	return from, nil
+}
+
+func cleanUpImageReference(value yamlMapStringInterface) (ImageReference, error) {
+	v := convertMapSIToMapSS(value)
+	var ref ImageReference
+
+	for key := range v {
+		switch key {
+		case "registry", "repository", "tag", "digest":
+		default:
+			return ref, fmt.Errorf("Unknown image key %q, expected registry, repository, tag or digest", key)
+		}
+	}
+
+	ref.Registry = v["registry"]
+	ref.Repository = v["repository"]
+	ref.Tag = v["tag"]
+	ref.Digest = v["digest"]
+
+	if err := ref.Validate(); err != nil {
+		return ref, fmt.Errorf("Invalid image: %v", err)
+	}
+
+	return ref, nil
 }
 
 func cleanUpArg(value yamlMapInterfaceInterface) Arg {
@@ -121,6 +176,10 @@ func cleanUpArg(value yamlMapInterfaceInterface) Arg {
 		arg.EnvVariable = true
 	}
 
+	if v["literal"] == "true" || v["literal"] == "yes" {
+		arg.Literal = true
+	}
+
 	return arg
 }
 
@@ -136,6 +195,10 @@ func cleanUpLabel(value yamlMapStringInterface) Label {
 		l.Value = v["value"]
 	}
 
+	if v["literal"] == "true" || v["literal"] == "yes" {
+		l.Literal = true
+	}
+
 	return l
 }
 
@@ -154,15 +217,22 @@ func cleanUpVolume(value yamlMapStringInterface) Volume {
 	return vlm
 }
 
-func cleanUpRunCommand(value yamlMapInterfaceInterface) RunCommand {
+func cleanUpRunCommand(value yamlMapInterfaceInterface) (RunCommand, error) {
 	var r RunCommand
 	v := convertMapIIToMapSS(value)
 
-	params, err := convertSliceInterfaceToString(value["params"])
-	if err != nil {
-		panic("Failed to parse run instruction params!")
+	r.Script = v["script"]
+	r.Delimiter = v["delimiter"]
+	r.ScriptForm = ScriptForm(v["scriptForm"])
+
+	// params can be left out when the command is given as a script
+	if value["params"] != nil || r.Script == "" {
+		params, err := convertSliceInterfaceToString(value["params"])
+		if err != nil {
+			return r, fmt.Errorf("Failed to parse run instruction params: %v", err)
+		}
+		r.Params = params
 	}
-	r.Params = params
 
 	r.RunForm = RunCommandDefaultRunForm
 	if v["runForm"] == "exec" {
@@ -171,7 +241,59 @@ func cleanUpRunCommand(value yamlMapInterfaceInterface) RunCommand {
 		r.RunForm = ShellForm
 	}
 
-	return r
+	if value["mounts"] != nil {
+		mounts, ok := value["mounts"].([]interface{})
+		if !ok {
+			return r, fmt.Errorf("Failed to parse run instruction mounts: expected a list, got %v", value["mounts"])
+		}
+
+		for _, mountValue := range mounts {
+			mount, err := cleanUpMount(mountValue)
+			if err != nil {
+				return r, fmt.Errorf("Failed to parse run instruction mounts: %v", err)
+			}
+			r.Mounts = append(r.Mounts, mount)
+		}
+	}
+
+	if v["network"] != "" {
+		r.Network = v["network"]
+	}
+
+	if v["security"] != "" {
+		r.Security = v["security"]
+	}
+
+	if err := r.Validate(); err != nil {
+		return r, fmt.Errorf("Failed to parse run instruction: %v", err)
+	}
+
+	return r, nil
+}
+
+func cleanUpMount(value interface{}) (Mount, error) {
+	var m Mount
+
+	mapValue, err := ensureMapInterfaceInterface(value)
+	if err != nil {
+		return m, err
+	}
+	v := convertMapIIToMapSS(mapValue)
+
+	m.Type = MountType(v["type"])
+	m.ID = v["id"]
+	m.Target = v["target"]
+	m.Source = v["source"]
+	m.From = v["from"]
+	m.Sharing = v["sharing"]
+	m.Mode = v["mode"]
+	m.UID = v["uid"]
+	m.GID = v["gid"]
+	m.Size = v["size"]
+	m.ReadWrite = v["rw"] == "true" || v["rw"] == "yes"
+	m.Required = v["required"] == "true" || v["required"] == "yes"
+
+	return m, nil
 }
 
 func cleanUpEnvVariable(value yamlMapStringInterface) EnvVariable {
@@ -186,18 +308,29 @@ func cleanUpEnvVariable(value yamlMapStringInterface) EnvVariable {
 		e.Value = v["value"]
 	}
 
+	if v["literal"] == "true" || v["literal"] == "yes" {
+		e.Literal = true
+	}
+
 	return e
 }
 
-func cleanUpCopyCommand(value yamlMapInterfaceInterface) CopyCommand {
+func cleanUpCopyCommand(value yamlMapInterfaceInterface) (CopyCommand, error) {
 	var c CopyCommand
 	v := convertMapIIToMapSS(value)
 
-	params, err := convertSliceInterfaceToString(value["sources"])
-	if err != nil {
-		panic("Failed to parse copy instruction sources!")
+	c.Content = v["content"]
+	c.Delimiter = v["delimiter"]
+	c.ScriptForm = ScriptForm(v["scriptForm"])
+
+	// sources can be left out when the file is given inline as content
+	if value["sources"] != nil || c.Content == "" {
+		params, err := convertSliceInterfaceToString(value["sources"])
+		if err != nil {
+			return c, fmt.Errorf("Failed to parse copy instruction sources: %v", err)
+		}
+		c.Sources = params
 	}
-	c.Sources = params
 
 	if v["destination"] != "" {
 		c.Destination = v["destination"]
@@ -207,20 +340,85 @@ func cleanUpCopyCommand(value yamlMapInterfaceInterface) CopyCommand {
 		c.Chown = v["chown"]
 	}
 
+	if v["chmod"] != "" {
+		c.Chmod = v["chmod"]
+	}
+
 	if v["from"] != "" {
 		c.From = v["from"]
 	}
 
-	return c
+	if v["link"] == "true" || v["link"] == "yes" {
+		c.Link = true
+	}
+
+	if v["parents"] == "true" || v["parents"] == "yes" {
+		c.Parents = true
+	}
+
+	if value["exclude"] != nil {
+		exclude, err := convertSliceInterfaceToString(value["exclude"])
+		if err != nil {
+			return c, fmt.Errorf("Failed to parse copy instruction exclude: %v", err)
+		}
+		c.Exclude = exclude
+	}
+
+	if err := c.Validate(); err != nil {
+		return c, fmt.Errorf("Failed to parse copy instruction: %v", err)
+	}
+
+	return c, nil
 }
 
-func cleanUpCmd(value yamlMapInterfaceInterface) Cmd {
+func cleanUpAdd(value yamlMapInterfaceInterface) (Add, error) {
+	var a Add
+	v := convertMapIIToMapSS(value)
+
+	params, err := convertSliceInterfaceToString(value["sources"])
+	if err != nil {
+		return a, fmt.Errorf("Failed to parse add instruction sources: %v", err)
+	}
+	a.Sources = params
+
+	if v["destination"] != "" {
+		a.Destination = v["destination"]
+	}
+
+	if v["chown"] != "" {
+		a.Chown = v["chown"]
+	}
+
+	if v["chmod"] != "" {
+		a.Chmod = v["chmod"]
+	}
+
+	if v["checksum"] != "" {
+		a.Checksum = v["checksum"]
+	}
+
+	if v["keepGitDir"] == "true" || v["keepGitDir"] == "yes" {
+		a.KeepGitDir = true
+	}
+
+	if v["link"] == "true" || v["link"] == "yes" {
+		a.Link = true
+	}
+
+	if err := a.Validate(); err != nil {
+		return a, fmt.Errorf("Failed to parse add instruction: %v", err)
+	}
+
+	return a, nil
+}
+
+func cleanUpCmd(value yamlMapInterfaceInterface) (Cmd, error) {
 	var c Cmd
 	v := convertMapIIToMapSS(value)
 
 	params, err := convertSliceInterfaceToString(value["params"])
 	if err != nil {
-		panic("Failed to parse cmd instruction params!")
+		return c, fmt.Errorf("Failed to parse cmd instruction params: %v", err)
 	}
 	c.Params = params
 
@@ -231,16 +429,16 @@ func cleanUpCmd(value yamlMapInterfaceInterface) Cmd {
 		c.RunForm = ShellForm
 	}
 
-	return c
+	return c, nil
 }
 
-func cleanUpEntrypoint(value yamlMapInterfaceInterface) Entrypoint {
+func cleanUpEntrypoint(value yamlMapInterfaceInterface) (Entrypoint, error) {
 	var e Entrypoint
 	v := convertMapIIToMapSS(value)
 
 	params, err := convertSliceInterfaceToString(value["params"])
 	if err != nil {
-		panic("Failed to parse entrypoint instruction params!")
+		return e, fmt.Errorf("Failed to parse entrypoint instruction params: %v", err)
 	}
 	e.Params = params
 
@@ -251,43 +449,107 @@ func cleanUpEntrypoint(value yamlMapInterfaceInterface) Entrypoint {
 		e.RunForm = ShellForm
 	}
 
-	return e
+	return e, nil
 }
 
-func cleanUpOnbuild(value yamlMapInterfaceInterface) Onbuild {
+func cleanUpOnbuild(value yamlMapInterfaceInterface) (Onbuild, error) {
 	var o Onbuild
 
-	params, err := convertSliceInterfaceToString(value["params"])
-	if err != nil {
-		panic("Failed to parse onBuild instruction params!")
+	trigger := yamlMapInterfaceInterface{}
+	for key, v := range value {
+		if key != "comment" {
+			trigger[key] = v
+		}
+	}
+
+	// params are a free text trigger, any other key is the instruction run as the trigger, e.g. copy
+	if _, ok := trigger["params"]; ok || len(trigger) == 0 {
+		params, err := convertSliceInterfaceToString(value["params"])
+		if err != nil {
+			return o, fmt.Errorf("Failed to parse onBuild instruction params: %v", err)
+		}
+		o.Params = params
+	} else {
+		instruction, err := cleanUpMapII(trigger)
+		if err != nil {
+			return o, fmt.Errorf("Failed to parse onBuild instruction: %v", err)
+		}
+		o.Instruction = instruction
 	}
-	o.Params = params
 
-	return o
+	if err := o.validateTrigger(); err != nil {
+		return o, fmt.Errorf("Failed to parse onBuild instruction: %v", err)
+	}
+
+	return o, nil
 }
 
-func cleanUpHealthCheck(value yamlMapInterfaceInterface) HealthCheck {
+func cleanUpHealthCheck(value yamlMapInterfaceInterface) (HealthCheck, error) {
 	var h HealthCheck
+	v := convertMapIIToMapSS(value)
 
-	params, err := convertSliceInterfaceToString(value["params"])
-	if err != nil {
-		panic("Failed to parse healthCheck instruction params!")
+	// params can be left out when the health check is disabled
+	if value["params"] != nil || (v["none"] != "true" && v["none"] != "yes") {
+		params, err := convertSliceInterfaceToString(value["params"])
+		if err != nil {
+			return h, fmt.Errorf("Failed to parse healthCheck instruction params: %v", err)
+		}
+		h.Params = params
+	}
+
+	// params used to hold the options as well, e.g. [--interval=30s, CMD, curl, -f, http://localhost/]
+	if hasOptionParams(h.Params) {
+		var err error
+		h, err = healthCheckFromParams(h.Params)
+		if err != nil {
+			return h, fmt.Errorf("Failed to parse healthCheck instruction params: %v", err)
+		}
+	}
+
+	h.RunForm = HealthCheckDefaultRunForm
+	if v["runForm"] == "exec" {
+		h.RunForm = ExecForm
+	} else if v["runForm"] == "shell" {
+		h.RunForm = ShellForm
 	}
-	h.Params = params
 
-	return h
+	if v["none"] == "true" || v["none"] == "yes" {
+		h.None = true
+	}
+
+	for _, option := range [][2]string{
+		{"interval", "interval"},
+		{"timeout", "timeout"},
+		{"startPeriod", "start-period"},
+		{"startInterval", "start-interval"},
+		{"retries", "retries"},
+	} {
+		if v[option[0]] == "" {
+			continue
+		}
+
+		if err := h.setOption(option[1], v[option[0]]); err != nil {
+			return h, fmt.Errorf("Failed to parse healthCheck instruction: %v", err)
+		}
+	}
+
+	if err := h.Validate(); err != nil {
+		return h, fmt.Errorf("Failed to parse healthCheck instruction: %v", err)
+	}
+
+	return h, nil
 }
 
-func cleanUpShell(value yamlMapInterfaceInterface) Shell {
+func cleanUpShell(value yamlMapInterfaceInterface) (Shell, error) {
 	var s Shell
 
 	params, err := convertSliceInterfaceToString(value["params"])
 	if err != nil {
-		panic("Failed to parse shell instruction params!")
+		return s, fmt.Errorf("Failed to parse shell instruction params: %v", err)
 	}
 	s.Params = params
 
-	return s
+	return s, nil
 }
 
 func cleanUpWorkdir(value yamlMapStringInterface) Workdir {
@@ -301,6 +563,37 @@ func cleanUpWorkdir(value yamlMapStringInterface) Workdir {
 	return w
 }
 
+func cleanUpExpose(value yamlMapInterfaceInterface) (Expose, error) {
+	var e Expose
+
+	ports, err := convertSliceInterfaceToString(value["ports"])
+	if err != nil {
+		return e, fmt.Errorf("Failed to parse expose instruction ports: %v", err)
+	}
+	e.Ports = ports
+
+	if err := e.Validate(); err != nil {
+		return e, fmt.Errorf("Failed to parse expose instruction: %v", err)
+	}
+
+	return e, nil
+}
+
+func cleanUpStopSignal(value yamlMapStringInterface) (StopSignal, error) {
+	v := convertMapSIToMapSS(value)
+	var s StopSignal
+
+	if v["signal"] != "" {
+		s.Signal = v["signal"]
+	}
+
+	if err := s.Validate(); err != nil {
+		return s, fmt.Errorf("Failed to parse stopSignal instruction: %v", err)
+	}
+
+	return s, nil
+}
+
 func cleanUpUserString(value string) User {
 	return User{User: value}
 }
@@ -320,49 +613,60 @@ func cleanUpUserMap(value yamlMapStringInterface) User {
 	return u
 }
 
-func cleanUpInterfaceArray(in []interface{}) []Instruction {
-	result := make([]Instruction, len(in))
-	for i, v := range in {
-		result[i] = cleanUpMapValue(v)
-	}
-	return result
-}
-
-func cleanUpMapSI(in map[string]interface{}) Instruction {
+func cleanUpMapSI(in map[string]interface{}) (Instruction, error) {
 	for key, value := range in {
 		switch key {
 		case "user":
-			v := ensureMapString(value)
-			return cleanUpUserString(v)
+			v, err := ensureMapString(value)
+			if err != nil {
+				return nil, err
+			}
+			return cleanUpUserString(v), nil
+		case "comment":
+			v, err := ensureMapString(value)
+			if err != nil {
+				return nil, err
+			}
+			return Comment{Text: v}, nil
 		}
+
+		return nil, fmt.Errorf("Unknown instruction %q", key)
 	}
 
-	panic("Unknown instruction in yaml!")
+	return nil, errors.New("Empty instruction")
 }
 
-func cleanUpMapIISimpleInstructions(instructionName string, value interface{}) Instruction {
-	v := ensureMapStringInterface(value)
+func cleanUpMapIISimpleInstructions(instructionName string, value interface{}) (Instruction, error) {
+	v, err := ensureMapStringInterface(value)
+	if err != nil {
+		return nil, err
+	}
 
 	switch strings.ToLower(instructionName) {
 	case "from":
 		return cleanUpFrom(v)
 	case "label":
-		return cleanUpLabel(v)
+		return cleanUpLabel(v), nil
 	case "volume":
-		return cleanUpVolume(v)
+		return cleanUpVolume(v), nil
 	case "envvariable":
-		return cleanUpEnvVariable(v)
+		return cleanUpEnvVariable(v), nil
 	case "workdir":
-		return cleanUpWorkdir(v)
+		return cleanUpWorkdir(v), nil
 	case "user":
-		return cleanUpUserMap(v)
+		return cleanUpUserMap(v), nil
+	case "stopsignal":
+		return cleanUpStopSignal(v)
 	}
 
-	panic(errorStringWithType(value))
+	return cleanUpMapIIComplexInstructions(instructionName, value)
 }
 
-func cleanUpMapIIComplexInstructions(instructionName string, value interface{}) Instruction {
-	v := ensureMapInterfaceInterface(value)
+func cleanUpMapIIComplexInstructions(instructionName string, value interface{}) (Instruction, error) {
+	v, err := ensureMapInterfaceInterface(value)
+	if err != nil {
+		return nil, err
+	}
 
 	switch strings.ToLower(instructionName) {
 	case "healthcheck":
@@ -375,92 +679,531 @@ func cleanUpMapIIComplexInstructions(instructionName string, value interface{})
 		return cleanUpCmd(v)
 	case "copy":
 		return cleanUpCopyCommand(v)
+	case "add":
+		return cleanUpAdd(v)
+	case "expose":
+		return cleanUpExpose(v)
 	case "arg":
-		return cleanUpArg(v)
+		return cleanUpArg(v), nil
 	case "run":
 		return cleanUpRunCommand(v)
 	case "shell":
 		return cleanUpShell(v)
 	}
 
-	panic(errorStringWithType(value))
+	return nil, fmt.Errorf("Unknown instruction %q", instructionName)
 }
 
-func cleanUpMapII(in map[interface{}]interface{}) Instruction {
+func cleanUpMapII(in map[interface{}]interface{}) (Instruction, error) {
+	if len(in) != 1 {
+		return nil, fmt.Errorf("An instruction should be a map with a single key, found %d keys", len(in))
+	}
+
 	for key, value := range in {
+		name, ok := key.(string)
+		if !ok {
+			return nil, errorWithType(key)
+		}
 
 		switch value.(type) {
-		case map[string]interface{}:
-			key, ok := key.(string)
-			if !ok {
-				panic(errorStringWithType(key))
-			}
-			return cleanUpMapIISimpleInstructions(key, value)
-		case map[interface{}]interface{}:
-			key, ok := key.(string)
-			if !ok {
-				panic(errorStringWithType(key))
-			}
-			return cleanUpMapIIComplexInstructions(key, value)
+		case map[string]interface{}, map[interface{}]interface{}:
+			return cleanUpMapIISimpleInstructions(name, value)
+		case nil:
+			return nil, fmt.Errorf("Instruction %q has no value", name)
 		}
 
+		// scalar values such as `user: 1000` aren't decoded as strings
+		return cleanUpMapSI(map[string]interface{}{name: fmt.Sprintf("%v", value)})
 	}
 
-	panic("Unknown instruction in yaml!")
+	return nil, errors.New("Empty instruction")
 }
 
-func cleanUpMapValue(v interface{}) Instruction {
+func cleanUpMapValue(v interface{}) (Instruction, error) {
 	switch v := v.(type) {
 	case map[string]interface{}:
 		return cleanUpMapSI(v)
 	case map[interface{}]interface{}:
 		return cleanUpMapII(v)
 	default:
-		panic("Invalid instruction type in yaml!")
+		return nil, fmt.Errorf("Invalid instruction type in yaml, expected a map, got %v", v)
 	}
 }
 
+// convertNodeToInterface works like decoding into an interface{}, except that scalars keep their original text,
+// e.g. a version number like 1.10 isn't turned into a float
+func convertNodeToInterface(node *yaml.Node) interface{} {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil
+		}
+		return convertNodeToInterface(node.Content[0])
+	case yaml.AliasNode:
+		return convertNodeToInterface(node.Alias)
+	case yaml.SequenceNode:
+		res := make([]interface{}, len(node.Content))
+		for i, item := range node.Content {
+			res[i] = convertNodeToInterface(item)
+		}
+		return res
+	case yaml.MappingNode:
+		res := make(map[interface{}]interface{}, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if isMergeKeyNode(node.Content[i]) {
+				continue
+			}
+			res[node.Content[i].Value] = convertNodeToInterface(node.Content[i+1])
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if isMergeKeyNode(node.Content[i]) {
+				mergeNodeIntoMap(res, node.Content[i+1])
+			}
+		}
+		return res
+	}
+
+	if node.ShortTag() == "!!null" {
+		return nil
+	}
+
+	return node.Value
+}
+
+// isMergeKeyNode reports whether the key is a YAML merge key, e.g. <<: *defaults
+func isMergeKeyNode(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Value == "<<" && (node.Tag == "" || node.Tag == "!" || node.ShortTag() == "!!merge")
+}
+
+// mergeNodeIntoMap adds the keys of a merged map, or of a sequence of maps, that the map doesn't have yet,
+// the keys of the map win over the merged ones and the first maps of a sequence win over the next ones
+func mergeNodeIntoMap(res map[interface{}]interface{}, node *yaml.Node) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	var merged []*yaml.Node
+	switch node.Kind {
+	case yaml.MappingNode:
+		merged = []*yaml.Node{node}
+	case yaml.SequenceNode:
+		merged = node.Content
+	}
+
+	for _, mergedNode := range merged {
+		values, ok := convertNodeToInterface(mergedNode).(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+
+		for key, value := range values {
+			if _, ok := res[key]; !ok {
+				res[key] = value
+			}
+		}
+	}
+}
+
+// decodeStageNode decodes a stage, which is either a sequence of instructions or a map in the form of
+// {platform: <platform>, dependsOn: [<stage>], instructions: [<instructions>]}
+func decodeStageNode(node *yaml.Node) (Stage, ConfigErrors) {
+	if node.Kind == yaml.SequenceNode {
+		instructions, errs := decodeInstructionsNode(node)
+		return Stage{Instructions: instructions}, errs
+	}
+
+	if node.Kind != yaml.MappingNode {
+		return Stage{}, ConfigErrors{newConfigError(node, "A stage should be a sequence of instructions or a map with an 'instructions' key")}
+	}
+
+	var stage Stage
+	var errs ConfigErrors
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+
+		switch keyNode.Value {
+		case "platform":
+			if valueNode.Kind != yaml.ScalarNode {
+				errs = append(errs, newConfigError(valueNode, "Stage platform should be a string"))
+				continue
+			}
+			stage.Platform = valueNode.Value
+		case "comment":
+			if valueNode.Kind != yaml.ScalarNode {
+				errs = append(errs, newConfigError(valueNode, "Stage comment should be a string"))
+				continue
+			}
+			stage.Comment = valueNode.Value
+		case "dependsOn":
+			dependsOn, err := convertSliceInterfaceToString(convertNodeToInterface(valueNode))
+			if err != nil {
+				errs = append(errs, newConfigError(valueNode, "Failed to parse stage dependsOn: %v", err))
+				continue
+			}
+			stage.DependsOn = dependsOn
+		case "instructions":
+			if valueNode.Kind != yaml.SequenceNode {
+				errs = append(errs, newConfigError(valueNode, "Stage instructions should be a sequence of instructions"))
+				continue
+			}
+			instructions, instructionErrs := decodeInstructionsNode(valueNode)
+			errs = append(errs, instructionErrs...)
+			stage.Instructions = instructions
+		default:
+			errs = append(errs, newConfigError(keyNode, "Unknown stage key %q", keyNode.Value))
+		}
+	}
+
+	if getMappingValueNode(node, "instructions") == nil {
+		errs = append(errs, newConfigError(node, "Stage should contain an 'instructions' key"))
+	}
+
+	if len(errs) > 0 {
+		return Stage{}, errs
+	}
+
+	return stage, nil
+}
+
+// instructionNodeComment returns the comment key of the instruction, or the yaml comment above the instruction
+func instructionNodeComment(node *yaml.Node) string {
+	if node.Kind != yaml.MappingNode || len(node.Content) != 2 {
+		return yamlComment(node.HeadComment)
+	}
+
+	if valueNode := node.Content[1]; valueNode.Kind == yaml.MappingNode {
+		if commentNode := getMappingValueNode(valueNode, "comment"); commentNode != nil && commentNode.Kind == yaml.ScalarNode {
+			return commentNode.Value
+		}
+	}
+
+	// yaml.v3 keeps the comment above a sequence item on the first key of the item
+	if comment := node.Content[0].HeadComment; comment != "" {
+		return yamlComment(comment)
+	}
+
+	return yamlComment(node.HeadComment)
+}
+
+// decodeDirectivesNode decodes the directives map, the keys are the names of the directives
+func decodeDirectivesNode(node *yaml.Node) (Directives, ConfigErrors) {
+	if node.Kind != yaml.MappingNode {
+		return Directives{}, ConfigErrors{newConfigError(node, "Directives should be a map with syntax, escape or check keys")}
+	}
+
+	var directives Directives
+	var errs ConfigErrors
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+
+		if valueNode.Kind != yaml.ScalarNode {
+			errs = append(errs, newConfigError(valueNode, "Directive %s should be a string", keyNode.Value))
+			continue
+		}
+
+		switch keyNode.Value {
+		case "syntax":
+			directives.Syntax = valueNode.Value
+		case "escape":
+			directives.Escape = valueNode.Value
+		case "check":
+			directives.Check = valueNode.Value
+		default:
+			errs = append(errs, newConfigError(keyNode, "Unknown directive %q", keyNode.Value))
+		}
+	}
+
+	if len(errs) == 0 {
+		if err := directives.Validate(); err != nil {
+			errs = append(errs, newConfigError(node, "%v", err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return Directives{}, errs
+	}
+
+	return directives, nil
+}
+
+// decodePlatformsNode decodes the list of target platforms, every platform is validated
+func decodePlatformsNode(node *yaml.Node) ([]string, ConfigErrors) {
+	if node.Kind != yaml.SequenceNode {
+		return nil, ConfigErrors{newConfigError(node, "Platforms should be a list, e.g. [linux/amd64, linux/arm64]")}
+	}
+
+	var platforms []string
+	var errs ConfigErrors
+
+	for _, platformNode := range node.Content {
+		if platformNode.Kind != yaml.ScalarNode {
+			errs = append(errs, newConfigError(platformNode, "Platform should be a string"))
+			continue
+		}
+
+		if err := validatePlatform(platformNode.Value); err != nil {
+			errs = append(errs, newConfigError(platformNode, "%v", err))
+			continue
+		}
+
+		platforms = append(platforms, platformNode.Value)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return platforms, nil
+}
+
+// instructionFields are the fields of the instructions by their lower case name, the same keys MarshalYAML encodes
+var instructionFields = map[string][]string{
+	"from":        {"image", "as", "platform", "comment"},
+	"arg":         {"name", "value", "test", "envVariable", "literal", "comment"},
+	"label":       {"name", "value", "literal", "comment"},
+	"volume":      {"source", "destination", "comment"},
+	"run":         {"params", "runForm", "mounts", "network", "security", "script", "delimiter", "scriptForm", "comment"},
+	"envvariable": {"name", "value", "literal", "comment"},
+	"copy":        {"sources", "destination", "chown", "chmod", "from", "link", "parents", "exclude", "content", "delimiter", "scriptForm", "comment"},
+	"add":         {"sources", "destination", "chown", "chmod", "checksum", "keepGitDir", "link", "comment"},
+	"cmd":         {"params", "runForm", "comment"},
+	"entrypoint":  {"params", "runForm", "comment"},
+	"healthcheck": {"params", "runForm", "interval", "timeout", "startPeriod", "startInterval", "retries", "none", "comment"},
+	"shell":       {"params", "comment"},
+	"workdir":     {"dir", "comment"},
+	"expose":      {"ports", "comment"},
+	"stopsignal":  {"signal", "comment"},
+	"user":        {"user", "group", "comment"},
+}
+
+// mountFields are the fields of a mount of the run instruction
+var mountFields = []string{"type", "id", "target", "source", "from", "sharing", "mode", "uid", "gid", "size", "rw", "required"}
+
+// checkInstructionFields reports the unknown keys of an instruction at the key node, e.g. a misspelled destination.
+// Unknown instructions and values that aren't maps are left to the decoder, as are the keys merged in with <<.
+func checkInstructionFields(node *yaml.Node) ConfigErrors {
+	node = resolveAliasNode(node)
+	if node.Kind != yaml.MappingNode || len(node.Content) != 2 {
+		return nil
+	}
+
+	return checkFields(node.Content[0].Value, resolveAliasNode(node.Content[1]))
+}
+
+// checkFields reports the keys of the value of the named instruction that the instruction doesn't have
+func checkFields(name string, node *yaml.Node) ConfigErrors {
+	key := strings.ToLower(name)
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	if key == "onbuild" {
+		return checkOnbuildFields(name, node)
+	}
+
+	fields, ok := instructionFields[key]
+	if !ok {
+		return nil
+	}
+
+	errs := checkMappingFields(node, name, fields)
+
+	if key == "run" {
+		mountsNode := resolveAliasNode(getMappingValueNode(node, "mounts"))
+		if mountsNode != nil && mountsNode.Kind == yaml.SequenceNode {
+			for _, mountNode := range mountsNode.Content {
+				if mountNode = resolveAliasNode(mountNode); mountNode.Kind == yaml.MappingNode {
+					errs = append(errs, checkMappingFields(mountNode, "mount", mountFields)...)
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+// checkOnbuildFields checks the trigger of an onbuild instruction, either params or a single instruction key
+func checkOnbuildFields(name string, node *yaml.Node) ConfigErrors {
+	if getMappingValueNode(node, "params") != nil {
+		return checkMappingFields(node, name, []string{"params", "comment"})
+	}
+
+	var errs ConfigErrors
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if key := node.Content[i]; key.Value != "comment" && !isMergeKeyNode(key) {
+			errs = append(errs, checkFields(key.Value, resolveAliasNode(node.Content[i+1]))...)
+		}
+	}
+
+	return errs
+}
+
+// checkMappingFields reports the keys of the mapping node that aren't in fields, e.g. Unknown copy key "destinaton"
+func checkMappingFields(node *yaml.Node, name string, fields []string) ConfigErrors {
+	var errs ConfigErrors
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if isMergeKeyNode(key) || containsString(fields, key.Value) {
+			continue
+		}
+
+		errs = append(errs, newConfigError(key, "Unknown %s key %q, expected %s or %s", name, key.Value, strings.Join(fields[:len(fields)-1], ", "), fields[len(fields)-1]))
+	}
+
+	return errs
+}
+
+// decodeInstructionsNode decodes every instruction of a sequence node, an error is collected for each invalid instruction
+func decodeInstructionsNode(node *yaml.Node) ([]Instruction, ConfigErrors) {
+	var errs ConfigErrors
+	result := make([]Instruction, 0, len(node.Content))
+
+	for i, instructionNode := range node.Content {
+		if fieldErrs := checkInstructionFields(instructionNode); len(fieldErrs) > 0 {
+			for _, fieldErr := range fieldErrs {
+				fieldErr.Instruction = i
+			}
+			errs = append(errs, fieldErrs...)
+			continue
+		}
+
+		instruction, err := cleanUpMapValue(convertNodeToInterface(instructionNode))
+		if err != nil {
+			configErr := newConfigError(instructionNode, "%v", err)
+			configErr.Instruction = i
+			errs = append(errs, configErr)
+			continue
+		}
+
+		if comment := instructionNodeComment(instructionNode); comment != "" {
+			instruction = setInstructionComment(instruction, comment)
+		}
+
+		result = append(result, instruction)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return result, nil
+}
+
 func unmarshallYamlFile(filename string, node *yaml.Node) error {
 	yamlFile, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return fmt.Errorf("yamlFile.Get err #%v", err)
 	}
-	err = yaml.Unmarshal(yamlFile, node)
+
+	return unmarshallYaml(yamlFile, filename, node)
+}
+
+func unmarshallYaml(content []byte, filename string, node *yaml.Node) error {
+	err := yaml.Unmarshal(content, node)
 	if err != nil {
-		return fmt.Errorf("Unmarshal: %v", err)
+		configErr := newConfigErrorFromYaml(err)
+		configErr.Filename = filename
+		return ConfigErrors{configErr}
+	}
+
+	if len(node.Content) == 0 {
+		configErr := newConfigError(nil, "Yaml document is empty")
+		configErr.Filename = filename
+		return ConfigErrors{configErr}
+	}
+
+	return nil
+}
+
+// resolveAliasNode returns the node an alias points to, other nodes are returned as they are
+func resolveAliasNode(node *yaml.Node) *yaml.Node {
+	for node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	return node
+}
+
+// getMappingValueNode returns the value node of the given key in a mapping node, nil if the key doesn't exist
+func getMappingValueNode(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
 	}
 
 	return nil
 }
 
+// takeMappingValueNode removes the given key from a mapping node and returns its value node, nil if the key doesn't exist
+func takeMappingValueNode(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			value := node.Content[i+1]
+			node.Content = append(node.Content[:i:i], node.Content[i+2:]...)
+			return value
+		}
+	}
+
+	return nil
+}
+
+// setMappingValueNode replaces the value of the given key in a mapping node, the key is appended if it doesn't exist
+func setMappingValueNode(node *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1] = value
+			return
+		}
+	}
+
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+// cloneNode returns a deep copy of the node, nodeOrigins.clone keeps the included files of the copies
+func cloneNode(node *yaml.Node) *yaml.Node {
+	clone := *node
+	clone.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		clone.Content[i] = cloneNode(child)
+	}
+
+	return &clone
+}
+
 func getStagesOrderFromYamlNode(node *yaml.Node) ([]string, error) {
 	var stages []string
 
 	if node.Kind != yaml.MappingNode {
-		return nil, errors.New("Yaml should contain a map that contains 'stages' key!")
+		return nil, ConfigErrors{newConfigError(node, "Yaml should contain a map that contains 'stages' key")}
 	}
 
-	stagesKeyNode := node.Content[0]
-	if stagesKeyNode.Kind != yaml.ScalarNode {
-		return nil, errors.New("Yaml should contain a 'stages' key!")
+	stagesMapNode := getMappingValueNode(node, "stages")
+	if stagesMapNode == nil {
+		return nil, ConfigErrors{newConfigError(node, "Yaml should contain a 'stages' key")}
 	}
 
-	stagesMapNode := node.Content[1]
 	if stagesMapNode.Kind != yaml.MappingNode {
-		return nil, errors.New("Yaml should contain a 'stages' map that has stage names as keys!")
+		return nil, ConfigErrors{newConfigError(stagesMapNode, "Yaml should contain a 'stages' map that has stage names as keys")}
 	}
 
-	for i, stage := range stagesMapNode.Content {
-		if i%2 == 0 {
-			if stage.Kind != yaml.ScalarNode {
-				return nil, errors.New("Yaml should contain stage keys in 'staging' map")
-			}
-			stages = append(stages, stage.Value)
-		} else {
-			if stage.Kind != yaml.SequenceNode {
-				return nil, errors.New("Yaml should contain stage sequences in 'staging' map")
-			}
+	for i := 0; i < len(stagesMapNode.Content); i += 2 {
+		stage := stagesMapNode.Content[i]
+		if stage.Kind != yaml.ScalarNode {
+			return nil, ConfigErrors{newConfigError(stage, "Yaml should contain stage keys in 'stages' map")}
 		}
+		stages = append(stages, stage.Value)
 	}
 
 	return stages, nil
@@ -3,6 +3,7 @@ package dockerfilegenerator
 import (
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v3"
+	"strings"
 	"testing"
 )
 
@@ -16,3 +17,93 @@ func TestGetStagesOrderFromYamlNode(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, stages, []string{"builder", "final"})
 }
+
+func TestConfigErrors(t *testing.T) {
+	_, err := NewDockerFileDataFromYamlFile("./example-input-files/invalid-instructions.yaml")
+	assert.Error(t, err)
+
+	errs, ok := err.(ConfigErrors)
+	assert.True(t, ok)
+	assert.Len(t, errs, 3)
+
+	assert.Equal(t, &ConfigError{
+		Filename:    "./example-input-files/invalid-instructions.yaml",
+		Line:        6,
+		Column:      7,
+		Stage:       "builder",
+		Instruction: 1,
+		Reason:      `Unknown instruction "rn"`,
+	}, errs[0])
+	assert.Equal(t, "./example-input-files/invalid-instructions.yaml:15:7: stages.final[1]: Failed to parse copy instruction sources: the field is missing", errs[1].Error())
+	assert.Equal(t, "./example-input-files/invalid-instructions.yaml:17:7: stages.final[2]: Failed to parse cmd instruction params: expected a list, got ./app", errs[2].Error())
+}
+
+func TestConfigErrorsSyntax(t *testing.T) {
+	node := yaml.Node{}
+	err := yaml.Unmarshal([]byte("stages:\n  final:\n    - from: {image: alpine\n"), &node)
+	assert.Error(t, err)
+
+	configErr := newConfigErrorFromYaml(err)
+	configErr.Filename = "Dockerfile.yaml"
+	assert.Equal(t, 3, configErr.Line)
+	assert.Equal(t, "Dockerfile.yaml:3: did not find expected ',' or '}'", configErr.Error())
+}
+
+func TestConfigErrorsUnknownKeys(t *testing.T) {
+	_, err := NewDockerFileDataFromYamlReader(strings.NewReader(`stages:
+  final:
+    - from: {image: alpine}
+    - copy: {sources: [a], destinaton: /x}
+    - run:
+        params: [make]
+        mounts: [{type: cache, targt: /root/.cache}]
+    - healthCheck: {params: [curl, localhost], intervl: 5s}
+    - onbuild: {copy: {sources: [a], destination: /x, chmd: "644"}}
+    - COPY: {sources: [a], destination: /x}
+`), "")
+	assert.EqualError(t, err, `4:28: stages.final[1]: Unknown copy key "destinaton", expected sources, destination, chown, chmod, from, link, parents, exclude, content, delimiter, scriptForm or comment
+7:32: stages.final[2]: Unknown mount key "targt", expected type, id, target, source, from, sharing, mode, uid, gid, size, rw or required
+8:48: stages.final[3]: Unknown healthCheck key "intervl", expected params, runForm, interval, timeout, startPeriod, startInterval, retries, none or comment
+9:55: stages.final[4]: Unknown copy key "chmd", expected sources, destination, chown, chmod, from, link, parents, exclude, content, delimiter, scriptForm or comment`)
+}
+
+func TestStageUnmarshalYAML(t *testing.T) {
+	var stage Stage
+	err := yaml.Unmarshal([]byte("- arg:\n    name: version\n    value: 1.0\n- user: 1000\n"), &stage)
+	assert.NoError(t, err)
+	assert.Equal(t, Stage{Instructions: []Instruction{Arg{Name: "version", Value: "1.0"}, User{User: "1000"}}}, stage)
+
+	err = yaml.Unmarshal([]byte("- from:\n    image: alpine\n- unknown: {}\n"), &stage)
+	assert.EqualError(t, err, `3:3: Unknown instruction "unknown"`)
+}
+
+func TestStageUnmarshalYAMLMap(t *testing.T) {
+	var stage Stage
+	err := yaml.Unmarshal([]byte("platform: linux/arm64\ndependsOn: [builder]\ninstructions:\n  - from:\n      image: alpine\n"), &stage)
+	assert.NoError(t, err)
+	assert.Equal(t, Stage{Platform: "linux/arm64", DependsOn: []string{"builder"}, Instructions: []Instruction{From{Image: "alpine"}}}, stage)
+
+	err = yaml.Unmarshal([]byte("platform: [a]\ndependsOn: builder\nunknown: 1\n"), &stage)
+	assert.EqualError(t, err, `1:11: Stage platform should be a string
+2:12: Failed to parse stage dependsOn: expected a list, got builder
+3:1: Unknown stage key "unknown"
+1:1: Stage should contain an 'instructions' key`)
+}
+
+func TestStageUnmarshalYAMLMergeKeys(t *testing.T) {
+	var stage Stage
+	err := yaml.Unmarshal([]byte(`- run: {<<: {params: [echo, hi]}}
+- envVariable: &env {name: APP_ENV, value: prod}
+- envVariable: {<<: *env, value: dev}
+- label: {<<: [{name: a}, {name: b, value: "2"}]}
+`), &stage)
+	assert.NoError(t, err)
+	assert.Equal(t, Stage{Instructions: []Instruction{
+		RunCommand{Params: []string{"echo", "hi"}, RunForm: ShellForm},
+		EnvVariable{Name: "APP_ENV", Value: "prod"},
+		EnvVariable{Name: "APP_ENV", Value: "dev"},
+		Label{Name: "a", Value: "2"},
+	}}, stage)
+
+	assert.Equal(t, "RUN echo hi", stage.Instructions[0].Render())
+}